@@ -0,0 +1,254 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CookieName is the cookie a session's token is kept in.
+const CookieName = "mailfeed_session"
+
+// DefaultSessionLifetime is how long a session lasts if Options doesn't say.
+const DefaultSessionLifetime = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrInvalidPassword    = errors.New("passwords must be between 8 and 72 bytes")
+	ErrEmailTaken         = errors.New("email address is already registered")
+	ErrInvalidCredentials = errors.New("wrong email address or password")
+)
+
+// Options configure accounts.
+type Options struct {
+	// SessionLifetime is how long someone stays signed in, DefaultSessionLifetime if zero.
+	SessionLifetime time.Duration
+}
+
+// Accounts signs people up and in, and works out who requests are from.
+type Accounts struct {
+	logger   *zap.Logger
+	db       *database.Database
+	lifetime time.Duration
+}
+
+func New(logger *zap.Logger, db *database.Database, options Options) *Accounts {
+	if options.SessionLifetime <= 0 {
+		options.SessionLifetime = DefaultSessionLifetime
+	}
+
+	return &Accounts{
+		logger:   logger,
+		db:       db,
+		lifetime: options.SessionLifetime,
+	}
+}
+
+// Register creates an account, storing a bcrypt hash of its password.
+func (a *Accounts) Register(ctx context.Context, email string, password string) (sqlc.Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return sqlc.Account{}, err
+	}
+
+	// bcrypt ignores anything past 72 bytes
+	if len(password) < 8 || len(password) > 72 {
+		return sqlc.Account{}, ErrInvalidPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return sqlc.Account{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var account sqlc.Account
+	err = a.db.InTx(ctx, func(q *sqlc.Queries) error {
+		_, err := q.GetAccountByEmail(ctx, email)
+		if err == nil {
+			return ErrEmailTaken
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get account: %w", err)
+		}
+
+		account, err = q.CreateAccount(ctx, sqlc.CreateAccountParams{
+			Email:        email,
+			PasswordHash: string(hash),
+			Created:      time.Now().UTC().Format("2006-01-02 15:04:05"),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create account: %w", err)
+		}
+
+		return nil
+	})
+
+	return account, err
+}
+
+// dummyHash is compared against when nobody has an email address, so signing in
+// takes as long whether or not the address is registered.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("mailfeed"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Authenticate returns the account with email if password is its password.
+func (a *Accounts) Authenticate(ctx context.Context, email string, password string) (sqlc.Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return sqlc.Account{}, ErrInvalidCredentials
+	}
+
+	account, err := a.db.GetAccountByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return sqlc.Account{}, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return sqlc.Account{}, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)); err != nil {
+		return sqlc.Account{}, ErrInvalidCredentials
+	}
+
+	return account, nil
+}
+
+// StartSession signs account in, setting the session cookie on w.
+func (a *Accounts) StartSession(ctx context.Context, w http.ResponseWriter, account sqlc.Account) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate session: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now().UTC()
+	expires := now.Add(a.lifetime)
+
+	if err := a.db.DeleteExpiredSessions(ctx, now.Format("2006-01-02 15:04:05")); err != nil {
+		a.logger.Warn("failed to delete expired sessions", zap.Error(err))
+	}
+
+	// only the token's hash is stored, so the database can't be used to sign in
+	err := a.db.CreateSession(ctx, sqlc.CreateSessionParams{
+		ID:        hashSession(token),
+		AccountID: account.ID,
+		Expires:   expires.Format("2006-01-02 15:04:05"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(a.lifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		// Cookies aren't sent with cross-site form posts, which keeps other sites from
+		// managing feeds on someone's behalf.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// EndSession signs the request's account out, clearing the session cookie.
+func (a *Accounts) EndSession(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		return nil
+	}
+
+	if err := a.db.DeleteSession(ctx, hashSession(cookie.Value)); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return nil
+}
+
+// Middleware adds the account a request's session belongs to, if any, to its context.
+func (a *Accounts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(CookieName)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		account, err := a.db.GetSessionAccount(r.Context(), sqlc.GetSessionAccountParams{
+			ID:      hashSession(cookie.Value),
+			Expires: time.Now().UTC().Format("2006-01-02 15:04:05"),
+		})
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				a.logger.Error("failed to get session", zap.Error(err))
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), account)))
+	})
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying account, as Middleware adds to requests.
+func NewContext(ctx context.Context, account sqlc.Account) context.Context {
+	return context.WithValue(ctx, contextKey{}, account)
+}
+
+// FromContext returns the account a request is from, if it's signed in.
+func FromContext(ctx context.Context) (sqlc.Account, bool) {
+	account, ok := ctx.Value(contextKey{}).(sqlc.Account)
+	return account, ok
+}
+
+// normalizeEmail returns a bare, lower case address, so each address has one account.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}
+
+func hashSession(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
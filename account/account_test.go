@@ -0,0 +1,117 @@
+package account
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newAccounts(t *testing.T) (*database.Database, *Accounts) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	return &db, New(logger, &db, Options{})
+}
+
+func TestRegister(t *testing.T) {
+	db, accounts := newAccounts(t)
+	ctx := context.Background()
+
+	created, err := accounts.Register(ctx, " Reader@Example.com", "correct horse")
+	require.NoError(t, err)
+	require.Equal(t, "reader@example.com", created.Email)
+
+	stored, err := db.GetAccount(ctx, created.ID)
+	require.NoError(t, err)
+	require.NotContains(t, stored.PasswordHash, "correct horse")
+
+	_, err = accounts.Register(ctx, "reader@example.com", "another password")
+	require.ErrorIs(t, err, ErrEmailTaken)
+
+	_, err = accounts.Register(ctx, "Reader <other@example.com>", "correct horse")
+	require.ErrorIs(t, err, ErrInvalidEmail)
+
+	_, err = accounts.Register(ctx, "other@example.com", "short")
+	require.ErrorIs(t, err, ErrInvalidPassword)
+
+	signedIn, err := accounts.Authenticate(ctx, "READER@example.com", "correct horse")
+	require.NoError(t, err)
+	require.Equal(t, created.ID, signedIn.ID)
+
+	_, err = accounts.Authenticate(ctx, "reader@example.com", "wrong horse")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = accounts.Authenticate(ctx, "nobody@example.com", "correct horse")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestSessions(t *testing.T) {
+	_, accounts := newAccounts(t)
+
+	r := http.NewServeMux()
+	r.HandleFunc("/signup", accounts.SignUp)
+	r.HandleFunc("/signin", accounts.SignIn)
+	r.HandleFunc("/signout", accounts.SignOut)
+	r.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		if signedIn, ok := FromContext(r.Context()); ok {
+			_, _ = w.Write([]byte(signedIn.Email))
+		}
+	})
+	handler := accounts.Middleware(r)
+
+	post := func(path string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	whoami := func(cookie *http.Cookie) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/whoami", nil)
+		r.AddCookie(cookie)
+		handler.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	w := post("/signup", url.Values{"email": {"reader@example.com"}, "password": {"correct horse"}}, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	require.Equal(t, "/dashboard", w.Header().Get("Location"))
+
+	cookie := w.Result().Cookies()[0]
+	require.Equal(t, CookieName, cookie.Name)
+	require.True(t, cookie.HttpOnly)
+	require.True(t, cookie.Secure)
+	require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	require.Equal(t, "reader@example.com", whoami(cookie))
+
+	w = post("/signin", url.Values{"email": {"reader@example.com"}, "password": {"wrong horse"}}, nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), ErrInvalidCredentials.Error())
+
+	w = post("/signin", url.Values{"email": {"reader@example.com"}, "password": {"correct horse"}}, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	second := w.Result().Cookies()[0]
+	require.NotEqual(t, cookie.Value, second.Value)
+
+	// signing out ends only that session
+	w = post("/signout", nil, cookie)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	require.Empty(t, whoami(cookie))
+	require.Equal(t, "reader@example.com", whoami(second))
+
+	require.Empty(t, whoami(&http.Cookie{Name: CookieName, Value: "forged"}))
+}
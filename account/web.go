@@ -0,0 +1,110 @@
+package account
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/internal/website"
+	"go.uber.org/zap"
+)
+
+// Shows the sign up form.
+func (a *Accounts) SignUpPage(w http.ResponseWriter, r *http.Request) {
+	a.render(w, http.StatusOK, page{SignUp: true})
+}
+
+// Creates an account from the sign up form and signs it in.
+func (a *Accounts) SignUp(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	account, err := a.Register(r.Context(), email, r.PostForm.Get("password"))
+	if errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrEmailTaken) {
+		a.render(w, http.StatusBadRequest, page{SignUp: true, Email: email, Error: err.Error()})
+		return
+	}
+
+	if err != nil {
+		a.logger.Error("failed to register", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	a.signIn(w, r, account)
+}
+
+// Shows the sign in form.
+func (a *Accounts) SignInPage(w http.ResponseWriter, r *http.Request) {
+	a.render(w, http.StatusOK, page{})
+}
+
+// Signs in with the sign in form's email address and password.
+func (a *Accounts) SignIn(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	account, err := a.Authenticate(r.Context(), email, r.PostForm.Get("password"))
+	if errors.Is(err, ErrInvalidCredentials) {
+		a.render(w, http.StatusUnauthorized, page{Email: email, Error: err.Error()})
+		return
+	}
+
+	if err != nil {
+		a.logger.Error("failed to authenticate", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	a.signIn(w, r, account)
+}
+
+// Signs out, then goes back to the home page.
+func (a *Accounts) SignOut(w http.ResponseWriter, r *http.Request) {
+	if err := a.EndSession(r.Context(), w, r); err != nil {
+		a.logger.Error("failed to end session", zap.Error(err))
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// signIn starts a session for account, then goes to its dashboard.
+func (a *Accounts) signIn(w http.ResponseWriter, r *http.Request, account sqlc.Account) {
+	if err := a.StartSession(r.Context(), w, account); err != nil {
+		a.logger.Error("failed to start session", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// page is what templates/account.html renders.
+type page struct {
+	// SignUp shows the sign up form rather than the sign in form.
+	SignUp bool
+	Email  string
+	Error  string
+}
+
+func (a *Accounts) render(w http.ResponseWriter, status int, data page) {
+	tmpl, err := template.ParseFS(website.Templates, "templates/account.html")
+	if err != nil {
+		a.logger.Error("failed to parse template", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		a.logger.Error("failed to execute template", zap.Error(err))
+	}
+}
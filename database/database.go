@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/alex-emery/mailfeed/database/sqlc"
 	msql "github.com/alex-emery/mailfeed/sql"
@@ -17,21 +19,56 @@ import (
 
 type Database struct {
 	*sqlc.Queries
+	db *sql.DB
 }
 
 func New(logger *zap.Logger, filepath string) (Database, error) {
-	db, err := sql.Open("sqlite", filepath)
+	// SQLite only enforces foreign keys, and so cascades deletes, on connections which ask.
+	dsn := filepath + "?_pragma=foreign_keys(1)"
+	if strings.Contains(filepath, "?") {
+		dsn = filepath + "&_pragma=foreign_keys(1)"
+	}
+
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return Database{}, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	// Every connection to an in-memory database gets its own, empty database.
+	if filepath == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+
 	if err := Migrate(logger, db); err != nil {
 		return Database{}, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	queries := sqlc.New(db)
 
-	return Database{Queries: queries}, nil
+	return Database{Queries: queries, db: db}, nil
+}
+
+// InTx runs fn in a transaction, which is committed if fn returns nil and rolled back otherwise.
+// fn must only use the queries it is given, as an in-memory database has a single connection.
+func (d *Database) InTx(ctx context.Context, fn func(q *sqlc.Queries) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(d.Queries.WithTx(tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("failed to roll back transaction: %v (after %w)", rbErr, err)
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
 func Migrate(logger *zap.Logger, db *sql.DB) error {
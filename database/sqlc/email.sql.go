@@ -7,39 +7,61 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
 )
 
 const createEmail = `-- name: CreateEmail :one
 INSERT INTO
     email (
-        id,
         date,
         recipient,
         sender,
         subject,
-        description
+        description,
+        uid,
+        undeliverable,
+        raw,
+        message_id,
+        dkim,
+        dkim_aligned,
+        spf,
+        dmarc
     )
 VALUES
-    (?, ?, ?, ?, ?, ?) RETURNING id, date, recipient, sender, subject, description
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, date, recipient, sender, subject, description, uid, undeliverable, raw, message_id, dkim, dkim_aligned, spf, dmarc
 `
 
 type CreateEmailParams struct {
-	ID          int64
-	Date        string
-	Recipient   string
-	Sender      string
-	Subject     string
-	Description string
+	Date          string
+	Recipient     string
+	Sender        string
+	Subject       string
+	Description   string
+	Uid           sql.NullInt64
+	Undeliverable bool
+	Raw           []byte
+	MessageID     string
+	Dkim          string
+	DkimAligned   bool
+	Spf           string
+	Dmarc         string
 }
 
 func (q *Queries) CreateEmail(ctx context.Context, arg CreateEmailParams) (Email, error) {
 	row := q.db.QueryRowContext(ctx, createEmail,
-		arg.ID,
 		arg.Date,
 		arg.Recipient,
 		arg.Sender,
 		arg.Subject,
 		arg.Description,
+		arg.Uid,
+		arg.Undeliverable,
+		arg.Raw,
+		arg.MessageID,
+		arg.Dkim,
+		arg.DkimAligned,
+		arg.Spf,
+		arg.Dmarc,
 	)
 	var i Email
 	err := row.Scan(
@@ -49,13 +71,49 @@ func (q *Queries) CreateEmail(ctx context.Context, arg CreateEmailParams) (Email
 		&i.Sender,
 		&i.Subject,
 		&i.Description,
+		&i.Uid,
+		&i.Undeliverable,
+		&i.Raw,
+		&i.MessageID,
+		&i.Dkim,
+		&i.DkimAligned,
+		&i.Spf,
+		&i.Dmarc,
 	)
 	return i, err
 }
 
+const deleteUnusedEmail = `-- name: DeleteUnusedEmail :exec
+DELETE FROM
+    email
+WHERE
+    id = ?
+    AND NOT EXISTS (
+        SELECT
+            1
+        FROM
+            feed_item
+        WHERE
+            feed_item.email_id = email.id
+    )
+    AND NOT EXISTS (
+        SELECT
+            1
+        FROM
+            quarantine
+        WHERE
+            quarantine.email_id = email.id
+    )
+`
+
+func (q *Queries) DeleteUnusedEmail(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUnusedEmail, id)
+	return err
+}
+
 const getEmail = `-- name: GetEmail :one
 SELECT
-    id, date, recipient, sender, subject, description
+    id, date, recipient, sender, subject, description, uid, undeliverable, raw, message_id, dkim, dkim_aligned, spf, dmarc
 FROM
     email
 WHERE
@@ -74,13 +132,40 @@ func (q *Queries) GetEmail(ctx context.Context, id int64) (Email, error) {
 		&i.Sender,
 		&i.Subject,
 		&i.Description,
+		&i.Uid,
+		&i.Undeliverable,
+		&i.Raw,
+		&i.MessageID,
+		&i.Dkim,
+		&i.DkimAligned,
+		&i.Spf,
+		&i.Dmarc,
 	)
 	return i, err
 }
 
+const hasEmailWithMessageID = `-- name: HasEmailWithMessageID :one
+SELECT
+    EXISTS (
+        SELECT
+            1
+        FROM
+            email
+        WHERE
+            message_id = ?
+    )
+`
+
+func (q *Queries) HasEmailWithMessageID(ctx context.Context, messageID string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, hasEmailWithMessageID, messageID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
 const listEmails = `-- name: ListEmails :many
 SELECT
-    id, date, recipient, sender, subject, description
+    id, date, recipient, sender, subject, description, uid, undeliverable, raw, message_id, dkim, dkim_aligned, spf, dmarc
 FROM
     email
 ORDER BY
@@ -103,6 +188,14 @@ func (q *Queries) ListEmails(ctx context.Context) ([]Email, error) {
 			&i.Sender,
 			&i.Subject,
 			&i.Description,
+			&i.Uid,
+			&i.Undeliverable,
+			&i.Raw,
+			&i.MessageID,
+			&i.Dkim,
+			&i.DkimAligned,
+			&i.Spf,
+			&i.Dmarc,
 		); err != nil {
 			return nil, err
 		}
@@ -116,3 +209,128 @@ func (q *Queries) ListEmails(ctx context.Context) ([]Email, error) {
 	}
 	return items, nil
 }
+
+const listRawEmails = `-- name: ListRawEmails :many
+SELECT
+    id, date, recipient, sender, subject, description, uid, undeliverable, raw, message_id, dkim, dkim_aligned, spf, dmarc
+FROM
+    email
+WHERE
+    raw IS NOT NULL
+ORDER BY
+    id
+`
+
+func (q *Queries) ListRawEmails(ctx context.Context) ([]Email, error) {
+	rows, err := q.db.QueryContext(ctx, listRawEmails)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Email
+	for rows.Next() {
+		var i Email
+		if err := rows.Scan(
+			&i.ID,
+			&i.Date,
+			&i.Recipient,
+			&i.Sender,
+			&i.Subject,
+			&i.Description,
+			&i.Uid,
+			&i.Undeliverable,
+			&i.Raw,
+			&i.MessageID,
+			&i.Dkim,
+			&i.DkimAligned,
+			&i.Spf,
+			&i.Dmarc,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRawEmailsForFeed = `-- name: ListRawEmailsForFeed :many
+SELECT
+    id, date, recipient, sender, subject, description, uid, undeliverable, raw, message_id, dkim, dkim_aligned, spf, dmarc
+FROM
+    email
+WHERE
+    raw IS NOT NULL
+    AND id IN (
+        SELECT
+            email_id
+        FROM
+            feed_item
+        WHERE
+            feed_id = ?
+    )
+ORDER BY
+    id
+`
+
+func (q *Queries) ListRawEmailsForFeed(ctx context.Context, feedID string) ([]Email, error) {
+	rows, err := q.db.QueryContext(ctx, listRawEmailsForFeed, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Email
+	for rows.Next() {
+		var i Email
+		if err := rows.Scan(
+			&i.ID,
+			&i.Date,
+			&i.Recipient,
+			&i.Sender,
+			&i.Subject,
+			&i.Description,
+			&i.Uid,
+			&i.Undeliverable,
+			&i.Raw,
+			&i.MessageID,
+			&i.Dkim,
+			&i.DkimAligned,
+			&i.Spf,
+			&i.Dmarc,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateEmailDescription = `-- name: UpdateEmailDescription :exec
+UPDATE
+    email
+SET
+    description = ?
+WHERE
+    id = ?
+`
+
+type UpdateEmailDescriptionParams struct {
+	Description string
+	ID          int64
+}
+
+func (q *Queries) UpdateEmailDescription(ctx context.Context, arg UpdateEmailDescriptionParams) error {
+	_, err := q.db.ExecContext(ctx, updateEmailDescription, arg.Description, arg.ID)
+	return err
+}
@@ -7,30 +7,68 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
 )
 
 const createFeed = `-- name: CreateFeed :one
 INSERT into
-    feed (id, name)
+    feed (id, name, token_hash, private, read_token_hash, account_id)
 VALUES
-    (?, ?) RETURNING id, name
+    (?, ?, ?, ?, ?, ?) RETURNING id, name, address, token_hash, private, read_token_hash, account_id, locked, sender_limit, require_dkim
 `
 
 type CreateFeedParams struct {
-	ID   string
-	Name string
+	ID            string
+	Name          string
+	TokenHash     string
+	Private       bool
+	ReadTokenHash string
+	AccountID     sql.NullInt64
 }
 
 func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, error) {
-	row := q.db.QueryRowContext(ctx, createFeed, arg.ID, arg.Name)
+	row := q.db.QueryRowContext(ctx, createFeed,
+		arg.ID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Private,
+		arg.ReadTokenHash,
+		arg.AccountID,
+	)
 	var i Feed
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Address,
+		&i.TokenHash,
+		&i.Private,
+		&i.ReadTokenHash,
+		&i.AccountID,
+		&i.Locked,
+		&i.SenderLimit,
+		&i.RequireDkim,
+	)
 	return i, err
 }
 
+const deleteFeed = `-- name: DeleteFeed :execrows
+DELETE FROM
+    feed
+WHERE
+    id = ?
+`
+
+func (q *Queries) DeleteFeed(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFeed, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getFeed = `-- name: GetFeed :one
 SELECT
-    id, name
+    id, name, address, token_hash, private, read_token_hash, account_id, locked, sender_limit, require_dkim
 FROM
     feed 
 where
@@ -42,13 +80,234 @@ limit
 func (q *Queries) GetFeed(ctx context.Context, id string) (Feed, error) {
 	row := q.db.QueryRowContext(ctx, getFeed, id)
 	var i Feed
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Address,
+		&i.TokenHash,
+		&i.Private,
+		&i.ReadTokenHash,
+		&i.AccountID,
+		&i.Locked,
+		&i.SenderLimit,
+		&i.RequireDkim,
+	)
+	return i, err
+}
+
+const getFeedByAddress = `-- name: GetFeedByAddress :one
+SELECT
+    id, name, address, token_hash, private, read_token_hash, account_id, locked, sender_limit, require_dkim
+FROM
+    feed
+WHERE
+    coalesce(nullif(address, ''), id) = ?
+LIMIT
+    1
+`
+
+func (q *Queries) GetFeedByAddress(ctx context.Context, address string) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedByAddress, address)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Address,
+		&i.TokenHash,
+		&i.Private,
+		&i.ReadTokenHash,
+		&i.AccountID,
+		&i.Locked,
+		&i.SenderLimit,
+		&i.RequireDkim,
+	)
+	return i, err
+}
+
+const getFeedSummary = `-- name: GetFeedSummary :one
+SELECT
+    feed.id,
+    feed.name,
+    coalesce(nullif(feed.address, ''), feed.id) AS address,
+    feed.private,
+    feed.locked,
+    feed.sender_limit,
+    feed.require_dkim,
+    count(feed_item.id) AS item_count,
+    max(feed_item.date) AS last_received
+FROM
+    feed
+    LEFT JOIN feed_item ON feed_item.feed_id = feed.id
+WHERE
+    feed.id = ?
+GROUP BY
+    feed.id
+`
+
+type GetFeedSummaryRow struct {
+	ID           string
+	Name         string
+	Address      string
+	Private      bool
+	Locked       bool
+	SenderLimit  int64
+	RequireDkim  bool
+	ItemCount    int64
+	LastReceived sql.NullString
+}
+
+func (q *Queries) GetFeedSummary(ctx context.Context, id string) (GetFeedSummaryRow, error) {
+	row := q.db.QueryRowContext(ctx, getFeedSummary, id)
+	var i GetFeedSummaryRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Address,
+		&i.Private,
+		&i.Locked,
+		&i.SenderLimit,
+		&i.RequireDkim,
+		&i.ItemCount,
+		&i.LastReceived,
+	)
 	return i, err
 }
 
+const listAccountFeedSummaries = `-- name: ListAccountFeedSummaries :many
+SELECT
+    feed.id,
+    feed.name,
+    coalesce(nullif(feed.address, ''), feed.id) AS address,
+    feed.private,
+    feed.locked,
+    feed.sender_limit,
+    feed.require_dkim,
+    count(feed_item.id) AS item_count,
+    max(feed_item.date) AS last_received
+FROM
+    feed
+    LEFT JOIN feed_item ON feed_item.feed_id = feed.id
+WHERE
+    feed.account_id = ?
+GROUP BY
+    feed.id
+ORDER BY
+    feed.rowid
+`
+
+type ListAccountFeedSummariesRow struct {
+	ID           string
+	Name         string
+	Address      string
+	Private      bool
+	Locked       bool
+	SenderLimit  int64
+	RequireDkim  bool
+	ItemCount    int64
+	LastReceived sql.NullString
+}
+
+func (q *Queries) ListAccountFeedSummaries(ctx context.Context, accountID sql.NullInt64) ([]ListAccountFeedSummariesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAccountFeedSummaries, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAccountFeedSummariesRow
+	for rows.Next() {
+		var i ListAccountFeedSummariesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Address,
+			&i.Private,
+			&i.Locked,
+			&i.SenderLimit,
+			&i.RequireDkim,
+			&i.ItemCount,
+			&i.LastReceived,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFeedSummaries = `-- name: ListFeedSummaries :many
+SELECT
+    feed.id,
+    feed.name,
+    coalesce(nullif(feed.address, ''), feed.id) AS address,
+    feed.private,
+    feed.locked,
+    feed.sender_limit,
+    feed.require_dkim,
+    count(feed_item.id) AS item_count,
+    max(feed_item.date) AS last_received
+FROM
+    feed
+    LEFT JOIN feed_item ON feed_item.feed_id = feed.id
+GROUP BY
+    feed.id
+ORDER BY
+    feed.rowid
+`
+
+type ListFeedSummariesRow struct {
+	ID           string
+	Name         string
+	Address      string
+	Private      bool
+	Locked       bool
+	SenderLimit  int64
+	RequireDkim  bool
+	ItemCount    int64
+	LastReceived sql.NullString
+}
+
+func (q *Queries) ListFeedSummaries(ctx context.Context) ([]ListFeedSummariesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFeedSummaries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFeedSummariesRow
+	for rows.Next() {
+		var i ListFeedSummariesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Address,
+			&i.Private,
+			&i.Locked,
+			&i.SenderLimit,
+			&i.RequireDkim,
+			&i.ItemCount,
+			&i.LastReceived,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listFeeds = `-- name: ListFeeds :many
 SELECT
-    id, name
+    id, name, address, token_hash, private, read_token_hash, account_id, locked, sender_limit, require_dkim
 FROM
     feed
 `
@@ -62,7 +321,18 @@ func (q *Queries) ListFeeds(ctx context.Context) ([]Feed, error) {
 	var items []Feed
 	for rows.Next() {
 		var i Feed
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Address,
+			&i.TokenHash,
+			&i.Private,
+			&i.ReadTokenHash,
+			&i.AccountID,
+			&i.Locked,
+			&i.SenderLimit,
+			&i.RequireDkim,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -75,3 +345,124 @@ func (q *Queries) ListFeeds(ctx context.Context) ([]Feed, error) {
 	}
 	return items, nil
 }
+
+const renameFeed = `-- name: RenameFeed :execrows
+UPDATE
+    feed
+SET
+    name = ?
+WHERE
+    id = ?
+`
+
+type RenameFeedParams struct {
+	Name string
+	ID   string
+}
+
+func (q *Queries) RenameFeed(ctx context.Context, arg RenameFeedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, renameFeed, arg.Name, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setFeedAccount = `-- name: SetFeedAccount :exec
+UPDATE
+    feed
+SET
+    account_id = ?
+WHERE
+    id = ?
+`
+
+type SetFeedAccountParams struct {
+	AccountID sql.NullInt64
+	ID        string
+}
+
+func (q *Queries) SetFeedAccount(ctx context.Context, arg SetFeedAccountParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedAccount, arg.AccountID, arg.ID)
+	return err
+}
+
+const setFeedLock = `-- name: SetFeedLock :exec
+UPDATE
+    feed
+SET
+    locked = ?,
+    sender_limit = ?
+WHERE
+    id = ?
+`
+
+type SetFeedLockParams struct {
+	Locked      bool
+	SenderLimit int64
+	ID          string
+}
+
+func (q *Queries) SetFeedLock(ctx context.Context, arg SetFeedLockParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedLock, arg.Locked, arg.SenderLimit, arg.ID)
+	return err
+}
+
+const setFeedRequireDKIM = `-- name: SetFeedRequireDKIM :exec
+UPDATE
+    feed
+SET
+    require_dkim = ?
+WHERE
+    id = ?
+`
+
+type SetFeedRequireDKIMParams struct {
+	RequireDkim bool
+	ID          string
+}
+
+func (q *Queries) SetFeedRequireDKIM(ctx context.Context, arg SetFeedRequireDKIMParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedRequireDKIM, arg.RequireDkim, arg.ID)
+	return err
+}
+
+const updateFeedAddress = `-- name: UpdateFeedAddress :exec
+UPDATE
+    feed
+SET
+    address = ?
+WHERE
+    id = ?
+`
+
+type UpdateFeedAddressParams struct {
+	Address string
+	ID      string
+}
+
+func (q *Queries) UpdateFeedAddress(ctx context.Context, arg UpdateFeedAddressParams) error {
+	_, err := q.db.ExecContext(ctx, updateFeedAddress, arg.Address, arg.ID)
+	return err
+}
+
+const updateFeedPrivacy = `-- name: UpdateFeedPrivacy :exec
+UPDATE
+    feed
+SET
+    private = ?,
+    read_token_hash = ?
+WHERE
+    id = ?
+`
+
+type UpdateFeedPrivacyParams struct {
+	Private       bool
+	ReadTokenHash string
+	ID            string
+}
+
+func (q *Queries) UpdateFeedPrivacy(ctx context.Context, arg UpdateFeedPrivacyParams) error {
+	_, err := q.db.ExecContext(ctx, updateFeedPrivacy, arg.Private, arg.ReadTokenHash, arg.ID)
+	return err
+}
@@ -7,8 +7,23 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
 )
 
+const confirmFeedItemsForEmail = `-- name: ConfirmFeedItemsForEmail :exec
+UPDATE
+    feed_item
+SET
+    confirmed = TRUE
+WHERE
+    email_id = ?
+`
+
+func (q *Queries) ConfirmFeedItemsForEmail(ctx context.Context, emailID sql.NullInt64) error {
+	_, err := q.db.ExecContext(ctx, confirmFeedItemsForEmail, emailID)
+	return err
+}
+
 const createFeedItem = `-- name: CreateFeedItem :one
 INSERT into
     feed_item(
@@ -17,19 +32,30 @@ INSERT into
         feed_id,
         subject,
         body,
-        date
+        date,
+        category,
+        email_id,
+        message_id,
+        confirm_url,
+        renamed
         )
 VALUES
-    (?, ?, ?,?,?,?) RETURNING id, name, feed_id, subject, body, date
+    (?, ?, ?,?,?,?,?,?,?,?,?)
+ON CONFLICT (feed_id, message_id) WHERE message_id != '' DO NOTHING RETURNING id, name, feed_id, subject, body, date, category, email_id, message_id, confirm_url, confirmed, renamed
 `
 
 type CreateFeedItemParams struct {
-	ID      string
-	Name    string
-	FeedID  string
-	Subject string
-	Body    string
-	Date    string
+	ID         string
+	Name       string
+	FeedID     string
+	Subject    string
+	Body       string
+	Date       string
+	Category   string
+	EmailID    sql.NullInt64
+	MessageID  string
+	ConfirmUrl string
+	Renamed    bool
 }
 
 func (q *Queries) CreateFeedItem(ctx context.Context, arg CreateFeedItemParams) (FeedItem, error) {
@@ -40,6 +66,11 @@ func (q *Queries) CreateFeedItem(ctx context.Context, arg CreateFeedItemParams)
 		arg.Subject,
 		arg.Body,
 		arg.Date,
+		arg.Category,
+		arg.EmailID,
+		arg.MessageID,
+		arg.ConfirmUrl,
+		arg.Renamed,
 	)
 	var i FeedItem
 	err := row.Scan(
@@ -49,13 +80,19 @@ func (q *Queries) CreateFeedItem(ctx context.Context, arg CreateFeedItemParams)
 		&i.Subject,
 		&i.Body,
 		&i.Date,
+		&i.Category,
+		&i.EmailID,
+		&i.MessageID,
+		&i.ConfirmUrl,
+		&i.Confirmed,
+		&i.Renamed,
 	)
 	return i, err
 }
 
 const getFeedItem = `-- name: GetFeedItem :one
 SELECT
-    id, name, feed_id, subject, body, date
+    id, name, feed_id, subject, body, date, category, email_id, message_id, confirm_url, confirmed, renamed
 FROM
     feed_item 
 where
@@ -74,13 +111,59 @@ func (q *Queries) GetFeedItem(ctx context.Context, id string) (FeedItem, error)
 		&i.Subject,
 		&i.Body,
 		&i.Date,
+		&i.Category,
+		&i.EmailID,
+		&i.MessageID,
+		&i.ConfirmUrl,
+		&i.Confirmed,
+		&i.Renamed,
 	)
 	return i, err
 }
 
+const listFeedEmailIDs = `-- name: ListFeedEmailIDs :many
+SELECT
+    email_id
+FROM
+    feed_item
+WHERE
+    feed_id = ?1
+    AND email_id IS NOT NULL
+UNION
+SELECT
+    email_id
+FROM
+    quarantine
+WHERE
+    feed_id = ?1
+`
+
+func (q *Queries) ListFeedEmailIDs(ctx context.Context, feedID string) ([]sql.NullInt64, error) {
+	rows, err := q.db.QueryContext(ctx, listFeedEmailIDs, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sql.NullInt64
+	for rows.Next() {
+		var email_id sql.NullInt64
+		if err := rows.Scan(&email_id); err != nil {
+			return nil, err
+		}
+		items = append(items, email_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listFeedItems = `-- name: ListFeedItems :many
 SELECT
-    id, name, feed_id, subject, body, date
+    id, name, feed_id, subject, body, date, category, email_id, message_id, confirm_url, confirmed, renamed
 FROM
     feed_item
 WHERE
@@ -103,6 +186,12 @@ func (q *Queries) ListFeedItems(ctx context.Context, feedID string) ([]FeedItem,
 			&i.Subject,
 			&i.Body,
 			&i.Date,
+			&i.Category,
+			&i.EmailID,
+			&i.MessageID,
+			&i.ConfirmUrl,
+			&i.Confirmed,
+			&i.Renamed,
 		); err != nil {
 			return nil, err
 		}
@@ -116,3 +205,92 @@ func (q *Queries) ListFeedItems(ctx context.Context, feedID string) ([]FeedItem,
 	}
 	return items, nil
 }
+
+const listRecentFeedItems = `-- name: ListRecentFeedItems :many
+SELECT
+    id, name, feed_id, subject, body, date, category, email_id, message_id, confirm_url, confirmed, renamed
+FROM
+    feed_item
+WHERE
+    feed_id = ?
+ORDER BY
+    date DESC,
+    rowid DESC
+LIMIT
+    ?
+`
+
+type ListRecentFeedItemsParams struct {
+	FeedID string
+	Limit  int64
+}
+
+func (q *Queries) ListRecentFeedItems(ctx context.Context, arg ListRecentFeedItemsParams) ([]FeedItem, error) {
+	rows, err := q.db.QueryContext(ctx, listRecentFeedItems, arg.FeedID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedItem
+	for rows.Next() {
+		var i FeedItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.FeedID,
+			&i.Subject,
+			&i.Body,
+			&i.Date,
+			&i.Category,
+			&i.EmailID,
+			&i.MessageID,
+			&i.ConfirmUrl,
+			&i.Confirmed,
+			&i.Renamed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateFeedItemsForEmail = `-- name: UpdateFeedItemsForEmail :exec
+UPDATE
+    feed_item
+SET
+    subject = CASE
+        WHEN renamed THEN subject
+        ELSE ?
+    END,
+    body = ?,
+    date = ?,
+    confirm_url = ?
+WHERE
+    email_id = ?
+`
+
+type UpdateFeedItemsForEmailParams struct {
+	Subject    string
+	Body       string
+	Date       string
+	ConfirmUrl string
+	EmailID    sql.NullInt64
+}
+
+func (q *Queries) UpdateFeedItemsForEmail(ctx context.Context, arg UpdateFeedItemsForEmailParams) error {
+	_, err := q.db.ExecContext(ctx, updateFeedItemsForEmail,
+		arg.Subject,
+		arg.Body,
+		arg.Date,
+		arg.ConfirmUrl,
+		arg.EmailID,
+	)
+	return err
+}
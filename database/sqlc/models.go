@@ -4,27 +4,127 @@
 
 package sqlc
 
-import ()
+import (
+	"database/sql"
+)
+
+type Account struct {
+	ID           int64
+	Email        string
+	PasswordHash string
+	Created      string
+}
+
+type Attachment struct {
+	ID          string
+	EmailID     int64
+	ContentID   string
+	Filename    string
+	ContentType string
+	Inline      bool
+	Size        int64
+	Data        []byte
+}
 
 type Email struct {
-	ID          int64
-	Date        string
-	Recipient   string
-	Sender      string
-	Subject     string
-	Description string
+	ID            int64
+	Date          string
+	Recipient     string
+	Sender        string
+	Subject       string
+	Description   string
+	Uid           sql.NullInt64
+	Undeliverable bool
+	Raw           []byte
+	MessageID     string
+	Dkim          string
+	DkimAligned   bool
+	Spf           string
+	Dmarc         string
 }
 
 type Feed struct {
-	ID   string
-	Name string
+	ID            string
+	Name          string
+	Address       string
+	TokenHash     string
+	Private       bool
+	ReadTokenHash string
+	AccountID     sql.NullInt64
+	Locked        bool
+	SenderLimit   int64
+	RequireDkim   bool
+}
+
+type FeedAlias struct {
+	Alias    string
+	FeedID   string
+	Disabled bool
 }
 
 type FeedItem struct {
-	ID      string
-	Name    string
+	ID         string
+	Name       string
+	FeedID     string
+	Subject    string
+	Body       string
+	Date       string
+	Category   string
+	EmailID    sql.NullInt64
+	MessageID  string
+	ConfirmUrl string
+	Confirmed  bool
+	Renamed    bool
+}
+
+type FilterRule struct {
+	ID           int64
+	FeedID       string
+	Field        string
+	Header       string
+	Pattern      string
+	Action       string
+	Value        string
+	TargetFeedID sql.NullString
+}
+
+type Mailbox struct {
+	Name        string
+	UidValidity int64
+	LastUid     int64
+}
+
+type Quarantine struct {
+	ID        int64
+	FeedID    string
+	EmailID   int64
+	Sender    string
+	Category  string
+	Reason    string
+	MessageID string
+}
+
+type SenderRule struct {
+	ID      int64
 	FeedID  string
-	Subject string
-	Body    string
-	Date    string
+	Pattern string
+	Action  string
+	Auto    bool
+}
+
+type Session struct {
+	ID        string
+	AccountID int64
+	Expires   string
+}
+
+type Subscription struct {
+	ID                int64
+	FeedID            string
+	Sender            string
+	UnsubscribeUrl    string
+	UnsubscribeMailto string
+	OneClick          bool
+	LastReceived      string
+	Unsubscribed      string
 }
@@ -1,14 +1,243 @@
 package date
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
-// ParseDate parses a date string into a time.Time object.
-// we get two different formats from the IMAP server, so we need to try both.
+// ParseDate parses an RFC 5322 date, as found in a Date header. Senders are lax,
+// so it also accepts the obsolete forms RFC 5322 allows and the mistakes mail
+// commonly has: a missing weekday or seconds, two-digit years, zone names or no
+// zone at all, comments, full month names and asctime's "Mon Jan 2 15:04:05 2006".
 func ParseDate(date string) (time.Time, error) {
-	parsedTime, err := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", date)
-	if err == nil {
-		return parsedTime, nil
+	tokens := tokenize(stripComments(date))
+
+	// The weekday is optional, and says nothing the rest of the date doesn't.
+	if len(tokens) > 0 && isWeekday(tokens[0]) {
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) < 4 {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: too short", date)
+	}
+
+	var day, month, year, clock, zone string
+	if _, ok := parseMonth(tokens[0]); ok {
+		// asctime: month day time year [zone]
+		month, day, clock, year = tokens[0], tokens[1], tokens[2], tokens[3]
+		tokens = tokens[4:]
+	} else {
+		day, month, year, clock = tokens[0], tokens[1], tokens[2], tokens[3]
+		tokens = tokens[4:]
+	}
+
+	if len(tokens) > 0 {
+		zone = tokens[0]
+	}
+
+	d, err := strconv.Atoi(day)
+	if err != nil || d < 1 || d > 31 {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: invalid day %q", date, day)
+	}
+
+	m, ok := parseMonth(month)
+	if !ok {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: invalid month %q", date, month)
+	}
+
+	y, err := parseYear(year)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: %w", date, err)
+	}
+
+	hour, minute, second, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: %w", date, err)
+	}
+
+	location, err := parseZone(zone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: %w", date, err)
+	}
+
+	parsed := time.Date(y, m, d, hour, minute, second, 0, location)
+	if parsed.Day() != d {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: no day %d in %s", date, d, m)
+	}
+
+	return parsed, nil
+}
+
+// ParseReceived parses the date a Received header was added, which follows its last semicolon.
+func ParseReceived(received string) (time.Time, error) {
+	i := strings.LastIndex(received, ";")
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("failed to parse received %q: no date", received)
+	}
+
+	return ParseDate(received[i+1:])
+}
+
+// stripComments removes parenthesized comments, which may be nested, such as "(UTC)".
+func stripComments(date string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range date {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// tokenize splits a date on whitespace and commas. Dashes between the day, month
+// and year, as in "2-Jan-2006", are separators too.
+func tokenize(date string) []string {
+	var tokens []string
+	for _, field := range strings.FieldsFunc(date, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == ','
+	}) {
+		if parts := strings.Split(field, "-"); len(parts) == 3 && parts[0] != "" {
+			if _, ok := parseMonth(parts[1]); ok {
+				tokens = append(tokens, parts...)
+				continue
+			}
+		}
+
+		tokens = append(tokens, field)
+	}
+
+	return tokens
+}
+
+func isWeekday(token string) bool {
+	token = strings.ToLower(token)
+	if len(token) < 3 {
+		return false
+	}
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.HasPrefix(strings.ToLower(day.String()), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseMonth accepts month names and their three letter abbreviations, in any case.
+func parseMonth(token string) (time.Month, bool) {
+	token = strings.ToLower(strings.TrimSuffix(token, "."))
+	if len(token) < 3 {
+		return 0, false
+	}
+
+	for month := time.January; month <= time.December; month++ {
+		name := strings.ToLower(month.String())
+		if token == name || token == name[:3] || (len(token) == 4 && token == "sept" && month == time.September) {
+			return month, true
+		}
+	}
+
+	return 0, false
+}
+
+// parseYear accepts four digit years, and the two and three digit years RFC 5322
+// section 4.3 says to add 1900 to, or 2000 to if below 50.
+func parseYear(token string) (int, error) {
+	year, err := strconv.Atoi(token)
+	if err != nil || year < 0 {
+		return 0, fmt.Errorf("invalid year %q", token)
+	}
+
+	switch {
+	case len(token) <= 2 && year < 50:
+		return year + 2000, nil
+	case len(token) <= 3:
+		return year + 1900, nil
+	default:
+		return year, nil
+	}
+}
+
+// parseClock parses a time of day, which may leave out the seconds.
+func parseClock(token string) (int, int, int, error) {
+	parts := strings.Split(token, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, 0, fmt.Errorf("invalid time %q", token)
+	}
+
+	limits := []int{23, 59, 60}
+	values := make([]int, 3)
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 || value > limits[i] {
+			return 0, 0, 0, fmt.Errorf("invalid time %q", token)
+		}
+
+		values[i] = value
+	}
+
+	// A leap second is as close to the next minute as time.Date can get.
+	if values[2] == 60 {
+		values[2] = 59
+	}
+
+	return values[0], values[1], values[2], nil
+}
+
+// obsoleteZones are the zone names RFC 5322 section 4.3 still allows.
+var obsoleteZones = map[string]int{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0,
+	"EST": -5, "EDT": -4,
+	"CST": -6, "CDT": -5,
+	"MST": -7, "MDT": -6,
+	"PST": -8, "PDT": -7,
+}
+
+// parseZone parses a numeric zone or an obsolete zone name. Dates without a zone,
+// or with a military or unknown one, are taken to be UTC, as RFC 5322 says to
+// treat them as -0000: in UTC, with the local zone unknown.
+func parseZone(token string) (*time.Location, error) {
+	if token == "" {
+		return time.UTC, nil
+	}
+
+	if token[0] == '+' || token[0] == '-' {
+		digits := strings.ReplaceAll(token[1:], ":", "")
+		if len(digits) != 4 {
+			return nil, fmt.Errorf("invalid zone %q", token)
+		}
+
+		hours, err := strconv.Atoi(digits[:2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone %q", token)
+		}
+
+		minutes, err := strconv.Atoi(digits[2:])
+		if err != nil || minutes > 59 {
+			return nil, fmt.Errorf("invalid zone %q", token)
+		}
+
+		offset := hours*60*60 + minutes*60
+		if token[0] == '-' {
+			offset = -offset
+		}
+
+		return time.FixedZone("", offset), nil
+	}
+
+	if hours, ok := obsoleteZones[strings.ToUpper(token)]; ok {
+		return time.FixedZone(strings.ToUpper(token), hours*60*60), nil
 	}
 
-	return time.Parse("Mon, 2 Jan 2006 15:04:05 -0700 (UTC)", date)
+	return time.UTC, nil
 }
@@ -0,0 +1,81 @@
+package date
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(2006, time.January, 2, 22, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		date string
+		want time.Time
+	}{
+		{date: "Mon, 02 Jan 2006 15:04:05 -0700", want: want},
+		{date: "Mon, 2 Jan 2006 15:04:05 -0700", want: want},
+		{date: "Mon,  2 Jan 2006 15:04:05 -0700", want: want},
+		{date: "2 Jan 2006 15:04:05 -0700", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 +0000 (UTC)", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 +0000 (Coordinated (Universal) Time)", want: want},
+		{date: "Mon, 2 Jan 2006 15:04:05 -0700 (MST)", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 GMT", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 UT", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 UTC", want: want},
+		{date: "Mon, 2 Jan 2006 17:04:05 EST", want: want},
+		{date: "Mon, 2 Jan 2006 14:04:05 PST", want: want},
+		{date: "Mon, 2 Jan 2006 15:04:05 MST", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 Z", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 A", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05", want: want},
+		{date: "Mon, 2 Jan 2006 22:04:05 -0000", want: want},
+		{date: "Mon, 2 Jan 2006 23:04:05 +01:00", want: want},
+		{date: "Mon, 2 Jan 06 15:04:05 -0700", want: want},
+		{date: "Mon, 2 Jan 106 15:04:05 -0700", want: want},
+		{date: "Monday, 2 January 2006 15:04:05 -0700", want: want},
+		{date: "mon, 2 JAN 2006 15:04:05 -0700", want: want},
+		{date: "2-Jan-2006 15:04:05 -0700", want: want},
+		{date: "Mon Jan  2 22:04:05 2006", want: want},
+		{date: "Mon Jan 2 15:04:05 2006 -0700", want: want},
+		{date: "Mon, 2 Jan 2006 15:04 -0700", want: want.Add(-5 * time.Second)},
+		{date: "Mon, 2 Jan 1999 22:04:05 +0000", want: time.Date(1999, time.January, 2, 22, 4, 5, 0, time.UTC)},
+		{date: "Thu, 1 Jan 70 00:00:00 +0000", want: time.Unix(0, 0).UTC()},
+		{date: "Sat, 31 Dec 2016 23:59:60 +0000", want: time.Date(2016, time.December, 31, 23, 59, 59, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.date, func(t *testing.T) {
+			got, err := ParseDate(test.date)
+			require.NoError(t, err)
+			require.True(t, test.want.Equal(got), "got %s", got)
+		})
+	}
+}
+
+func TestParseDateRejectsGarbage(t *testing.T) {
+	for _, date := range []string{
+		"",
+		"yesterday",
+		"Mon, 2 Jan 2006",
+		"Mon, 32 Jan 2006 15:04:05 -0700",
+		"Mon, 30 Feb 2006 15:04:05 -0700",
+		"Mon, 2 Foo 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 25:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 +07",
+	} {
+		_, err := ParseDate(date)
+		require.Error(t, err, date)
+	}
+}
+
+func TestParseReceived(t *testing.T) {
+	got, err := ParseReceived("from mail.newsletter.com (mail.newsletter.com [192.0.2.1])\r\n" +
+		"\tby mx.mailfeed.xyz with ESMTPS id abc123; Mon, 2 Jan 2006 15:04:05 -0700 (MST)")
+	require.NoError(t, err)
+	require.True(t, time.Date(2006, time.January, 2, 22, 4, 5, 0, time.UTC).Equal(got))
+
+	_, err = ParseReceived("from mail.newsletter.com by mx.mailfeed.xyz")
+	require.Error(t, err)
+}
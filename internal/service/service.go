@@ -2,15 +2,20 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/alex-emery/mailfeed/account"
 	"github.com/alex-emery/mailfeed/database"
 	"github.com/alex-emery/mailfeed/internal/website"
 	"github.com/alex-emery/mailfeed/mail"
 	"github.com/alex-emery/mailfeed/newsletter"
 	"github.com/alex-emery/mailfeed/rss"
+	"github.com/alex-emery/mailfeed/smtpd"
+	"github.com/emersion/go-smtp"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/httprate"
 	"go.uber.org/zap"
@@ -19,8 +24,13 @@ import (
 
 type Service struct {
 	httpServer *http.Server
+	smtpServer *smtp.Server
 	mail       *mail.Mail
+	rss        *rss.Server
+	queue      *newsletter.Queue
 	logger     *zap.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
 type ServiceOptions struct {
@@ -30,51 +40,262 @@ type ServiceOptions struct {
 	DBPath        string
 	Port          string
 	Domain        string
+	// SMTPAddr is the address of the embedded SMTP server, which is disabled if empty.
+	SMTPAddr string
+	// LMTP makes the embedded server speak LMTP instead of SMTP.
+	LMTP bool
+	// Fingerprint are the headers hashed to deduplicate messages without a Message-ID.
+	// Fingerprinting is disabled if empty.
+	Fingerprint []string
+	// FeedItemLimit is the number of most recent items served in a feed.
+	FeedItemLimit int
+	// FeedCacheSize is the number of feeds kept in memory between requests.
+	FeedCacheSize int
+	// FeedCacheTTL is how long feeds are kept in memory, rss.DefaultCacheTTL if zero.
+	FeedCacheTTL time.Duration
+	// PolicyPath is a JSON file holding the allowlist newsletter HTML is sanitized
+	// against. mail.DefaultPolicy is used if empty.
+	PolicyPath string
+	// TrackingRulesPath is a JSON file holding tracking rules used alongside
+	// mail.DefaultTrackingRules.
+	TrackingRulesPath string
+	// AdminToken may list and manage every feed through the API. Feeds can only be
+	// managed with their own tokens if it's empty.
+	AdminToken string
+	// AuthServID is the authserv-id of the mail server whose Authentication-Results
+	// headers are trusted for fetched mail. The topmost header is trusted if it's empty.
+	AuthServID string
+	// ConfirmDomains are the domains whose subscription confirmation links are followed
+	// automatically. Confirmation links are only shown in feeds if it's empty.
+	ConfirmDomains []string
+	// UnsubscribeRelay is the SMTP server mailto unsubscriptions are sent through.
+	// Senders are only unsubscribed from with one-click POSTs if its Addr is empty.
+	UnsubscribeRelay mail.Relay
+	// QueueSize is the number of newsletters which can wait to be picked up by
+	// their feeds before ingestion waits too.
+	QueueSize int
 }
 
 func New(logger *zap.Logger, options ServiceOptions) (Service, error) {
-	feedChan := make(chan *newsletter.NewsLetter)
+	queue := newsletter.NewQueue(options.QueueSize)
 	db, err := database.New(logger, options.DBPath)
 	if err != nil {
 		return Service{}, fmt.Errorf("failed to create database: %w", err)
 	}
 
-	m, err := mail.New(logger, options.EmailServer, options.EmailUsername, options.EmailPassword, &db, feedChan)
+	resolver := mail.NewResolver(&db, options.Domain)
+	deliverer, err := newDeliverer(logger, &db, queue, options)
 	if err != nil {
-		return Service{}, fmt.Errorf("failed to create mail fetcher: %w", err)
+		return Service{}, err
 	}
 
-	rss, err := rss.New(logger, &db, feedChan, options.Domain)
-	if err != nil {
-		return Service{}, fmt.Errorf("failed to create rss server: %w", err)
+	var m *mail.Mail
+	if options.EmailServer != "" {
+		m, err = mail.New(logger, options.EmailServer, options.EmailUsername, options.EmailPassword, &db, resolver, deliverer)
+		if err != nil {
+			return Service{}, fmt.Errorf("failed to create mail fetcher: %w", err)
+		}
+	}
+
+	var smtpServer *smtp.Server
+	if options.SMTPAddr != "" {
+		smtpServer = smtpd.New(logger, resolver, deliverer, smtpd.Options{
+			Addr:            options.SMTPAddr,
+			Domain:          options.Domain,
+			LMTP:            options.LMTP,
+			MaxMessageBytes: 25 * 1024 * 1024,
+		})
 	}
 
+	rss := rss.New(logger, &db, queue, rss.Options{
+		Domain:       options.Domain,
+		ItemLimit:    options.FeedItemLimit,
+		CacheSize:    options.FeedCacheSize,
+		CacheTTL:     options.FeedCacheTTL,
+		AdminToken:   options.AdminToken,
+		Unsubscriber: mail.NewUnsubscriber(nil, options.UnsubscribeRelay),
+	})
+
+	accounts := account.New(logger, &db, account.Options{})
+
 	r := chi.NewRouter()
 	r.Use(chizap.New(logger, &chizap.Opts{
 		WithReferer:   true,
 		WithUserAgent: true,
 	}))
+	r.Use(accounts.Middleware)
 
 	r.Get("/", website.Serve)
 
+	r.Group(func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Use(rss.RequireAdmin)
+		r.Get("/debug/queue", queueStats(logger, queue))
+	})
+
 	r.Route("/rss", func(r chi.Router) {
 		r.Use(httprate.LimitByIP(30, 1*time.Minute))
 		r.Post("/", rss.CreateFeed)
 		r.Get("/{id}", rss.GetFeed)
+		r.Post("/{id}/aliases", rss.CreateAlias)
+	})
+
+	r.Route("/item", func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Get("/{feed}/{item}", rss.GetItem)
+	})
+
+	r.Route("/media", func(r chi.Router) {
+		r.Use(httprate.LimitByIP(120, 1*time.Minute))
+		r.Get("/{id}", rss.GetMedia)
+	})
+
+	r.Route("/atom", func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Get("/{id}", rss.GetAtomFeed)
+	})
+
+	r.Route("/json", func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Get("/{id}", rss.GetJSONFeed)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Get("/signup", accounts.SignUpPage)
+		r.Get("/signin", accounts.SignInPage)
+		r.Post("/signout", accounts.SignOut)
+		r.Get("/dashboard", rss.Dashboard)
+		r.Post("/dashboard/unsubscribe", rss.DashboardUnsubscribe)
+	})
+
+	r.Group(func(r chi.Router) {
+		// each attempt costs a bcrypt hash, so they're limited more strictly
+		r.Use(httprate.LimitByIP(10, 1*time.Minute))
+		r.Post("/signup", accounts.SignUp)
+		r.Post("/signin", accounts.SignIn)
+	})
+
+	r.Route("/manage", func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Get("/", rss.ManagePage)
+		r.Post("/", rss.ManageFeed)
+		r.Post("/{id}", rss.ManageFeedAction)
+	})
+
+	r.Route("/api/feeds", func(r chi.Router) {
+		r.Use(httprate.LimitByIP(30, 1*time.Minute))
+		r.Get("/", rss.ListFeeds)
+		r.Get("/{id}", rss.GetFeedInfo)
+		r.Patch("/{id}", rss.UpdateFeed)
+		r.Delete("/{id}", rss.DeleteFeed)
+		r.Post("/{id}/rotate", rss.RotateFeedAddress)
+		r.Get("/{id}/senders", rss.ListSenderRules)
+		r.Post("/{id}/senders", rss.CreateSenderRule)
+		r.Delete("/{id}/senders/{rule}", rss.DeleteSenderRule)
+		r.Get("/{id}/quarantine", rss.ListQuarantine)
+		r.Post("/{id}/quarantine/{message}/release", rss.ReleaseQuarantine)
+		r.Delete("/{id}/quarantine/{message}", rss.DiscardQuarantine)
+		r.Get("/{id}/subscriptions", rss.ListSubscriptions)
+		r.Post("/{id}/subscriptions/{subscription}/unsubscribe", rss.Unsubscribe)
+		r.Get("/{id}/filters", rss.ListFilterRules)
+		r.Post("/{id}/filters", rss.CreateFilterRule)
+		r.Post("/{id}/filters/dry-run", rss.DryRunFilterRule)
+		r.Put("/{id}/filters/{filter}", rss.UpdateFilterRule)
+		r.Delete("/{id}/filters/{filter}", rss.DeleteFilterRule)
 	})
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return Service{
-		mail: m,
+		mail:       m,
+		smtpServer: smtpServer,
+		rss:        rss,
+		queue:      queue,
 		httpServer: &http.Server{
 			Addr:    fmt.Sprintf(":%s", options.Port),
 			Handler: r,
 		},
 		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
 	}, nil
 }
 
+// queueStats reports how backed up the newsletter queue is.
+func queueStats(logger *zap.Logger, queue *newsletter.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(queue.Stats()); err != nil {
+			logger.Error("failed to write queue stats", zap.Error(err))
+		}
+	}
+}
+
+// Reprocess re-runs the conversion pipeline over the stored messages for feedID,
+// or every feed if feedID is empty, without starting the service.
+func Reprocess(logger *zap.Logger, options ServiceOptions, feedID string) (int, error) {
+	db, err := database.New(logger, options.DBPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create database: %w", err)
+	}
+
+	// Reprocessing updates items in place, so no newsletters are ever sent.
+	deliverer, err := newDeliverer(logger, &db, nil, options)
+	if err != nil {
+		return 0, err
+	}
+
+	return deliverer.Reprocess(context.Background(), feedID)
+}
+
+// newDeliverer creates a deliverer running the conversion pipeline configured by options.
+func newDeliverer(logger *zap.Logger, db *database.Database, queue *newsletter.Queue, options ServiceOptions) (*mail.Deliverer, error) {
+	deliverer := mail.NewDeliverer(logger, db, queue)
+	deliverer.SetFingerprint(options.Fingerprint)
+	deliverer.SetMediaURL(fmt.Sprintf("https://%s/media/", options.Domain))
+	deliverer.SetDNSResolver(net.DefaultResolver)
+	deliverer.SetAuthServID(options.AuthServID)
+	if len(options.ConfirmDomains) > 0 {
+		deliverer.SetConfirmer(mail.NewConfirmer(nil, options.ConfirmDomains))
+	}
+	if options.PolicyPath != "" {
+		policy, err := mail.LoadPolicy(options.PolicyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sanitization policy: %w", err)
+		}
+
+		deliverer.SetPolicy(policy)
+	}
+
+	if options.TrackingRulesPath != "" {
+		rules, err := mail.LoadTrackingRules(options.TrackingRulesPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tracking rules: %w", err)
+		}
+
+		if err := deliverer.SetTrackingRules(mail.DefaultTrackingRules().Merge(rules)); err != nil {
+			return nil, fmt.Errorf("failed to set tracking rules: %w", err)
+		}
+	}
+
+	return deliverer, nil
+}
+
 func (svc *Service) Start() error {
-	go svc.mail.StartFetch()
+	go svc.rss.Run(svc.ctx)
+
+	if svc.mail != nil {
+		go svc.mail.StartFetch()
+	}
+
+	if svc.smtpServer != nil {
+		go func() {
+			svc.logger.Info("starting smtp server", zap.String("addr", svc.smtpServer.Addr), zap.Bool("lmtp", svc.smtpServer.LMTP))
+			if err := svc.smtpServer.ListenAndServe(); err != nil {
+				svc.logger.Error("smtp server stopped", zap.Error(err))
+			}
+		}()
+	}
 
 	if err := svc.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start http server: %w", err)
@@ -84,6 +305,19 @@ func (svc *Service) Start() error {
 }
 
 func (svc *Service) Stop() error {
-	svc.mail.Close()
+	if svc.mail != nil {
+		svc.mail.Close()
+	}
+
+	if svc.smtpServer != nil {
+		if err := svc.smtpServer.Close(); err != nil {
+			svc.logger.Error("failed to close smtp server", zap.Error(err))
+		}
+	}
+
+	// Anything still queued is already stored, so it can be dropped.
+	svc.queue.Close()
+	svc.cancel()
+
 	return svc.httpServer.Shutdown(context.Background())
 }
@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// attachment is a non-text part of a message: an inline image referenced from the
+// body by its Content-ID, or a file attached to the message.
+type attachment struct {
+	// id identifies the attachment in media URLs. It is derived from the raw message,
+	// so reprocessing a message gives its attachments the same IDs.
+	id          string
+	contentID   string
+	filename    string
+	contentType string
+	inline      bool
+	data        []byte
+}
+
+// extractAttachments returns the parts of a raw message which aren't part of its body.
+func extractAttachments(raw []byte) ([]attachment, error) {
+	msg, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	var attachments []attachment
+	err = msg.Walk(func(path []int, entity *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) {
+			return err
+		}
+
+		mediaType, params, _ := entity.Header.ContentType()
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return nil
+		}
+
+		disposition, dispositionParams, _ := entity.Header.ContentDisposition()
+		if strings.HasPrefix(mediaType, "text/") && disposition != "attachment" {
+			return nil
+		}
+
+		data, err := io.ReadAll(entity.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read part: %w", err)
+		}
+
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+
+		if filename != "" {
+			filename = filepath.Base(filename)
+		}
+
+		if mediaType == "" || mediaType == "application/octet-stream" {
+			if byExtension := mime.TypeByExtension(filepath.Ext(filename)); byExtension != "" {
+				mediaType = byExtension
+			}
+		}
+
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+
+		contentID := strings.Trim(entity.Header.Get("Content-Id"), " <>")
+		key := contentID
+		if key == "" {
+			key = fmt.Sprint(path)
+		}
+
+		attachments = append(attachments, attachment{
+			id:          attachmentID(raw, key),
+			contentID:   contentID,
+			filename:    filename,
+			contentType: mediaType,
+			inline:      contentID != "" && disposition != "attachment",
+			data:        data,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk message: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// attachmentID returns an ID for the part of raw identified by key. It can't be
+// guessed without the message, so attachments are as private as the feeds showing them.
+// The whole message is hashed, so emails storing an attachment with the same ID have
+// the same content, and media is served by ID alone whichever email it's read from.
+func attachmentID(raw []byte, key string) string {
+	hash := sha256.New()
+	hash.Write(raw)
+	hash.Write([]byte{0})
+	hash.Write([]byte(key))
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// rewriteCIDs replaces cid: URLs in body, which refer to the message's own parts,
+// with the URLs the attachments are served from.
+func rewriteCIDs(body string, attachments []attachment, mediaURL string) (string, error) {
+	urls := make(map[string]string)
+	for _, attachment := range attachments {
+		if attachment.contentID != "" {
+			urls[attachment.contentID] = mediaURL + attachment.id
+		}
+	}
+
+	if len(urls) == 0 || !strings.Contains(body, "cid:") {
+		return body, nil
+	}
+
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(body), context)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	var buf strings.Builder
+	for _, node := range nodes {
+		rewriteNodeCIDs(node, urls)
+		if err := html.Render(&buf, node); err != nil {
+			return "", fmt.Errorf("failed to render body: %w", err)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+func rewriteNodeCIDs(n *html.Node, urls map[string]string) {
+	if n.Type == html.ElementNode {
+		for i, attr := range n.Attr {
+			if attr.Key != "src" && attr.Key != "href" {
+				continue
+			}
+
+			ref, ok := strings.CutPrefix(attr.Val, "cid:")
+			if !ok {
+				continue
+			}
+
+			// Content-IDs in URLs are percent-encoded, see RFC 2392.
+			if unescaped, err := url.PathUnescape(ref); err == nil {
+				ref = unescaped
+			}
+
+			if u, ok := urls[ref]; ok {
+				n.Attr[i].Val = u
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		rewriteNodeCIDs(c, urls)
+	}
+}
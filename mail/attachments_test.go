@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const attachmentMessage = "Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+	"From: The Newsletter <the@newsletter.com>\r\n" +
+	"To: abc123@mailfeed.xyz\r\n" +
+	"Subject: Issue 42\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/related; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Look:</p><img src=\"cid:logo%40newsletter.com\" alt=\"Logo\">\r\n" +
+	"--inner\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@newsletter.com>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"issue-42.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQ=\r\n" +
+	"--outer--\r\n"
+
+func TestExtractAttachments(t *testing.T) {
+	attachments, err := extractAttachments([]byte(attachmentMessage))
+	require.NoError(t, err)
+	require.Len(t, attachments, 2)
+
+	logo, pdf := attachments[0], attachments[1]
+	require.Equal(t, "logo@newsletter.com", logo.contentID)
+	require.Equal(t, "image/png", logo.contentType)
+	require.True(t, logo.inline)
+	require.Equal(t, "\x89PNG\r\n\x1a\n", string(logo.data))
+
+	require.Equal(t, "issue-42.pdf", pdf.filename)
+	require.Equal(t, "application/pdf", pdf.contentType)
+	require.False(t, pdf.inline)
+	require.Equal(t, "%PDF-1.4", string(pdf.data))
+
+	// IDs are stable, so reprocessing doesn't break links to attachments
+	again, err := extractAttachments([]byte(attachmentMessage))
+	require.NoError(t, err)
+	require.Equal(t, logo.id, again[0].id)
+	require.NotEqual(t, logo.id, pdf.id)
+
+	// attachments are served by ID alone, so a different attachment never has the same ID
+	changed, err := extractAttachments([]byte(strings.Replace(attachmentMessage, "JVBERi0xLjQ=", "JVBERi0xLjU=", 1)))
+	require.NoError(t, err)
+	require.Equal(t, "%PDF-1.5", string(changed[1].data))
+	require.NotEqual(t, pdf.id, changed[1].id)
+	require.NotEqual(t, logo.id, changed[0].id)
+}
+
+func TestDeliverStoresAttachments(t *testing.T) {
+	logger := zap.NewNop()
+	path := filepath.Join(t.TempDir(), "mailfeed.db")
+	db, err := database.New(logger, path)
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	letters := newsletter.NewQueue(1)
+	deliverer := NewDeliverer(logger, &db, letters)
+	deliverer.SetMediaURL("https://mailfeed.xyz/media/")
+	err = deliverer.Deliver(context.Background(), Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(attachmentMessage))
+	require.NoError(t, err)
+
+	attachments, err := extractAttachments([]byte(attachmentMessage))
+	require.NoError(t, err)
+
+	letter := <-letters.Receive()
+	require.Contains(t, letter.Body, `<img src="https://mailfeed.xyz/media/`+attachments[0].id+`" alt="Logo"/>`)
+	require.NotContains(t, letter.Body, "PDF")
+
+	stored, err := db.GetAttachment(context.Background(), attachments[0].id)
+	require.NoError(t, err)
+	require.Equal(t, letter.EmailID, stored.EmailID)
+	require.Equal(t, "image/png", stored.ContentType)
+
+	listed, err := db.ListFeedAttachments(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, attachments[1].id, listed[0].ID)
+	require.Equal(t, "issue-42.pdf", listed[0].Filename)
+
+	// the same message delivered separately to another feed has its attachments too
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "def456", Name: "Other Feed"})
+	require.NoError(t, err)
+
+	err = deliverer.Deliver(context.Background(), Envelope{Recipients: []Recipient{{FeedID: "def456"}}}, []byte(attachmentMessage))
+	require.NoError(t, err)
+	<-letters.Receive()
+
+	listed, err = db.ListFeedAttachments(context.Background(), "def456")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, attachments[1].id, listed[0].ID)
+
+	// each email has its own copy, which is the same whichever one is served
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var copies, contents int
+	err = conn.QueryRow("SELECT count(*), count(DISTINCT data) FROM attachment WHERE id = ?", attachments[1].id).Scan(&copies, &contents)
+	require.NoError(t, err)
+	require.Equal(t, 2, copies)
+	require.Equal(t, 1, contents)
+
+	// reprocessing keeps the same attachments
+	count, err := deliverer.Reprocess(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	items, err := db.ListFeedItems(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.True(t, strings.Contains(items[0].Body, attachments[0].id))
+}
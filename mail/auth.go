@@ -0,0 +1,230 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/emersion/go-msgauth/dmarc"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Authentication results, as they're written in Authentication-Results headers.
+const (
+	AuthPass      = "pass"
+	AuthFail      = "fail"
+	AuthNone      = "none"
+	AuthTempError = "temperror"
+	AuthPermError = "permerror"
+)
+
+// authTimeout limits how long the DNS lookups authenticating a message may take.
+const authTimeout = 10 * time.Second
+
+// maxSignatures limits how many DKIM signatures are checked on a message.
+const maxSignatures = 5
+
+// DNSResolver looks up the DNS records needed to authenticate messages.
+// *net.Resolver implements it, and tests can use a fake.
+type DNSResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// Authentication is the verdict on whether a message is from who it says.
+// Each result is one of the Auth constants, or empty if it wasn't checked.
+type Authentication struct {
+	DKIM  string
+	SPF   string
+	DMARC string
+	// DKIMAligned is set when a valid DKIM signature is from the From address's
+	// domain, as DMARC aligns them.
+	DKIMAligned bool
+}
+
+// authenticate verifies a message's DKIM signatures, and works out its SPF and DMARC
+// results. They're checked directly for messages received over SMTP. Otherwise the
+// message was delivered to a mailbox, so they're read from the Authentication-Results
+// header its server added, with DMARC evaluated here if the header doesn't have it.
+func (d *Deliverer) authenticate(ctx context.Context, envelope Envelope, header message.Header, raw []byte) Authentication {
+	if d.dns == nil {
+		return Authentication{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
+	defer cancel()
+
+	lookupTXT := func(name string) ([]string, error) {
+		return d.dns.LookupTXT(ctx, name)
+	}
+
+	var from string
+	if address := senderAddress(header); address != "" {
+		_, from, _ = strings.Cut(address, "@")
+	}
+
+	// The DMARC record says how strictly DKIM and SPF domains must match From's.
+	record, policyErr := lookupDMARC(from, lookupTXT)
+	var dkimAlignment, spfAlignment dmarc.AlignmentMode = dmarc.AlignmentRelaxed, dmarc.AlignmentRelaxed
+	if policyErr == nil {
+		dkimAlignment, spfAlignment = record.DKIMAlignment, record.SPFAlignment
+	}
+
+	auth := Authentication{}
+	auth.DKIM, auth.DKIMAligned = verifyDKIM(raw, from, dkimAlignment, lookupTXT)
+
+	var spfDomain, headerDMARC string
+	if envelope.RemoteIP != nil {
+		result, _ := spf.CheckHostWithSender(envelope.RemoteIP, envelope.Helo, envelope.MailFrom,
+			spf.WithResolver(d.dns), spf.WithContext(ctx))
+		auth.SPF = string(result)
+		spfDomain = identityDomain(envelope.MailFrom, envelope.Helo)
+	} else {
+		for _, result := range d.trustedResults(header) {
+			switch result := result.(type) {
+			case *authres.SPFResult:
+				auth.SPF = string(result.Value)
+				spfDomain = identityDomain(result.From, result.Helo)
+			case *authres.DMARCResult:
+				headerDMARC = string(result.Value)
+			}
+		}
+	}
+
+	switch {
+	case headerDMARC != "":
+		auth.DMARC = headerDMARC
+	case from == "" || errors.Is(policyErr, dmarc.ErrNoPolicy):
+		auth.DMARC = AuthNone
+	case dmarc.IsTempFail(policyErr):
+		auth.DMARC = AuthTempError
+	case policyErr != nil:
+		auth.DMARC = AuthPermError
+	case auth.DKIMAligned || auth.SPF == AuthPass && aligned(spfDomain, from, spfAlignment):
+		auth.DMARC = AuthPass
+	default:
+		auth.DMARC = AuthFail
+	}
+
+	return auth
+}
+
+// lookupDMARC returns the DMARC record for domain, or for its organizational domain if
+// it hasn't one.
+func lookupDMARC(domain string, lookupTXT func(string) ([]string, error)) (*dmarc.Record, error) {
+	if domain == "" {
+		return nil, dmarc.ErrNoPolicy
+	}
+
+	options := &dmarc.LookupOptions{LookupTXT: lookupTXT}
+	record, err := dmarc.LookupWithOptions(domain, options)
+	if !errors.Is(err, dmarc.ErrNoPolicy) {
+		return record, err
+	}
+
+	organization, orgErr := publicsuffix.EffectiveTLDPlusOne(domain)
+	if orgErr != nil || organization == domain {
+		return nil, err
+	}
+
+	return dmarc.LookupWithOptions(organization, options)
+}
+
+// verifyDKIM checks raw's DKIM signatures. It passes if any signature is valid, and
+// reports whether one of the valid signatures is aligned with the From domain.
+func verifyDKIM(raw []byte, from string, alignment dmarc.AlignmentMode, lookupTXT func(string) ([]string, error)) (string, bool) {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT:        lookupTXT,
+		MaxVerifications: maxSignatures,
+	})
+	if err != nil && !errors.Is(err, dkim.ErrTooManySignatures) {
+		return AuthPermError, false
+	}
+
+	if len(verifications) == 0 {
+		return AuthNone, false
+	}
+
+	result := AuthFail
+	var isAligned bool
+	for _, verification := range verifications {
+		switch {
+		case verification.Err == nil:
+			result = AuthPass
+			isAligned = isAligned || aligned(verification.Domain, from, alignment)
+		case dkim.IsTempFail(verification.Err) && result != AuthPass:
+			result = AuthTempError
+		}
+	}
+
+	return result, isAligned
+}
+
+// trustedResults returns the results from the Authentication-Results header added by the
+// server the message was fetched from. Senders can add their own, so only the topmost
+// header is trusted, or the topmost from the configured authserv-id if one is set. If
+// the topmost header can't be parsed, the message is treated as unauthenticated rather
+// than trusting one further down.
+func (d *Deliverer) trustedResults(header message.Header) []authres.Result {
+	for _, value := range header.Values("Authentication-Results") {
+		id, results, err := authres.Parse(value)
+		if err != nil {
+			if d.authServID == "" {
+				return nil
+			}
+
+			continue
+		}
+
+		if d.authServID == "" || strings.EqualFold(id, d.authServID) {
+			return results
+		}
+	}
+
+	return nil
+}
+
+// identityDomain returns the domain SPF checked: the envelope sender's, or else the
+// HELO name's.
+func identityDomain(mailFrom string, helo string) string {
+	if _, domain, ok := strings.Cut(mailFrom, "@"); ok && domain != "" {
+		return domain
+	}
+
+	return helo
+}
+
+// aligned reports whether domain is aligned with the From domain: the same domain in
+// strict mode, or under the same organizational domain in relaxed mode.
+func aligned(domain string, from string, mode dmarc.AlignmentMode) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	from = strings.TrimSuffix(strings.ToLower(from), ".")
+	if domain == "" || from == "" {
+		return false
+	}
+
+	if domain == from {
+		return true
+	}
+
+	if mode == dmarc.AlignmentStrict {
+		return false
+	}
+
+	a, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return false
+	}
+
+	b, err := publicsuffix.EffectiveTLDPlusOne(from)
+	return err == nil && a == b
+}
@@ -0,0 +1,169 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeResolver answers TXT lookups from a map, so tests never touch the network.
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txts, ok := f[strings.TrimSuffix(name, ".")]; ok {
+		return txts, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+// signer signs messages for domain, and publishes its key in resolver.
+func signer(t *testing.T, resolver fakeResolver, domain string) func(raw string) string {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	resolver["mail._domainkey."+domain] = []string{"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public)}
+
+	return func(raw string) string {
+		var signed bytes.Buffer
+		err := dkim.Sign(&signed, strings.NewReader(raw), &dkim.SignOptions{
+			Domain:   domain,
+			Selector: "mail",
+			Signer:   private,
+		})
+		require.NoError(t, err)
+
+		return signed.String()
+	}
+}
+
+func newResolver() fakeResolver {
+	return fakeResolver{
+		"_dmarc.example.com": {"v=DMARC1; p=reject"},
+		"example.com":        {"v=spf1 ip4:192.0.2.1 -all"},
+		"evil.com":           {"v=spf1 ip4:198.51.100.1 -all"},
+	}
+}
+
+const authMessage = "From: News <news@example.com>\r\n" +
+	"To: abc123@mailfeed.xyz\r\n" +
+	"Subject: Hello\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+	"\r\n" +
+	"Hello\r\n"
+
+func TestAuthenticate(t *testing.T) {
+	resolver := newResolver()
+	signExample := signer(t, resolver, "mail.example.com")
+	signEvil := signer(t, resolver, "evil.com")
+
+	deliverer := NewDeliverer(zap.NewNop(), nil, nil)
+	deliverer.SetDNSResolver(resolver)
+
+	authenticate := func(envelope Envelope, raw string) Authentication {
+		msg, err := message.Read(strings.NewReader(raw))
+		require.NoError(t, err)
+
+		return deliverer.authenticate(context.Background(), envelope, msg.Header, []byte(raw))
+	}
+
+	smtp := Envelope{RemoteIP: net.ParseIP("192.0.2.1"), Helo: "mail.example.com", MailFrom: "bounce@example.com"}
+	require.Equal(t, Authentication{DKIM: AuthPass, DKIMAligned: true, SPF: AuthPass, DMARC: AuthPass}, authenticate(smtp, signExample(authMessage)))
+
+	// tampering breaks the signature, but SPF still passes DMARC
+	tampered := strings.Replace(signExample(authMessage), "\r\nHello\r\n", "\r\nGoodbye\r\n", 1)
+	require.Equal(t, Authentication{DKIM: AuthFail, SPF: AuthPass, DMARC: AuthPass}, authenticate(smtp, tampered))
+
+	// a spoofer can pass DKIM and SPF for their own domain, but not align with From's
+	spoofed := Envelope{RemoteIP: net.ParseIP("198.51.100.1"), Helo: "evil.com", MailFrom: "bounce@evil.com"}
+	require.Equal(t, Authentication{DKIM: AuthPass, SPF: AuthPass, DMARC: AuthFail}, authenticate(spoofed, signEvil(authMessage)))
+
+	forged := Envelope{RemoteIP: net.ParseIP("198.51.100.1"), Helo: "evil.com", MailFrom: "bounce@example.com"}
+	require.Equal(t, Authentication{DKIM: AuthNone, SPF: AuthFail, DMARC: AuthFail}, authenticate(forged, authMessage))
+
+	// fetched mail takes SPF and DMARC from the topmost Authentication-Results header
+	fetched := "Authentication-Results: mx.example.net; spf=fail smtp.mailfrom=example.com\r\n" +
+		"Authentication-Results: evil.com; spf=pass smtp.mailfrom=example.com; dmarc=pass\r\n" + authMessage
+	require.Equal(t, Authentication{DKIM: AuthNone, SPF: AuthFail, DMARC: AuthFail}, authenticate(Envelope{}, fetched))
+
+	fetched = "Authentication-Results: mx.example.net; spf=pass smtp.mailfrom=bounce@example.com\r\n" + authMessage
+	require.Equal(t, Authentication{DKIM: AuthNone, SPF: AuthPass, DMARC: AuthPass}, authenticate(Envelope{}, fetched))
+
+	// a malformed topmost header isn't skipped in favour of one the sender added
+	fetched = "Authentication-Results: mx.example.net; spf\r\n" +
+		"Authentication-Results: mx.example.net; spf=pass smtp.mailfrom=bounce@example.com; dmarc=pass\r\n" + authMessage
+	require.Equal(t, Authentication{DKIM: AuthNone, DMARC: AuthFail}, authenticate(Envelope{}, fetched))
+
+	deliverer.SetAuthServID("mx.example.net")
+	fetched = "Authentication-Results: evil.com; spf=pass smtp.mailfrom=example.com; dmarc=pass\r\n" +
+		"Authentication-Results: mx.example.net; spf=softfail smtp.mailfrom=example.com; dmarc=fail\r\n" + authMessage
+	require.Equal(t, Authentication{DKIM: AuthNone, SPF: "softfail", DMARC: AuthFail}, authenticate(Envelope{}, fetched))
+
+	// messages aren't authenticated without a resolver
+	require.Equal(t, Authentication{}, NewDeliverer(zap.NewNop(), nil, nil).authenticate(context.Background(), smtp, message.Header{}, nil))
+}
+
+func TestDeliverRequiresDKIM(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	err = db.SetFeedRequireDKIM(ctx, sqlc.SetFeedRequireDKIMParams{RequireDkim: true, ID: "abc123"})
+	require.NoError(t, err)
+
+	resolver := newResolver()
+	signExample := signer(t, resolver, "example.com")
+	signEvil := signer(t, resolver, "evil.com")
+
+	letters := newsletter.NewQueue(10)
+	deliverer := NewDeliverer(logger, &db, letters)
+	deliverer.SetDNSResolver(resolver)
+
+	envelope := Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}
+	require.NoError(t, deliverer.Deliver(ctx, envelope, []byte(signEvil("Message-ID: <1@evil.com>\r\n"+authMessage))))
+	require.NoError(t, deliverer.Deliver(ctx, envelope, []byte(signExample("Message-ID: <2@example.com>\r\n"+authMessage))))
+
+	items, err := db.ListFeedItems(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	email, err := db.GetEmail(ctx, items[0].EmailID.Int64)
+	require.NoError(t, err)
+	require.Equal(t, AuthPass, email.Dkim)
+	require.True(t, email.DkimAligned)
+	require.Equal(t, AuthPass, email.Dmarc)
+
+	quarantined, err := db.ListQuarantine(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.Equal(t, "message has no DKIM signature from its sender's domain", quarantined[0].Reason)
+}
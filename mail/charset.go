@@ -0,0 +1,86 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+	gocharset "github.com/emersion/go-message/charset"
+	htmlcharset "golang.org/x/net/html/charset"
+)
+
+// go-message decodes encoded-word headers and single part bodies with
+// message.CharsetReader, so they're decoded the same way as every other part.
+func init() {
+	message.CharsetReader = charsetReader
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decodeCharset(content, "", charset)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(decoded), nil
+}
+
+// decodeCharset converts the content of a text part to UTF-8. charset is the one
+// declared in the part's Content-Type. HTML without one is decoded using its meta
+// charset, and anything else which isn't valid UTF-8 is assumed to be Windows-1252,
+// the most common undeclared charset in mail.
+func decodeCharset(content []byte, mediaType string, charset string) ([]byte, error) {
+	charset = strings.ToLower(strings.Trim(strings.TrimSpace(charset), `"`))
+	if charset == "" && mediaType == "text/html" {
+		_, charset, _ = htmlcharset.DetermineEncoding(content, "text/html")
+	}
+
+	switch charset {
+	case "utf-8", "utf8":
+		return content, nil
+	case "", "us-ascii", "ascii":
+		// Senders often declare ASCII, or nothing, while sending UTF-8.
+		if utf8.Valid(content) {
+			return content, nil
+		}
+
+		charset = "windows-1252"
+	}
+
+	// WHATWG's labels come first, as they decode the charsets senders actually use:
+	// ISO-8859-1 mail, for example, is usually Windows-1252.
+	var reader io.Reader
+	if encoding, _ := htmlcharset.Lookup(charset); encoding != nil {
+		reader = encoding.NewDecoder().Reader(bytes.NewReader(content))
+	} else {
+		var err error
+		reader, err = gocharset.Reader(charset, bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode charset: %w", err)
+		}
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", charset, err)
+	}
+
+	return decoded, nil
+}
+
+// headerText returns the decoded value of a header, or its raw value if it can't be decoded.
+func headerText(header message.Header, key string) string {
+	text, err := header.Text(key)
+	if err != nil {
+		return header.Get(key)
+	}
+
+	return text
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestConvertDecodesCharsets(t *testing.T) {
+	tests := []struct {
+		file    string
+		subject string
+		body    string
+	}{
+		{file: "iso-8859-1.eml", subject: "Café déjà vu", body: "Crème brûlée à la française"},
+		{file: "windows-1252.eml", subject: "“Weekly” digest", body: "“Smart quotes” cost €5 — really"},
+		{file: "shift_jis.eml", subject: "今週のニュース", body: "こんにちは世界"},
+		{file: "iso-2022-jp.eml", subject: "ニュースレター第1号", body: "日本語のメールマガジン"},
+		{file: "meta-charset.eml", subject: "Grüße aus Köln", body: "Grüße für 10€"},
+		{file: "mislabelled-ascii.eml", subject: "Naïve café", body: "Naïve café"},
+	}
+
+	deliverer := NewDeliverer(zap.NewNop(), nil, nil)
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata/charset", test.file))
+			require.NoError(t, err)
+
+			msg, err := deliverer.convert(raw, time.Time{})
+			require.NoError(t, err)
+			require.Equal(t, test.subject, msg.subject)
+			require.Contains(t, msg.body, test.body)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when a link from mail points at an address which
+// isn't on the public internet.
+var errPrivateAddress = errors.New("address isn't public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate doesn't cover.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicTransport returns a transport for following links from mail, which anyone
+// can send. It only connects to public addresses, so a sender can't make the server
+// send requests to itself or the network it runs in. Proxies aren't used, since the
+// address would then only be checked against the proxy's.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: refusePrivate,
+	}
+
+	return &http.Transport{
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// refusePrivate is a net.Dialer Control function which refuses to connect to
+// loopback, private, link-local and other non-public addresses. It runs after
+// names are resolved, so it can't be got round with DNS.
+func refusePrivate(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse address %q: %w", address, err)
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("failed to parse address %q: %w", address, err)
+	}
+
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// confirmPatterns match the confirmation links of common newsletter platforms, which
+// are trusted without anything else in the message looking like a confirmation.
+var confirmPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^https?://[^/]*list-manage\.com/subscribe/confirm`),
+	regexp.MustCompile(`^https?://[^/]*substack\.com/[^?]*confirm`),
+	regexp.MustCompile(`^https?://[^/]*buttondown\.(email|com)/[^?]*confirm`),
+	regexp.MustCompile(`^https?://[^/]*(convertkit|kit)\.com/[^?]*confirm`),
+	regexp.MustCompile(`^https?://[^/]*beehiiv\.com/[^?]*confirm`),
+	regexp.MustCompile(`^https?://[^/]*mailerlite\.com/[^?]*(confirm|activate)`),
+	regexp.MustCompile(`^https?://[^/]*createsend\d*\.com/t/[^?]*confirm`),
+	regexp.MustCompile(`^https?://[^/]*/members/\?[^#]*action=signup`),
+}
+
+var (
+	// confirmSubject matches the subjects of mail asking for a subscription to be confirmed.
+	confirmSubject = regexp.MustCompile(`(?i)\b(confirm|verify|activate|validate)\b.{0,40}\b(subscription|subscribing|email|e-mail|address|sign[- ]?up|newsletter)|please confirm|opt[- ]?in|one (more|last) step|almost (there|done|finished)`)
+	// confirmText matches body text asking for a subscription to be confirmed.
+	confirmText = regexp.MustCompile(`(?i)\b(confirm|verify|activate)\b (your |my |the )?(subscription|email|e-mail|address|sign[- ]?up)|double opt[- ]?in|if you (did not|didn't|did not mean to) (sign up|subscribe|request)`)
+	// confirmLink matches the text or URLs of links which confirm a subscription.
+	confirmLink = regexp.MustCompile(`(?i)confirm|verify|activate|opt-?in|yes,? (subscribe|sign me up)|subscribe me`)
+)
+
+// detectConfirmation returns the link which confirms a subscription, if subject and
+// body are a request to confirm one. Links from platforms known to send confirmations
+// are enough on their own; otherwise the subject or text has to ask for confirmation
+// and a link has to look like it gives it.
+func detectConfirmation(subject string, body string) (string, bool) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+
+	var candidate string
+	for _, link := range links(doc) {
+		for _, pattern := range confirmPatterns {
+			if pattern.MatchString(link.href) {
+				return link.href, true
+			}
+		}
+
+		if candidate == "" && (confirmLink.MatchString(link.label) || confirmLink.MatchString(link.url.Path+"?"+link.url.RawQuery)) {
+			candidate = link.href
+		}
+	}
+
+	if candidate == "" {
+		return "", false
+	}
+
+	var text strings.Builder
+	collectText(doc, &text)
+	if confirmSubject.MatchString(subject) || confirmText.MatchString(text.String()) {
+		return candidate, true
+	}
+
+	return "", false
+}
+
+type link struct {
+	href  string
+	url   *url.URL
+	label string
+}
+
+// links returns the web links under n, in order, leaving out unsubscribe links.
+func links(n *html.Node) []link {
+	if n.Type == html.ElementNode && n.DataAtom == atom.A {
+		var label strings.Builder
+		collectText(n, &label)
+
+		href := attr(n, "href")
+		u, err := url.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil
+		}
+
+		if strings.Contains(strings.ToLower(href+" "+label.String()), "unsubscribe") {
+			return nil
+		}
+
+		return []link{{href: href, url: u, label: label.String()}}
+	}
+
+	var found []link
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		found = append(found, links(c)...)
+	}
+
+	return found
+}
+
+// collectText writes the text under n to text, with a space after each text node.
+func collectText(n *html.Node, text *strings.Builder) {
+	if n.Type == html.TextNode {
+		text.WriteString(n.Data)
+		text.WriteString(" ")
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		collectText(c, text)
+	}
+}
+
+// confirmTimeout limits how long following a confirmation link may take.
+const confirmTimeout = 10 * time.Second
+
+// HTTPClient sends the requests following confirmation links. *http.Client implements it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Confirmer follows the links in subscription confirmation mail, so feeds don't wait for
+// someone to click them. Only links to allowlisted domains are followed, since anyone can
+// send mail with a link in it.
+type Confirmer struct {
+	client  HTTPClient
+	domains []string
+}
+
+// NewConfirmer creates a Confirmer which follows links to domains, or their subdomains,
+// with client, or a client which only connects to public addresses if it's nil.
+// Redirects are only followed to allowlisted domains too.
+func NewConfirmer(client HTTPClient, domains []string) *Confirmer {
+	if client == nil {
+		client = &http.Client{Transport: publicTransport()}
+	}
+
+	allowed := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), "."); domain != "" {
+			allowed = append(allowed, domain)
+		}
+	}
+
+	c := &Confirmer{client: client, domains: allowed}
+	if hc, ok := client.(*http.Client); ok {
+		checked := *hc
+		checked.CheckRedirect = c.checkRedirect
+		c.client = &checked
+	}
+
+	return c
+}
+
+// checkRedirect is an http.Client CheckRedirect function which only follows redirects
+// to allowlisted domains, so an open redirect on one can't send requests anywhere.
+func (c *Confirmer) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if !c.Allowed(req.URL.String()) {
+		return fmt.Errorf("confirmation link redirected to %q, which isn't an allowed domain", req.URL)
+	}
+
+	return nil
+}
+
+// Allowed reports whether link is to an allowlisted domain.
+func (c *Confirmer) Allowed(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range c.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Confirm follows link, which must be allowed.
+func (c *Confirmer) Confirm(ctx context.Context, link string) error {
+	if !c.Allowed(link) {
+		return fmt.Errorf("confirmation link %q isn't to an allowed domain", link)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to follow confirmation link: %w", err)
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("confirmation link returned %s", resp.Status)
+	}
+
+	return nil
+}
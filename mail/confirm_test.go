@@ -0,0 +1,159 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDetectConfirmation(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		want    string
+	}{
+		{
+			name:    "known platform",
+			subject: "The Weekly: Action required",
+			body:    `<p>Hi!</p><a href="https://example.us1.list-manage.com/subscribe/confirm?u=1&amp;id=2">Yes, subscribe me to this list.</a>`,
+			want:    "https://example.us1.list-manage.com/subscribe/confirm?u=1&id=2",
+		},
+		{
+			name:    "subject asks to confirm",
+			subject: "Please confirm your subscription to The Weekly",
+			body:    `<a href="https://example.com/about">About us</a> <a href="https://example.com/s/verify?token=abc">Click here</a>`,
+			want:    "https://example.com/s/verify?token=abc",
+		},
+		{
+			name:    "body asks to confirm",
+			subject: "Welcome!",
+			body:    `<p>Confirm your email address to start reading.</p><a href="https://example.com/welcome?t=1">Confirm</a>`,
+			want:    "https://example.com/welcome?t=1",
+		},
+		{
+			name:    "unsubscribe links are never confirmations",
+			subject: "Please confirm your subscription",
+			body:    `<a href="https://example.com/unsubscribe?confirm=1">Confirm you want to unsubscribe</a>`,
+		},
+		{
+			name:    "newsletter",
+			subject: "This week in Go",
+			body:    `<p>We verified the benchmarks.</p><a href="https://example.com/verify-benchmarks">Read more</a>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link, ok := detectConfirmation(test.subject, test.body)
+			require.Equal(t, test.want != "", ok)
+			require.Equal(t, test.want, link)
+		})
+	}
+}
+
+func TestConfirmerAllowed(t *testing.T) {
+	confirmer := NewConfirmer(nil, []string{"Example.com", " list-manage.com "})
+
+	require.True(t, confirmer.Allowed("https://example.com/confirm"))
+	require.True(t, confirmer.Allowed("http://news.example.com/confirm"))
+	require.True(t, confirmer.Allowed("https://us1.list-manage.com/subscribe/confirm"))
+	require.False(t, confirmer.Allowed("https://notexample.com/confirm"))
+	require.False(t, confirmer.Allowed("https://example.com.evil.net/confirm"))
+	require.False(t, confirmer.Allowed("ftp://example.com/confirm"))
+}
+
+func TestConfirmOnlyFollowsAllowedRedirects(t *testing.T) {
+	var elsewhere []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elsewhere = append(elsewhere, r.URL.Path)
+	}))
+	defer other.Close()
+
+	var followed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = append(followed, r.URL.Path)
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/confirm", http.StatusFound)
+		case "/open":
+			// localhost isn't allowlisted, though it's the same machine as 127.0.0.1
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/internal", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	confirmer := NewConfirmer(server.Client(), []string{"127.0.0.1"})
+	ctx := context.Background()
+
+	require.NoError(t, confirmer.Confirm(ctx, server.URL+"/moved"))
+	require.Equal(t, []string{"/moved", "/confirm"}, followed)
+
+	require.Error(t, confirmer.Confirm(ctx, server.URL+"/open"))
+	require.Empty(t, elsewhere)
+
+	// without a client of its own, only public addresses are connected to
+	require.ErrorIs(t, NewConfirmer(nil, []string{"127.0.0.1"}).Confirm(ctx, server.URL+"/confirm"), errPrivateAddress)
+}
+
+func TestDeliverConfirmsSubscriptions(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	var followed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = append(followed, r.URL.String())
+	}))
+	defer server.Close()
+
+	letters := newsletter.NewQueue(10)
+	deliverer := NewDeliverer(logger, &db, letters)
+	deliverer.SetConfirmer(NewConfirmer(server.Client(), []string{"127.0.0.1"}))
+
+	deliver := func(id string, link string) sqlc.FeedItem {
+		raw := fmt.Sprintf("Message-ID: <%s@example.com>\r\n"+
+			"From: news@example.com\r\n"+
+			"Subject: Please confirm your subscription\r\n"+
+			"Content-Type: text/html\r\n"+
+			"\r\n"+
+			"<a href=\"%s\">Confirm</a>\r\n", id, link)
+		require.NoError(t, deliverer.Deliver(ctx, Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+
+		letter := <-letters.Receive()
+		items, err := db.ListFeedItems(ctx, "abc123")
+		require.NoError(t, err)
+		for _, item := range items {
+			if item.EmailID.Int64 == letter.EmailID {
+				return item
+			}
+		}
+
+		require.FailNow(t, "item not found")
+		return sqlc.FeedItem{}
+	}
+
+	item := deliver("1", server.URL+"/confirm?token=abc")
+	require.Equal(t, server.URL+"/confirm?token=abc", item.ConfirmUrl)
+	require.True(t, item.Confirmed)
+	require.Equal(t, []string{"/confirm?token=abc"}, followed)
+
+	// links to other domains are only shown
+	item = deliver("2", "https://example.com/confirm?token=def")
+	require.Equal(t, "https://example.com/confirm?token=def", item.ConfirmUrl)
+	require.False(t, item.Confirmed)
+	require.Len(t, followed, 1)
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// bodyKind is what a part of a message was rendered from, in order of preference.
+type bodyKind int
+
+const (
+	bodyNone bodyKind = iota
+	bodyPlain
+	bodyHTML
+)
+
+// ConvertEmail returns a message's body as HTML. It walks the message's MIME tree,
+// picking the richest alternative of a multipart/alternative, the root part of a
+// multipart/related and every displayable part of a multipart/mixed. Plain text
+// is converted to HTML, and attachments are left out.
+func ConvertEmail(message message.Entity) (string, error) {
+	body, kind, err := renderEntity(&message)
+	if err != nil {
+		return "", err
+	}
+
+	if kind == bodyNone {
+		return "", fmt.Errorf("no text/html or text/plain part found")
+	}
+
+	return body, nil
+}
+
+// renderEntity renders a part of a message, and any parts inside it, as HTML.
+func renderEntity(entity *message.Entity) (string, bodyKind, error) {
+	mediaType, params, err := entity.Header.ContentType()
+	if err != nil || mediaType == "" {
+		// RFC 2045 makes parts without a usable Content-Type plain text.
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return renderMultipart(entity, mediaType, params)
+	}
+
+	if disposition, _, _ := entity.Header.ContentDisposition(); disposition == "attachment" {
+		return "", bodyNone, nil
+	}
+
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return "", bodyNone, nil
+	}
+
+	content, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return "", bodyNone, fmt.Errorf("failed to read %s part: %w", mediaType, err)
+	}
+
+	// go-message has already decoded parts which declared a charset.
+	if params["charset"] == "" {
+		if decoded, err := decodeCharset(content, mediaType, ""); err == nil {
+			content = decoded
+		}
+	}
+
+	if mediaType == "text/html" {
+		return string(content), bodyHTML, nil
+	}
+
+	flowed := strings.EqualFold(params["format"], "flowed")
+	delSp := strings.EqualFold(params["delsp"], "yes")
+	return textToHTML(string(content), flowed, delSp), bodyPlain, nil
+}
+
+func renderMultipart(entity *message.Entity, mediaType string, params map[string]string) (string, bodyKind, error) {
+	reader := entity.MultipartReader()
+	if reader == nil {
+		return "", bodyNone, fmt.Errorf("failed to read %s parts", mediaType)
+	}
+
+	// start names the root of a multipart/related by its Content-ID, see RFC 2387.
+	start := strings.Trim(params["start"], " <>")
+
+	var bodies []string
+	best, bestKind := "", bodyNone
+	for i := 0; ; i++ {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+			return "", bodyNone, fmt.Errorf("failed to read %s part: %w", mediaType, err)
+		}
+
+		switch mediaType {
+		case "multipart/related":
+			isRoot := strings.Trim(part.Header.Get("Content-Id"), " <>") == start
+			if i > 0 && !isRoot {
+				continue
+			}
+
+			body, kind, err := renderEntity(part)
+			if err != nil {
+				return "", bodyNone, err
+			}
+
+			// The first part is the root unless start names another one.
+			if start == "" || isRoot {
+				return body, kind, nil
+			}
+
+			best, bestKind = body, kind
+
+		case "multipart/alternative":
+			body, kind, err := renderEntity(part)
+			if err != nil {
+				return "", bodyNone, err
+			}
+
+			// Alternatives are listed from plainest to richest.
+			if kind != bodyNone && kind >= bestKind {
+				best, bestKind = body, kind
+			}
+
+		default:
+			body, kind, err := renderEntity(part)
+			if err != nil {
+				return "", bodyNone, err
+			}
+
+			if kind != bodyNone {
+				bodies = append(bodies, body)
+				bestKind = max(bestKind, kind)
+			}
+		}
+	}
+
+	if len(bodies) > 0 {
+		return strings.Join(bodies, "\n"), bestKind, nil
+	}
+
+	return best, bestKind, nil
+}
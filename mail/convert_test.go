@@ -0,0 +1,96 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertEmail(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "plain text alternative",
+			raw: "Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nJust text.\r\n" +
+				"--b\r\nContent-Type: text/calendar\r\n\r\nBEGIN:VCALENDAR\r\n" +
+				"--b--\r\n",
+			want: "<p>Just text.</p>\n",
+		},
+		{
+			name: "richest alternative",
+			raw: "Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nPlain.\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>Rich.</p>\r\n" +
+				"--b--\r\n",
+			want: "<p>Rich.</p>",
+		},
+		{
+			name: "nested multiparts",
+			raw: "Content-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\n\r\nPlain.\r\n" +
+				"--inner\r\nContent-Type: multipart/related; boundary=innermost\r\n\r\n" +
+				"--innermost\r\nContent-Type: text/html\r\n\r\n<p>Rich.</p>\r\n" +
+				"--innermost\r\nContent-Type: image/png\r\nContent-ID: <logo>\r\n\r\npng\r\n" +
+				"--innermost--\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment\r\n\r\n%PDF-1.4\r\n" +
+				"--outer--\r\n",
+			want: "<p>Rich.</p>",
+		},
+		{
+			name: "related start",
+			raw: "Content-Type: multipart/related; boundary=b; start=\"<root>\"\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\nContent-ID: <other>\r\n\r\n<p>Other.</p>\r\n" +
+				"--b\r\nContent-Type: text/html\r\nContent-ID: <root>\r\n\r\n<p>Root.</p>\r\n" +
+				"--b--\r\n",
+			want: "<p>Root.</p>",
+		},
+		{
+			name: "mixed parts",
+			raw: "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>One.</p>\r\n" +
+				"--b\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\niVBORw0KGgo=\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nTwo.\r\n" +
+				"--b--\r\n",
+			want: "<p>One.</p>\n<p>Two.</p>\n",
+		},
+		{
+			name: "plain text",
+			raw: "Content-Type: text/plain\r\n\r\n" +
+				"Hi <reader>,\r\nsee https://go.dev/doc/go1.22. Or (www.example.com/a_(b)).\r\n\r\nBye & thanks\r\n",
+			want: "<p>Hi &lt;reader&gt;,<br>\n" +
+				`see <a href="https://go.dev/doc/go1.22">https://go.dev/doc/go1.22</a>. ` +
+				`Or (<a href="http://www.example.com/a_(b)">www.example.com/a_(b)</a>).</p>` + "\n" +
+				"<p>Bye &amp; thanks</p>\n",
+		},
+		{
+			name: "flowed text",
+			raw: "Content-Type: text/plain; format=flowed; delsp=yes\r\n\r\n" +
+				"A long line which was wra \r\npped.\r\n" +
+				">Quoted and  \r\n>joined.\r\n" +
+				" >Stuffed.\r\n" +
+				"-- \r\nSignature\r\n",
+			want: "<p>A long line which was wrapped.</p>\n" +
+				"<blockquote>\n<p>Quoted and joined.</p>\n</blockquote>\n" +
+				"<p>&gt;Stuffed.<br>\n-- <br>\nSignature</p>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := message.Read(strings.NewReader(test.raw))
+			require.NoError(t, err)
+
+			got, err := ConvertEmail(*msg)
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
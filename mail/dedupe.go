@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// DefaultFingerprint are the headers hashed to recognise a message which has no Message-ID.
+var DefaultFingerprint = []string{"From", "Subject", "Date"}
+
+// messageID returns the key used to recognise copies of a message: its Message-ID, or
+// failing that a hash of the fingerprint headers. It returns "" if the message has no
+// Message-ID and fingerprint is empty or names no headers the message has.
+func messageID(header message.Header, fingerprint []string) string {
+	if id := strings.Trim(strings.TrimSpace(header.Get("Message-Id")), "<>"); id != "" {
+		return id
+	}
+
+	if len(fingerprint) == 0 {
+		return ""
+	}
+
+	found := false
+	hash := sha256.New()
+	for _, key := range fingerprint {
+		value := strings.TrimSpace(header.Get(key))
+		if value != "" {
+			found = true
+		}
+
+		hash.Write([]byte(strings.ToLower(key) + ":" + value + "\n"))
+	}
+
+	if !found {
+		return ""
+	}
+
+	return "fingerprint:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,596 @@
+package mail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/date"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/emersion/go-message"
+	"github.com/microcosm-cc/bluemonday"
+	"go.uber.org/zap"
+)
+
+// ErrMalformedMessage is returned when a message can't be converted, so
+// retrying it won't help.
+var ErrMalformedMessage = errors.New("malformed message")
+
+// Deliverer turns raw messages into newsletters. It is shared by every
+// ingestion path, so mail is handled the same way however it arrives.
+type Deliverer struct {
+	logger      *zap.Logger
+	db          *database.Database
+	queue       *newsletter.Queue
+	fingerprint []string
+	sanitizer   *bluemonday.Policy
+	tracking    *trackingFilter
+	mediaURL    string
+	dns         DNSResolver
+	authServID  string
+	confirmer   *Confirmer
+}
+
+func NewDeliverer(logger *zap.Logger, db *database.Database, queue *newsletter.Queue) *Deliverer {
+	tracking, err := DefaultTrackingRules().filter()
+	if err != nil {
+		panic(fmt.Sprintf("failed to compile built-in tracking rules: %v", err))
+	}
+
+	return &Deliverer{
+		logger:      logger,
+		db:          db,
+		queue:       queue,
+		fingerprint: DefaultFingerprint,
+		sanitizer:   DefaultPolicy().sanitizer(),
+		tracking:    tracking,
+		mediaURL:    "/media/",
+	}
+}
+
+// SetFingerprint sets the headers hashed to deduplicate messages without a Message-ID.
+// An empty list turns fingerprinting off, so those messages are never treated as duplicates.
+func (d *Deliverer) SetFingerprint(headers []string) {
+	d.fingerprint = headers
+}
+
+// SetPolicy sets the allowlist newsletter bodies are sanitized against.
+func (d *Deliverer) SetPolicy(policy Policy) {
+	d.sanitizer = policy.sanitizer()
+}
+
+// SetTrackingRules sets the rules used to remove trackers from newsletter bodies.
+// They replace the built-in rules, so merge with DefaultTrackingRules to extend them.
+func (d *Deliverer) SetTrackingRules(rules TrackingRules) error {
+	tracking, err := rules.filter()
+	if err != nil {
+		return err
+	}
+
+	d.tracking = tracking
+	return nil
+}
+
+// SetMediaURL sets the URL attachments are served from, which their IDs are appended to.
+func (d *Deliverer) SetMediaURL(url string) {
+	d.mediaURL = url
+}
+
+// SetDNSResolver sets the resolver used to authenticate messages with DKIM, SPF and
+// DMARC. Messages aren't authenticated until one is set.
+func (d *Deliverer) SetDNSResolver(resolver DNSResolver) {
+	d.dns = resolver
+}
+
+// SetAuthServID sets the authserv-id of the server whose Authentication-Results headers
+// are trusted, for messages fetched from a mailbox. If it's empty, the topmost header is.
+func (d *Deliverer) SetAuthServID(id string) {
+	d.authServID = id
+}
+
+// SetConfirmer sets the Confirmer which follows the links in subscription confirmation
+// mail. Confirmation links are only shown in feeds, not followed, if it's nil.
+func (d *Deliverer) SetConfirmer(confirmer *Confirmer) {
+	d.confirmer = confirmer
+}
+
+// converted is a message after it has been through the conversion pipeline.
+type converted struct {
+	header  message.Header
+	subject string
+	date    time.Time
+	body    string
+	// confirmURL is the link confirming a subscription, if the message asks for one.
+	confirmURL string
+
+	attachments []attachment
+}
+
+// convert parses a raw RFC 822 message and converts it into a newsletter's contents.
+// internalDate is when the message was received, if known, for messages without a usable Date.
+func (d *Deliverer) convert(raw []byte, internalDate time.Time) (*converted, error) {
+	msg, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, fmt.Errorf("%w: failed to parse message: %v", ErrMalformedMessage, err)
+	}
+
+	contents, err := ConvertEmail(*msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to convert email: %v", ErrMalformedMessage, err)
+	}
+
+	// Trackers are removed first, so anything a redirector unwraps to is sanitized too.
+	contents, err = d.tracking.apply(contents)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to remove trackers: %v", ErrMalformedMessage, err)
+	}
+
+	// Bodies are sanitized before they're stored, so every feed and page can show them as they are.
+	contents = d.sanitizer.Sanitize(contents)
+
+	attachments, err := extractAttachments(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to extract attachments: %v", ErrMalformedMessage, err)
+	}
+
+	contents, err = rewriteCIDs(contents, attachments, d.mediaURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to rewrite inline images: %v", ErrMalformedMessage, err)
+	}
+
+	parsedTime := d.messageDate(msg.Header, internalDate)
+
+	subject, err := msg.Header.Text("Subject")
+	if err != nil {
+		d.logger.Warn("failed to decode subject", zap.Error(err))
+		subject = msg.Header.Get("Subject")
+	}
+
+	confirmURL, _ := detectConfirmation(subject, contents)
+
+	return &converted{
+		header:      msg.Header,
+		subject:     subject,
+		date:        parsedTime,
+		body:        contents,
+		confirmURL:  confirmURL,
+		attachments: attachments,
+	}, nil
+}
+
+// messageDate returns when a message was sent. Mail is never dropped over a bad date: if its
+// Date header can't be parsed, the date it was received is used instead, from internalDate or
+// else the topmost Received header, falling back to now.
+func (d *Deliverer) messageDate(header message.Header, internalDate time.Time) time.Time {
+	sent, err := date.ParseDate(header.Get("Date"))
+	if err == nil {
+		return sent
+	}
+
+	d.logger.Warn("failed to parse date", zap.String("date", header.Get("Date")), zap.Error(err))
+	if !internalDate.IsZero() {
+		return internalDate
+	}
+
+	if received, err := date.ParseReceived(header.Get("Received")); err == nil {
+		return received
+	}
+
+	return time.Now()
+}
+
+// Envelope is what's known about a message besides its contents.
+type Envelope struct {
+	// UID is the message's IMAP UID, if it was fetched over IMAP.
+	UID sql.NullInt64
+	// InternalDate is when the IMAP server received the message, if it was fetched over IMAP.
+	InternalDate time.Time
+	// Recipients are the feeds the message is addressed to.
+	Recipients []Recipient
+	// RemoteIP, Helo and MailFrom are the client's address, the name it greeted with and
+	// the envelope sender, if the message was received over SMTP. SPF is checked with them.
+	RemoteIP net.IP
+	Helo     string
+	MailFrom string
+}
+
+// errDuplicate rolls back delivering a message every recipient's feed already has.
+var errDuplicate = errors.New("duplicate message")
+
+// Deliver converts a raw message, records it as an email, adds it to each recipient's feed
+// and then queues a newsletter for each. Everything is stored before anything is queued, so
+// a message is never lost once Deliver returns. A message without recipients is recorded as
+// undeliverable. The raw message is stored compressed so it can be reprocessed. Recipients
+// whose feed already has the message are skipped, so delivering it again is a no-op. Each
+// feed's filter rules may drop, tag, rename or redirect the message before it's added.
+func (d *Deliverer) Deliver(ctx context.Context, envelope Envelope, raw []byte) error {
+	recipients := envelope.Recipients
+	msg, err := d.convert(raw, envelope.InternalDate)
+	if err != nil {
+		return err
+	}
+
+	compressed, err := compress(raw)
+	if err != nil {
+		return fmt.Errorf("failed to compress message: %w", err)
+	}
+
+	id := messageID(msg.header, d.fingerprint)
+
+	// Format the time to a string that SQLite understands. Dates are stored in UTC,
+	// so they sort in the order messages were sent whatever zone they were sent from.
+	formattedTime := msg.date.UTC().Format("2006-01-02 15:04:05")
+
+	sender := senderAddress(msg.header)
+	auth := d.authenticate(ctx, envelope, msg.header, raw)
+	unsubscribe := parseListUnsubscribe(msg.header)
+	filtered := newFiltered(msg.header, msg.subject, msg.body)
+
+	var letters []*newsletter.NewsLetter
+	// screened counts the recipients whose feeds quarantined, blocked or dropped the message
+	var screened int
+	err = d.db.InTx(ctx, func(q *sqlc.Queries) error {
+		// Screening a message which is already stored isn't counted, so redelivering one
+		// every feed screens out is rolled back as a duplicate rather than stored again.
+		var redelivered bool
+		if id != "" {
+			stored, err := q.HasEmailWithMessageID(ctx, id)
+			if err != nil {
+				return fmt.Errorf("failed to look up message: %w", err)
+			}
+
+			redelivered = stored != 0
+		}
+
+		email, err := q.CreateEmail(ctx, sqlc.CreateEmailParams{
+			Date:          formattedTime,
+			Recipient:     headerText(msg.header, "To"),
+			Sender:        headerText(msg.header, "From"),
+			Subject:       msg.subject,
+			Description:   msg.body,
+			Uid:           envelope.UID,
+			Undeliverable: len(recipients) == 0,
+			Raw:           compressed,
+			MessageID:     id,
+			Dkim:          auth.DKIM,
+			DkimAligned:   auth.DKIMAligned,
+			Spf:           auth.SPF,
+			Dmarc:         auth.DMARC,
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to insert email %q: %w", msg.subject, err)
+		}
+
+		if err := storeAttachments(ctx, q, email.ID, msg.attachments); err != nil {
+			return err
+		}
+
+		// screen reports whether the message may be added to feedID, after blocking or
+		// quarantining it as the feed's sender rules say.
+		screen := func(feedID string, category string) (bool, error) {
+			verdict, reason, err := screenSender(ctx, q, feedID, sender, auth)
+			if err != nil {
+				return false, err
+			}
+
+			switch verdict {
+			case verdictBlock:
+				d.logger.Info("blocked message", zap.String("feed", feedID), zap.String("sender", sender), zap.String("reason", reason))
+				if !redelivered {
+					screened++
+				}
+
+				return false, nil
+			case verdictQuarantine:
+				_, err := q.CreateQuarantine(ctx, sqlc.CreateQuarantineParams{
+					FeedID:    feedID,
+					EmailID:   email.ID,
+					Sender:    sender,
+					Category:  category,
+					Reason:    reason,
+					MessageID: id,
+				})
+
+				// The message is already waiting for review.
+				if errors.Is(err, sql.ErrNoRows) {
+					return false, nil
+				}
+
+				if err != nil {
+					return false, fmt.Errorf("failed to quarantine message: %w", err)
+				}
+
+				d.logger.Info("quarantined message", zap.String("feed", feedID), zap.String("sender", sender), zap.String("reason", reason))
+				screened++
+				return false, nil
+			}
+
+			return true, nil
+		}
+
+		for _, recipient := range recipients {
+			// Senders are remembered even when they're screened out, so they can be
+			// unsubscribed from rather than blocked forever.
+			if sender != "" && !unsubscribe.IsZero() {
+				err := q.UpsertSubscription(ctx, sqlc.UpsertSubscriptionParams{
+					FeedID:            recipient.FeedID,
+					Sender:            sender,
+					UnsubscribeUrl:    unsubscribe.URL,
+					UnsubscribeMailto: unsubscribe.Mailto,
+					OneClick:          unsubscribe.OneClick,
+					LastReceived:      formattedTime,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to store subscription: %w", err)
+				}
+			}
+
+			delivered, err := screen(recipient.FeedID, recipient.Tag)
+			if err != nil {
+				return err
+			}
+
+			if !delivered {
+				continue
+			}
+
+			rules, err := q.ListFilterRules(ctx, recipient.FeedID)
+			if err != nil {
+				return fmt.Errorf("failed to list filter rules: %w", err)
+			}
+
+			result := applyFilters(feedFilters(rules), filtered, recipient.Tag)
+			if result.Drop {
+				d.logger.Info("filtered out message", zap.String("feed", recipient.FeedID), zap.String("subject", msg.subject))
+				if !redelivered {
+					screened++
+				}
+
+				continue
+			}
+
+			// Redirected messages are screened by the target feed's sender rules, so they
+			// can't get round them, but skip its filter rules, so rules can't loop.
+			feedID := recipient.FeedID
+			if result.Target != "" {
+				feedID = result.Target
+				delivered, err := screen(feedID, result.Category)
+				if err != nil {
+					return err
+				}
+
+				if !delivered {
+					continue
+				}
+			}
+
+			letter := newsletter.New(feedID, result.Subject, msg.body, msg.date)
+			letter.Category = result.Category
+			letter.EmailID = email.ID
+			letter.MessageID = id
+
+			_, err = q.CreateFeedItem(ctx, sqlc.CreateFeedItemParams{
+				ID:         newItemID(),
+				FeedID:     letter.Inbox,
+				Subject:    letter.Subject,
+				Body:       letter.Body,
+				Date:       formattedTime,
+				Category:   letter.Category,
+				EmailID:    sql.NullInt64{Int64: email.ID, Valid: true},
+				MessageID:  id,
+				ConfirmUrl: msg.confirmURL,
+				Renamed:    result.Renamed,
+			})
+
+			// The feed already has a copy of the message.
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to insert feed item: %w", err)
+			}
+
+			letters = append(letters, letter)
+		}
+
+		if len(recipients) > 0 && len(letters) == 0 && screened == 0 {
+			return errDuplicate
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errDuplicate) {
+		d.logger.Info("ignoring duplicate message", zap.String("messageID", id))
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(recipients) == 0 {
+		d.logger.Warn("message is not addressed to any feed", zap.String("recipient", msg.header.Get("To")))
+		return nil
+	}
+
+	if msg.confirmURL != "" && len(letters) > 0 {
+		d.confirm(ctx, letters[0].EmailID, msg.confirmURL)
+	}
+
+	for _, letter := range letters {
+		// The item is already stored, but a cached copy of the feed is served without
+		// it until it expires.
+		if err := d.queue.Send(ctx, letter); err != nil {
+			d.logger.Warn("failed to queue newsletter", zap.String("feed", letter.Inbox), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// confirm follows a subscription's confirmation link if there's a Confirmer which allows
+// it, then marks the email's items confirmed. Failing is only logged, as the link is
+// still in the feed to be followed by hand.
+func (d *Deliverer) confirm(ctx context.Context, emailID int64, link string) {
+	if d.confirmer == nil || !d.confirmer.Allowed(link) {
+		return
+	}
+
+	if err := d.confirmer.Confirm(ctx, link); err != nil {
+		d.logger.Warn("failed to confirm subscription", zap.String("link", link), zap.Error(err))
+		return
+	}
+
+	if err := d.db.ConfirmFeedItemsForEmail(ctx, sql.NullInt64{Int64: emailID, Valid: true}); err != nil {
+		d.logger.Error("failed to mark subscription confirmed", zap.Error(err))
+		return
+	}
+
+	d.logger.Info("confirmed subscription", zap.String("link", link))
+}
+
+// storeAttachments records a message's attachments against its email. Attachments are
+// keyed by their email as well as their ID, so a message delivered again, to another
+// feed, keeps its own copy.
+func storeAttachments(ctx context.Context, q *sqlc.Queries, emailID int64, attachments []attachment) error {
+	for _, attachment := range attachments {
+		err := q.CreateAttachment(ctx, sqlc.CreateAttachmentParams{
+			ID:          attachment.id,
+			EmailID:     emailID,
+			ContentID:   attachment.contentID,
+			Filename:    attachment.filename,
+			ContentType: attachment.contentType,
+			Inline:      attachment.inline,
+			Size:        int64(len(attachment.data)),
+			Data:        attachment.data,
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to insert attachment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// newItemID returns a random ID for a feed item.
+func newItemID() string {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// Reprocess runs the stored raw messages for a feed back through the conversion
+// pipeline, updating their emails and feed items in place. If feedID is empty every
+// stored message is reprocessed. It returns the number of messages reprocessed;
+// messages which fail to convert are logged and skipped.
+func (d *Deliverer) Reprocess(ctx context.Context, feedID string) (int, error) {
+	var emails []sqlc.Email
+	var err error
+	if feedID == "" {
+		emails, err = d.db.ListRawEmails(ctx)
+	} else {
+		emails, err = d.db.ListRawEmailsForFeed(ctx, feedID)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to list emails: %w", err)
+	}
+
+	reprocessed := 0
+	for _, email := range emails {
+		if err := d.reprocessEmail(ctx, email); err != nil {
+			d.logger.Error("failed to reprocess email", zap.Int64("id", email.ID), zap.Error(err))
+			continue
+		}
+
+		reprocessed++
+	}
+
+	return reprocessed, nil
+}
+
+func (d *Deliverer) reprocessEmail(ctx context.Context, email sqlc.Email) error {
+	raw, err := decompress(email.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to decompress message: %w", err)
+	}
+
+	// The date stored for the email is the best there was when it was delivered.
+	delivered, err := time.Parse("2006-01-02 15:04:05", email.Date)
+	if err != nil {
+		return fmt.Errorf("failed to parse email date: %w", err)
+	}
+
+	msg, err := d.convert(raw, delivered)
+	if err != nil {
+		return err
+	}
+
+	// Attachments the pipeline didn't extract before are stored; existing ones are kept.
+	if err := storeAttachments(ctx, d.db.Queries, email.ID, msg.attachments); err != nil {
+		return err
+	}
+
+	err = d.db.UpdateEmailDescription(ctx, sqlc.UpdateEmailDescriptionParams{
+		Description: msg.body,
+		ID:          email.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	// Items renamed by filter rules keep their titles.
+	err = d.db.UpdateFeedItemsForEmail(ctx, sqlc.UpdateFeedItemsForEmailParams{
+		Subject:    msg.subject,
+		Body:       msg.body,
+		Date:       msg.date.UTC().Format("2006-01-02 15:04:05"),
+		ConfirmUrl: msg.confirmURL,
+		EmailID:    sql.NullInt64{Int64: email.ID, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update feed items: %w", err)
+	}
+
+	return nil
+}
+
+func compress(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(compressed []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
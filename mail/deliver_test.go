@@ -0,0 +1,183 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/emersion/go-message"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testMessage = "Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+	"From: The Newsletter <the@newsletter.com>\r\n" +
+	"To: abc123@mailfeed.xyz\r\n" +
+	"Subject: Why is Email to RSS Great\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>It just is.</p>\r\n"
+
+func TestReprocess(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	letters := newsletter.NewQueue(1)
+	deliverer := NewDeliverer(logger, &db, letters)
+	err = deliverer.Deliver(context.Background(), Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(testMessage))
+	require.NoError(t, err)
+
+	letter := <-letters.Receive()
+	require.NotZero(t, letter.EmailID)
+
+	// pretend the item was converted by an older, broken pipeline
+	err = db.UpdateFeedItemsForEmail(context.Background(), sqlc.UpdateFeedItemsForEmailParams{
+		Subject: "broken",
+		Body:    "broken",
+		Date:    "2006-01-02 22:04:05",
+		EmailID: sql.NullInt64{Int64: letter.EmailID, Valid: true},
+	})
+	require.NoError(t, err)
+
+	count, err := deliverer.Reprocess(context.Background(), "def456")
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	count, err = deliverer.Reprocess(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	items, err := db.ListFeedItems(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, letter.Subject, items[0].Subject)
+	require.Equal(t, letter.Body, items[0].Body)
+
+	count, err = deliverer.Reprocess(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestDeliverIgnoresDuplicates(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	for _, id := range []string{"abc123", "def456"} {
+		_, err := db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: id, Name: id})
+		require.NoError(t, err)
+	}
+
+	letters := newsletter.NewQueue(10)
+	deliverer := NewDeliverer(logger, &db, letters)
+
+	// deliver returns the number of newsletters queued by delivering raw to feeds
+	deliver := func(raw string, feeds ...string) int {
+		var recipients []Recipient
+		for _, feed := range feeds {
+			recipients = append(recipients, Recipient{FeedID: feed})
+		}
+
+		require.NoError(t, deliverer.Deliver(context.Background(), Envelope{Recipients: recipients}, []byte(raw)))
+
+		queued := len(letters.Receive())
+		for len(letters.Receive()) > 0 {
+			<-letters.Receive()
+		}
+
+		return queued
+	}
+
+	withID := "Message-ID: <1234@newsletter.com>\r\n" + testMessage
+	require.Equal(t, 1, deliver(withID, "abc123"))
+	require.Equal(t, 0, deliver(withID, "abc123"))
+
+	// a copy sent to another feed is still delivered there
+	require.Equal(t, 1, deliver(withID, "abc123", "def456"))
+
+	// messages without a Message-ID are recognised by their fingerprint
+	require.Equal(t, 1, deliver(testMessage, "abc123"))
+	require.Equal(t, 0, deliver(testMessage, "abc123"))
+
+	deliverer.SetFingerprint(nil)
+	require.Equal(t, 1, deliver(testMessage, "def456"))
+	require.Equal(t, 1, deliver(testMessage, "def456"))
+
+	// duplicates aren't stored at all
+	emails, err := db.ListEmails(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 5)
+
+	items, err := db.ListFeedItems(context.Background(), "def456")
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+}
+
+func TestDeliverStoresDatesInUTC(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	deliverer := NewDeliverer(logger, &db, newsletter.NewQueue(10))
+	for _, sent := range []string{
+		"Tue, 03 Jan 2006 07:00:00 +0900",
+		"Mon, 02 Jan 2006 20:00:00 -0700",
+	} {
+		raw := "Message-ID: <" + sent + "@newsletter.com>\r\nDate: " + sent + "\r\n" + testMessage[len("Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n"):]
+		require.NoError(t, deliverer.Deliver(context.Background(), Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+	}
+
+	// the message sent from Tokyo is older, even though its local time is later
+	items, err := db.ListRecentFeedItems(context.Background(), sqlc.ListRecentFeedItemsParams{FeedID: "abc123", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "2006-01-03 03:00:00", items[0].Date)
+
+	_, err = deliverer.Reprocess(context.Background(), "abc123")
+	require.NoError(t, err)
+
+	items, err = db.ListRecentFeedItems(context.Background(), sqlc.ListRecentFeedItemsParams{FeedID: "abc123", Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2006-01-03 03:00:00", "2006-01-02 22:00:00"}, []string{items[0].Date, items[1].Date})
+}
+
+func TestMessageDateFallsBack(t *testing.T) {
+	deliverer := NewDeliverer(zap.NewNop(), nil, nil)
+	sent := time.Date(2006, time.January, 2, 22, 4, 5, 0, time.UTC)
+	internal := time.Date(2006, time.January, 3, 9, 0, 0, 0, time.UTC)
+
+	header := func(fields ...string) message.Header {
+		var h message.Header
+		for i := 0; i < len(fields); i += 2 {
+			h.Add(fields[i], fields[i+1])
+		}
+
+		return h
+	}
+
+	received := "from mail.newsletter.com by mx.mailfeed.xyz; Tue, 3 Jan 2006 10:00:00 +0000"
+
+	got := deliverer.messageDate(header("Date", "Mon, 2 Jan 2006 15:04:05 -0700", "Received", received), internal)
+	require.True(t, sent.Equal(got))
+
+	got = deliverer.messageDate(header("Date", "the day before yesterday", "Received", received), internal)
+	require.True(t, internal.Equal(got))
+
+	got = deliverer.messageDate(header("Date", "the day before yesterday", "Received", received), time.Time{})
+	require.True(t, time.Date(2006, time.January, 3, 10, 0, 0, 0, time.UTC).Equal(got))
+
+	before := time.Now()
+	got = deliverer.messageDate(header("Received", "from mail.newsletter.com by mx.mailfeed.xyz"), time.Time{})
+	require.False(t, got.Before(before))
+}
@@ -0,0 +1,258 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/emersion/go-message"
+	"golang.org/x/net/html"
+)
+
+// Filter rule fields, which say what part of a message a rule's pattern matches.
+const (
+	FilterSender  = "sender"
+	FilterSubject = "subject"
+	FilterHeader  = "header"
+	FilterBody    = "body"
+)
+
+// Filter rule actions.
+const (
+	FilterDrop     = "drop"
+	FilterTag      = "tag"
+	FilterRename   = "rename"
+	FilterRedirect = "redirect"
+)
+
+// ErrInvalidFilterRule is returned for filter rules which can't be applied.
+var ErrInvalidFilterRule = errors.New("invalid filter rule")
+
+// FilterRule acts on the messages delivered to a feed which its pattern matches.
+//
+// Sender rules match the From address with a sender pattern, as sender rules do.
+// Subject, header and body rules match a regular expression against the subject, each
+// value of Header, or the text of the body.
+//
+// Matching messages are dropped from the feed, tagged with Value as their category,
+// renamed to Value, where "{subject}" is replaced with the original subject, or
+// redirected to the feed Target instead.
+type FilterRule struct {
+	Field   string
+	Header  string
+	Pattern string
+	Action  string
+	Value   string
+	Target  string
+}
+
+// Normalize checks a filter rule and returns it as it's stored.
+func (r FilterRule) Normalize() (FilterRule, error) {
+	r.Field = strings.ToLower(strings.TrimSpace(r.Field))
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	r.Header = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(r.Header))
+	r.Value = strings.TrimSpace(r.Value)
+	r.Target = strings.TrimSpace(r.Target)
+
+	switch r.Field {
+	case FilterSender:
+		pattern, err := NormalizeSenderPattern(r.Pattern)
+		if err != nil {
+			return FilterRule{}, fmt.Errorf("%w: %v", ErrInvalidFilterRule, err)
+		}
+
+		r.Pattern = pattern
+	case FilterSubject, FilterHeader, FilterBody:
+		if r.Pattern == "" {
+			return FilterRule{}, fmt.Errorf("%w: pattern is required", ErrInvalidFilterRule)
+		}
+
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return FilterRule{}, fmt.Errorf("%w: %v", ErrInvalidFilterRule, err)
+		}
+	default:
+		return FilterRule{}, fmt.Errorf("%w: field must be sender, subject, header or body", ErrInvalidFilterRule)
+	}
+
+	if r.Field == FilterHeader && r.Header == "" {
+		return FilterRule{}, fmt.Errorf("%w: header rules need a header", ErrInvalidFilterRule)
+	}
+
+	if r.Field != FilterHeader {
+		r.Header = ""
+	}
+
+	switch r.Action {
+	case FilterDrop:
+		r.Value, r.Target = "", ""
+	case FilterTag, FilterRename:
+		if r.Value == "" {
+			return FilterRule{}, fmt.Errorf("%w: %s rules need a value", ErrInvalidFilterRule, r.Action)
+		}
+
+		r.Target = ""
+	case FilterRedirect:
+		if r.Target == "" {
+			return FilterRule{}, fmt.Errorf("%w: redirect rules need a target feed", ErrInvalidFilterRule)
+		}
+
+		r.Value = ""
+	default:
+		return FilterRule{}, fmt.Errorf("%w: action must be drop, tag, rename or redirect", ErrInvalidFilterRule)
+	}
+
+	return r, nil
+}
+
+// storedFilterRule returns a stored filter rule as a FilterRule.
+func storedFilterRule(rule sqlc.FilterRule) FilterRule {
+	return FilterRule{
+		Field:   rule.Field,
+		Header:  rule.Header,
+		Pattern: rule.Pattern,
+		Action:  rule.Action,
+		Value:   rule.Value,
+		Target:  rule.TargetFeedID.String,
+	}
+}
+
+// filtered is what filter rules match a message on.
+type filtered struct {
+	sender  string
+	subject string
+	header  message.Header
+	text    string
+}
+
+// newFiltered returns what filter rules match a message with header, subject and
+// converted body on.
+func newFiltered(header message.Header, subject string, body string) filtered {
+	var text strings.Builder
+	if doc, err := html.Parse(strings.NewReader(body)); err == nil {
+		collectText(doc, &text)
+	}
+
+	return filtered{
+		sender:  senderAddress(header),
+		subject: subject,
+		header:  header,
+		text:    text.String(),
+	}
+}
+
+// matches reports whether rule matches msg. Rules which don't compile never match.
+func (r FilterRule) matches(msg filtered) bool {
+	if r.Field == FilterSender {
+		return msg.sender != "" && matchSender(r.Pattern, msg.sender)
+	}
+
+	pattern, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return false
+	}
+
+	switch r.Field {
+	case FilterSubject:
+		return pattern.MatchString(msg.subject)
+	case FilterHeader:
+		for _, value := range msg.header.Values(r.Header) {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+
+		return false
+	case FilterBody:
+		return pattern.MatchString(msg.text)
+	default:
+		return false
+	}
+}
+
+// FilterResult is what a feed's filter rules did to a message.
+type FilterResult struct {
+	// Drop is set when the message isn't added to the feed.
+	Drop bool `json:"drop,omitempty"`
+	// Target is the feed the message is added to instead, if it was redirected.
+	Target   string `json:"target,omitempty"`
+	Subject  string `json:"subject"`
+	Category string `json:"category,omitempty"`
+	// Renamed is set when Subject isn't the message's own.
+	Renamed bool `json:"renamed,omitempty"`
+}
+
+// applyFilters applies rules, in order, to msg. Tags and renames accumulate, and the
+// first drop or redirect ends the rules. category is the message's category before
+// the rules are applied.
+func applyFilters(rules []FilterRule, msg filtered, category string) FilterResult {
+	result := FilterResult{Subject: msg.subject, Category: category}
+	for _, rule := range rules {
+		if !rule.matches(msg) {
+			continue
+		}
+
+		switch rule.Action {
+		case FilterDrop:
+			result.Drop = true
+			return result
+		case FilterRedirect:
+			result.Target = rule.Target
+			return result
+		case FilterTag:
+			result.Category = rule.Value
+		case FilterRename:
+			result.Subject = strings.ReplaceAll(rule.Value, "{subject}", msg.subject)
+			result.Renamed = true
+		}
+	}
+
+	return result
+}
+
+// storedFiltered returns what filter rules match a stored email on. Emails stored
+// without their raw message are matched on their subject and body alone.
+func storedFiltered(email sqlc.Email) (filtered, error) {
+	if len(email.Raw) == 0 {
+		return newFiltered(message.Header{}, email.Subject, email.Description), nil
+	}
+
+	raw, err := decompress(email.Raw)
+	if err != nil {
+		return filtered{}, fmt.Errorf("failed to decompress message: %w", err)
+	}
+
+	msg, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return filtered{}, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	return newFiltered(msg.Header, email.Subject, email.Description), nil
+}
+
+// DryRunFilter reports whether rule matches a stored email, and what it would do to it.
+func DryRunFilter(rule FilterRule, email sqlc.Email) (FilterResult, bool, error) {
+	filtered, err := storedFiltered(email)
+	if err != nil {
+		return FilterResult{}, false, err
+	}
+
+	if !rule.matches(filtered) {
+		return FilterResult{}, false, nil
+	}
+
+	return applyFilters([]FilterRule{rule}, filtered, ""), true, nil
+}
+
+// feedFilters returns a feed's stored filter rules as FilterRules.
+func feedFilters(rules []sqlc.FilterRule) []FilterRule {
+	filters := make([]FilterRule, 0, len(rules))
+	for _, rule := range rules {
+		filters = append(filters, storedFilterRule(rule))
+	}
+
+	return filters
+}
@@ -0,0 +1,170 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNormalizeFilterRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule FilterRule
+		want FilterRule
+	}{
+		{
+			name: "sender",
+			rule: FilterRule{Field: "Sender", Pattern: "Example.com", Action: "drop", Value: "ignored"},
+			want: FilterRule{Field: FilterSender, Pattern: "*@example.com", Action: FilterDrop},
+		},
+		{
+			name: "header",
+			rule: FilterRule{Field: "header", Header: "list-id", Pattern: "golang", Action: "tag", Value: " go "},
+			want: FilterRule{Field: FilterHeader, Header: "List-Id", Pattern: "golang", Action: FilterTag, Value: "go"},
+		},
+		{
+			name: "redirect",
+			rule: FilterRule{Field: "body", Pattern: "invoice", Action: "redirect", Target: "def456"},
+			want: FilterRule{Field: FilterBody, Pattern: "invoice", Action: FilterRedirect, Target: "def456"},
+		},
+		{name: "unknown field", rule: FilterRule{Field: "to", Pattern: "x", Action: "drop"}},
+		{name: "bad regexp", rule: FilterRule{Field: "subject", Pattern: "(", Action: "drop"}},
+		{name: "header without name", rule: FilterRule{Field: "header", Pattern: "x", Action: "drop"}},
+		{name: "rename without value", rule: FilterRule{Field: "subject", Pattern: "x", Action: "rename"}},
+		{name: "redirect without target", rule: FilterRule{Field: "subject", Pattern: "x", Action: "redirect"}},
+		{name: "unknown action", rule: FilterRule{Field: "subject", Pattern: "x", Action: "archive"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := test.rule.Normalize()
+			if test.want == (FilterRule{}) {
+				require.ErrorIs(t, err, ErrInvalidFilterRule)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.want, rule)
+		})
+	}
+}
+
+func TestDeliverFilters(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, id := range []string{"abc123", "def456"} {
+		_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: id, Name: "Feed " + id})
+		require.NoError(t, err)
+	}
+
+	for _, rule := range []sqlc.CreateFilterRuleParams{
+		{Field: FilterSubject, Pattern: "(?i)sponsored", Action: FilterDrop},
+		{Field: FilterHeader, Header: "List-Id", Pattern: "golang", Action: FilterTag, Value: "go"},
+		{Field: FilterSender, Pattern: "news@example.com", Action: FilterRename, Value: "News: {subject}"},
+		{Field: FilterBody, Pattern: "invoice", Action: FilterRedirect, TargetFeedID: sql.NullString{String: "def456", Valid: true}},
+	} {
+		rule.FeedID = "abc123"
+		_, err := db.CreateFilterRule(ctx, rule)
+		require.NoError(t, err)
+	}
+
+	deliverer := NewDeliverer(logger, &db, newsletter.NewQueue(10))
+	deliver := func(id string, from string, subject string, header string, body string) {
+		raw := fmt.Sprintf("Message-ID: <%s@example.com>\r\n"+
+			"From: %s\r\n"+
+			"Subject: %s\r\n"+
+			"Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n"+
+			"%s"+
+			"Content-Type: text/html\r\n"+
+			"\r\n"+
+			"<p>%s</p>\r\n", id, from, subject, header, body)
+		require.NoError(t, deliverer.Deliver(ctx, Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+	}
+
+	deliver("1", "ads@example.com", "SPONSORED: buy now", "", "Hello")
+	deliver("2", "news@example.com", "Issue 1", "List-Id: <golang.example.com>\r\n", "Hello")
+	deliver("3", "billing@example.com", "Your receipt", "", "Your invoice is attached")
+	deliver("4", "friend@example.com", "Hi", "", "Hello")
+
+	items, err := db.ListFeedItems(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, "News: Issue 1", items[0].Subject)
+	require.Equal(t, "go", items[0].Category)
+	require.True(t, items[0].Renamed)
+	require.Equal(t, "Hi", items[1].Subject)
+
+	// redelivering a dropped message doesn't store it again
+	deliver("1", "ads@example.com", "SPONSORED: buy now", "", "Hello")
+
+	stored, err := db.ListEmails(ctx)
+	require.NoError(t, err)
+
+	dropped := 0
+	for _, email := range stored {
+		if email.MessageID == "1@example.com" {
+			dropped++
+		}
+	}
+
+	require.Equal(t, 1, dropped)
+
+	redirected, err := db.ListFeedItems(ctx, "def456")
+	require.NoError(t, err)
+	require.Len(t, redirected, 1)
+	require.Equal(t, "Your receipt", redirected[0].Subject)
+
+	// reprocessing keeps renamed titles
+	_, err = deliverer.Reprocess(ctx, "abc123")
+	require.NoError(t, err)
+
+	items, err = db.ListFeedItems(ctx, "abc123")
+	require.NoError(t, err)
+	require.Equal(t, "News: Issue 1", items[0].Subject)
+
+	// dry runs match stored emails
+	emails, err := db.ListRawEmailsForFeed(ctx, "def456")
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+
+	result, matched, err := DryRunFilter(FilterRule{Field: FilterBody, Pattern: "invoice", Action: FilterRename, Value: "[billing] {subject}"}, emails[0])
+	require.NoError(t, err)
+	require.True(t, matched)
+	require.Equal(t, FilterResult{Subject: "[billing] Your receipt", Renamed: true}, result)
+
+	_, matched, err = DryRunFilter(FilterRule{Field: FilterSender, Pattern: "*@example.org", Action: FilterDrop}, emails[0])
+	require.NoError(t, err)
+	require.False(t, matched)
+
+	// redirected messages are still screened by the target feed's sender rules
+	_, err = db.CreateSenderRule(ctx, sqlc.CreateSenderRuleParams{FeedID: "def456", Pattern: "spam@example.com", Action: SenderBlock})
+	require.NoError(t, err)
+
+	deliver("5", "spam@example.com", "Overdue", "", "Pay this invoice now")
+
+	require.NoError(t, db.SetFeedRequireDKIM(ctx, sqlc.SetFeedRequireDKIMParams{RequireDkim: true, ID: "def456"}))
+	deliver("6", "billing@example.com", "Another receipt", "", "Your invoice is attached")
+
+	for _, id := range []string{"abc123", "def456"} {
+		items, err := db.ListFeedItems(ctx, id)
+		require.NoError(t, err)
+		for _, item := range items {
+			require.NotContains(t, []string{"Overdue", "Another receipt"}, item.Subject)
+		}
+	}
+
+	quarantined, err := db.ListQuarantine(ctx, "def456")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.Equal(t, "billing@example.com", quarantined[0].Sender)
+}
@@ -4,20 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
+	"database/sql"
 	"errors"
 	"fmt"
-	"io"
-	"mime"
-	"mime/multipart"
-	"mime/quotedprintable"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/alex-emery/mailfeed/database"
 	"github.com/alex-emery/mailfeed/database/sqlc"
-	"github.com/alex-emery/mailfeed/date"
-	"github.com/alex-emery/mailfeed/newsletter"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/emersion/go-message"
@@ -25,337 +20,193 @@ import (
 	"go.uber.org/zap"
 )
 
+// mailboxName is the mailbox newsletters are fetched from.
+const mailboxName = "INBOX"
+
 type Mail struct {
-	c          *imapclient.Client
-	SeqNum     uint32
-	logger     *zap.Logger
-	letterChan chan<- *newsletter.NewsLetter
-	fetchReady chan struct{}
-	cleanups   []func() error
-	db         *database.Database
+	c           *imapclient.Client
+	idleClient  *imapclient.Client
+	idle        *imapclient.IdleCommand
+	SeqNum      uint32
+	uidValidity uint32
+	logger      *zap.Logger
+	resolver    *Resolver
+	deliverer   *Deliverer
+	fetchReady  chan struct{}
+	db          *database.Database
+	dial        dialFunc
+
+	healthInterval time.Duration
+	idleRefresh    time.Duration
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
 }
 
-func (m *Mail) startIdle(logger *zap.Logger, server, username, password string, fetchReady chan<- struct{}) error {
-	options := imapclient.Options{
-		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
-			Expunge: func(seqNum uint32) {
-				logger.Info("message has been expunged", zap.Uint32("seqNum", seqNum))
-			},
-			Mailbox: func(data *imapclient.UnilateralDataMailbox) {
-				if data.NumMessages != nil {
-					logger.Info("a new message has been received")
-					fetchReady <- struct{}{}
-				}
-			},
-		},
-	}
+// dialFunc opens a new authenticated connection to the IMAP server.
+type dialFunc func(options *imapclient.Options) (*imapclient.Client, error)
 
-	c, err := newMailClient(server, username, password, &options)
-	if err != nil {
-		return fmt.Errorf("failed to create mail client: %v", err)
-	}
+func newMailClient(server, username, password string) dialFunc {
+	return func(options *imapclient.Options) (*imapclient.Client, error) {
+		c, err := imapclient.DialTLS(server, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial IMAP server: %v", err)
+		}
 
-	m.cleanups = append(m.cleanups, c.Close)
-	_, err = c.Select("INBOX", nil).Wait()
-	if err != nil {
-		return fmt.Errorf("failed to select INBOX: %v", err)
-	}
+		if err := c.Login(username, password).Wait(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to login: %v", err)
+		}
 
-	logger.Debug("Starting idle")
-	idle, err := c.Idle()
-	if err != nil {
-		return fmt.Errorf("failed to start IDLE: %v", err)
+		return c, nil
 	}
+}
 
-	m.cleanups = append(m.cleanups, idle.Close)
-	return nil
+func New(logger *zap.Logger, server, username, password string, db *database.Database, resolver *Resolver, deliverer *Deliverer) (*Mail, error) {
+	return newMail(logger, newMailClient(server, username, password), db, resolver, deliverer)
 }
 
-func newMailClient(server, username, password string, options *imapclient.Options) (*imapclient.Client, error) {
-	c, err := imapclient.DialTLS(server, options)
-	if err != nil {
-		return nil, fmt.Errorf("failed to dial IMAP server: %v", err)
+func newMail(logger *zap.Logger, dial dialFunc, db *database.Database, resolver *Resolver, deliverer *Deliverer) (*Mail, error) {
+	mail := &Mail{
+		logger:         logger,
+		resolver:       resolver,
+		deliverer:      deliverer,
+		fetchReady:     make(chan struct{}, 1),
+		db:             db,
+		dial:           dial,
+		healthInterval: defaultHealthInterval,
+		idleRefresh:    defaultIdleRefresh,
+		minBackoff:     defaultMinBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		done:           make(chan struct{}),
 	}
 
-	if err := c.Login(username, password).Wait(); err != nil {
-		return nil, fmt.Errorf("failed to login: %v", err)
+	if err := mail.connect(); err != nil {
+		return nil, err
 	}
 
-	return c, nil
+	return mail, nil
 }
 
-func New(logger *zap.Logger, server, username, password string, db *database.Database, feed chan<- *newsletter.NewsLetter) (*Mail, error) {
-	c, err := newMailClient(server, username, password, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create mail client: %v", err)
+// loadCursor sets SeqNum to the UID following the last processed message.
+// If the mailbox has never been synced, or its UIDVALIDITY has changed since
+// the cursor was stored, the whole mailbox is fetched again.
+func (m *Mail) loadCursor(ctx context.Context) error {
+	mailbox, err := m.db.GetMailbox(ctx, mailboxName)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.logger.Info("no stored cursor, syncing whole mailbox")
+		m.SeqNum = 1
+		return nil
 	}
 
-	selectCmd, err := c.Select("INBOX", nil).Wait()
 	if err != nil {
-		return nil, fmt.Errorf("failed to select INBOX: %v", err)
-	}
-
-	logger.Debug("selected INBOX", zap.Uint32("UIDNext", selectCmd.UIDNext))
-
-	fetchReady := make(chan struct{})
-
-	mail := &Mail{
-		c:          c,
-		SeqNum:     selectCmd.UIDNext,
-		logger:     logger,
-		letterChan: feed,
-		fetchReady: fetchReady,
-		cleanups:   []func() error{c.Close},
-		db:         db,
+		return fmt.Errorf("failed to get mailbox: %w", err)
 	}
 
-	err = mail.startIdle(logger, server, username, password, fetchReady)
-	if err != nil {
-		return nil, fmt.Errorf("failed to start IDLE: %v", err)
+	if uint32(mailbox.UidValidity) != m.uidValidity {
+		m.logger.Warn("UIDVALIDITY has changed, resyncing whole mailbox",
+			zap.Int64("stored", mailbox.UidValidity), zap.Uint32("current", m.uidValidity))
+		m.SeqNum = 1
+		return nil
 	}
 
-	return mail, nil
-}
-
-func (m *Mail) Close() {
-	for _, cleanup := range m.cleanups {
-		if err := cleanup(); err != nil {
-			m.logger.Error("failed to cleanup", zap.Error(err))
-		}
-	}
+	m.SeqNum = uint32(mailbox.LastUid) + 1
+	return nil
 }
 
-func (m *Mail) StartFetch() {
-	m.logger.Info("starting fetch loop")
-	for {
-		<-m.fetchReady
-		m.Fetch()
-	}
+// saveCursor records uid as the last processed message.
+func (m *Mail) saveCursor(ctx context.Context, uid uint32) error {
+	return m.db.UpsertMailbox(ctx, sqlc.UpsertMailboxParams{
+		Name:        mailboxName,
+		UidValidity: int64(m.uidValidity),
+		LastUid:     int64(uid),
+	})
 }
 
-// Fetches a single email from the server.
-func (m *Mail) Fetch() {
+// Fetch fetches every message from SeqNum up to the end of the mailbox,
+// advancing and persisting the cursor as each message is processed. Malformed
+// messages are logged and skipped, as retrying them won't help. Any other failure
+// stops the fetch with the cursor on the failed message, so it's retried next time.
+func (m *Mail) Fetch() error {
 	m.logger.Info("fetching messages", zap.Uint32("UID", m.SeqNum))
-	seqSet := imap.SeqSetNum(m.SeqNum)
+	seqSet := imap.SeqSetRange(m.SeqNum, 0)
 	fetchOptions := &imap.FetchOptions{
-		UID:      true,
-		Flags:    true,
-		Envelope: true,
+		UID:          true,
+		Flags:        true,
+		Envelope:     true,
+		InternalDate: true,
 		BodySection: []*imap.FetchItemBodySection{
-			{Specifier: imap.PartSpecifierHeader},
-			{Specifier: imap.PartSpecifierText},
+			{Specifier: imap.PartSpecifierNone},
 		},
 	}
 
-	getMessages := func() []*imapclient.FetchMessageBuffer {
-		messages, err := m.c.UIDFetch(seqSet, fetchOptions).Collect()
-		if err != nil {
-			m.logger.Error("failed to fetch messages", zap.Error(err))
-			return nil
-		}
-
-		return messages
+	messages, err := m.c.UIDFetch(seqSet, fetchOptions).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to fetch messages: %w", err)
 	}
 
-	var messages []*imapclient.FetchMessageBuffer
-	retry := 0
-	for messages = getMessages(); messages == nil && retry < 10; messages = getMessages() {
-		m.logger.Debug("retrying fetch", zap.Int("retry", retry))
-		time.Sleep(1 * time.Second)
-		retry += 1
-	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].UID < messages[j].UID
+	})
 
 	for _, msg := range messages {
-		m.logger.Info("message received", zap.Uint32("UID", msg.UID), zap.String("subject", msg.Envelope.Subject))
-		var header message.Header
-		var body string
-
-		for k, buf := range msg.BodySection {
-			if k.Specifier == imap.PartSpecifierHeader {
-				reader := bufio.NewReader(bytes.NewReader(buf))
-
-				txtHeader, err := textproto.ReadHeader(reader)
-				if err != nil {
-					m.logger.Error("failed to parse header", zap.Error(err))
-					continue
-				}
-
-				header = message.Header{
-					Header: txtHeader,
-				}
-			}
-
-			if k.Specifier == imap.PartSpecifierText {
-				body = string(buf)
-			}
-		}
-
-		parsedMessage, err := message.New(header, strings.NewReader(body))
-		if err != nil {
-			m.logger.Error("failed to parse message", zap.Error(err))
-			continue
-		}
-
-		contents, err := ConvertEmail(*parsedMessage)
-		if err != nil {
-			m.logger.Error("failed to convert email", zap.Error(err))
+		// "n:*" always includes the highest UID in the mailbox, even when it is below n.
+		if msg.UID < m.SeqNum {
 			continue
 		}
 
-		m.logger.Info("message converted", zap.Uint32("UID", msg.UID))
-
-		// Parse the date string
-		parsedTime, err := date.ParseDate(parsedMessage.Header.Get("Date"))
-		if err != nil {
-			m.logger.Error("failed to parse date", zap.Error(err))
-			continue
+		err := m.processMessage(msg)
+		if err != nil && !errors.Is(err, ErrMalformedMessage) {
+			return fmt.Errorf("failed to process message %d: %w", msg.UID, err)
 		}
 
-		// Format the time to a string that SQLite understands
-		formattedTime := parsedTime.Format("2006-01-02 15:04:05")
-
-		_, err = m.db.CreateEmail(context.Background(), sqlc.CreateEmailParams{
-			ID:          int64(msg.UID),
-			Date:        formattedTime,
-			Recipient:   parsedMessage.Header.Get("To"),
-			Sender:      parsedMessage.Header.Get("From"),
-			Subject:     parsedMessage.Header.Get("Subject"),
-			Description: contents,
-		})
-
 		if err != nil {
-			m.logger.Error("failed to insert email", zap.Error(err), zap.String("subject", parsedMessage.Header.Get("Subject")))
-			continue
+			m.logger.Error("skipping malformed message", zap.Uint32("UID", msg.UID), zap.Error(err))
 		}
 
-		destinationInbox := strings.TrimSpace(strings.Split(parsedMessage.Header.Get("To"), "@")[0])
-		inbox, err := m.db.GetFeed(context.Background(), destinationInbox)
-		if err != nil {
-			m.logger.Error("failed to find destination inbox", zap.Error(err))
-		}
-
-		m.letterChan <- newsletter.New(inbox.ID, msg.Envelope.Subject, contents, parsedTime)
-
 		m.SeqNum = msg.UID + 1
-	}
-}
-
-func ConvertEmail(message message.Entity) (string, error) {
-	// Retrieve the Content-Type header and parse it to get the boundary value
-	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
-	if err != nil {
-		return "", fmt.Errorf("error parsing MIME header: %v", err)
-	}
-
-	if strings.HasPrefix(mediaType, "multipart/") {
-		parts, err := ParsePart(message.Body, params["boundary"])
-		if err != nil {
-			return "", fmt.Errorf("error parsing MIME parts: %v", err)
-		}
-
-		if strings.HasPrefix(mediaType, "multipart/alternative") { // try html first, then plain text
-			for _, part := range parts {
-				if part.ContentType == "text/html" {
-					return part.Content, nil
-				}
-			}
-			for _, part := range parts {
-				if part.ContentType == "plain/text" {
-					return part.Content, nil
-				}
-			}
-
-			return "", fmt.Errorf("no text/html or text/plain part found")
-		}
-
-		body := ""
-		for _, part := range parts {
-			body += "\n" + part.Content
+		if err := m.saveCursor(context.Background(), msg.UID); err != nil {
+			m.logger.Error("failed to save mailbox cursor", zap.Uint32("UID", msg.UID), zap.Error(err))
 		}
-
-		return body, nil
 	}
 
-	contents, err := io.ReadAll(message.Body)
-	return string(contents), err
-}
-
-type Part struct {
-	ContentType string
-	Content     string
+	return nil
 }
 
-func ParsePart(mimeData io.Reader, boundary string) ([]Part, error) {
-	reader := multipart.NewReader(mimeData, boundary)
-	if reader == nil {
-		return nil, fmt.Errorf("error creating MIME multipart reader")
-	}
-
-	var parts []Part
-	for {
-		new_part, err := reader.NextPart()
-		if errors.Is(err, io.EOF) {
-			break
-		}
-
-		if err != nil {
-			return nil, err
+// processMessage converts a fetched message, stores it and passes it on to its feed.
+func (m *Mail) processMessage(msg *imapclient.FetchMessageBuffer) error {
+	m.logger.Info("message received", zap.Uint32("UID", msg.UID), zap.String("subject", msg.Envelope.Subject))
+	var raw []byte
+	for k, buf := range msg.BodySection {
+		if k.Specifier == imap.PartSpecifierNone && len(k.Part) == 0 {
+			raw = buf
 		}
-
-		mediaType, _, err := mime.ParseMediaType(new_part.Header.Get("Content-Type"))
-		if err != nil {
-			return nil, err
-		}
-
-		if strings.HasPrefix(mediaType, "multipart/") {
-			nestedParts, err := ParsePart(new_part, boundary)
-			if err != nil {
-				return nil, err
-			}
-
-			parts = append(parts, nestedParts...)
-
-		} else {
-			partString, err := PartToString(new_part)
-			if err != nil {
-				return nil, err
-			}
-
-			parts = append(parts, Part{ContentType: mediaType, Content: partString})
-		}
-
 	}
 
-	return parts, nil
-}
-
-func PartToString(part *multipart.Part) (string, error) {
-	part_data, err := io.ReadAll(part)
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
 	if err != nil {
-		return "", fmt.Errorf("error reading MIME part - %v", err)
+		return fmt.Errorf("%w: failed to parse header: %v", ErrMalformedMessage, err)
 	}
 
-	content_transfer_encoding := strings.ToUpper(part.Header.Get("Content-Transfer-Encoding"))
-
-	switch {
-
-	case strings.Compare(content_transfer_encoding, "BASE64") == 0:
-		decoded_content, err := base64.StdEncoding.DecodeString(string(part_data))
-		if err != nil {
-			return "", fmt.Errorf("error decoding base64 - %v", err)
-		}
+	recipients, err := m.resolver.ResolveHeader(context.Background(), message.Header{Header: header})
+	if err != nil {
+		return fmt.Errorf("failed to resolve recipients: %w", err)
+	}
 
-		return string(decoded_content), nil
+	envelope := Envelope{
+		UID:          sql.NullInt64{Int64: int64(msg.UID), Valid: true},
+		InternalDate: msg.InternalDate,
+		Recipients:   recipients,
+	}
 
-	case strings.Compare(content_transfer_encoding, "QUOTED-PRINTABLE") == 0:
-		decoded_content, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(part_data)))
-		if err != nil {
-			return "", fmt.Errorf("error decoding quoted-printable - %v", err)
-		}
+	if err := m.deliverer.Deliver(context.Background(), envelope, raw); err != nil {
+		return err
+	}
 
-		return string(decoded_content), nil
+	m.logger.Info("message delivered", zap.Uint32("UID", msg.UID))
 
-	default:
-		return string(part_data), nil
-	}
+	return nil
 }
@@ -3,12 +3,15 @@ package mail
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"os"
 	"testing"
 
+	"github.com/alex-emery/mailfeed/database"
 	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/textproto"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestConvertEmailHandlesAlternative(t *testing.T) {
@@ -36,3 +39,24 @@ func TestConvertEmailHandlesAlternative(t *testing.T) {
 	require.Equal(t, "<html><head><meta charset=\"utf-8\"><title>The News Letter</title>\r\n</head><body>Wow this is like the text/html version.</body></html>", email)
 
 }
+
+func TestLoadCursor(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	m := &Mail{logger: logger, db: &db, uidValidity: 100}
+
+	// nothing stored yet, so the whole mailbox is synced
+	require.NoError(t, m.loadCursor(context.Background()))
+	require.Equal(t, uint32(1), m.SeqNum)
+
+	require.NoError(t, m.saveCursor(context.Background(), 41))
+	require.NoError(t, m.loadCursor(context.Background()))
+	require.Equal(t, uint32(42), m.SeqNum)
+
+	// a new UIDVALIDITY invalidates every stored UID
+	m.uidValidity = 200
+	require.NoError(t, m.loadCursor(context.Background()))
+	require.Equal(t, uint32(1), m.SeqNum)
+}
@@ -0,0 +1,160 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/emersion/go-message"
+)
+
+// recipientHeaders are the headers searched for feed addresses. Forwarded mail
+// often only names the feed in Delivered-To, X-Original-To or Envelope-To.
+var recipientHeaders = []string{"To", "Cc", "Delivered-To", "X-Original-To", "Envelope-To"}
+
+// ErrNoFeed is returned when an address doesn't belong to a feed.
+var ErrNoFeed = errors.New("address does not belong to a feed")
+
+// Recipient is a feed a message is delivered to, along with the tag given by a
+// plus or dot address, e.g. "tech" for abc123+tech@domain.
+type Recipient struct {
+	FeedID string
+	Tag    string
+}
+
+// Resolver maps addresses on the configured domain to the feeds they belong to.
+// The local part of an address may be a feed ID or one of its aliases, optionally
+// followed by a tag separated with '+' or '.'.
+type Resolver struct {
+	db     *database.Database
+	domain string
+}
+
+func NewResolver(db *database.Database, domain string) *Resolver {
+	return &Resolver{
+		db:     db,
+		domain: domain,
+	}
+}
+
+// Resolve returns the recipient address belongs to, or ErrNoFeed.
+func (r *Resolver) Resolve(ctx context.Context, address string) (Recipient, error) {
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || !strings.EqualFold(address[at+1:], r.domain) {
+		return Recipient{}, ErrNoFeed
+	}
+
+	local := address[:at]
+	feedID, err := r.lookup(ctx, local)
+	if err == nil {
+		return Recipient{FeedID: feedID}, nil
+	}
+
+	if !errors.Is(err, ErrNoFeed) {
+		return Recipient{}, err
+	}
+
+	sep := strings.IndexAny(local, "+.")
+	if sep <= 0 {
+		return Recipient{}, ErrNoFeed
+	}
+
+	feedID, err = r.lookup(ctx, local[:sep])
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	return Recipient{FeedID: feedID, Tag: strings.ToLower(local[sep+1:])}, nil
+}
+
+// lookup returns the ID of the feed name refers to, either by its address or by alias.
+// A feed's address is its ID until the address is rotated.
+func (r *Resolver) lookup(ctx context.Context, name string) (string, error) {
+	feed, err := r.db.GetFeedByAddress(ctx, name)
+	if err == nil {
+		return feed.ID, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	alias, err := r.db.GetFeedAlias(ctx, strings.ToLower(name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoFeed
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to get feed alias: %w", err)
+	}
+
+	// Rotated addresses are kept as disabled aliases, so they no longer receive mail.
+	if alias.Disabled {
+		return "", ErrNoFeed
+	}
+
+	return alias.FeedID, nil
+}
+
+// ResolveHeader returns every recipient addressed in header, with one recipient per feed.
+// If a feed is addressed more than once, the first tag given is used.
+func (r *Resolver) ResolveHeader(ctx context.Context, header message.Header) ([]Recipient, error) {
+	var recipients []Recipient
+	seen := make(map[string]int)
+	for _, key := range recipientHeaders {
+		for _, value := range header.Values(key) {
+			for _, address := range parseAddressList(value) {
+				recipient, err := r.Resolve(ctx, address)
+				if errors.Is(err, ErrNoFeed) {
+					continue
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				i, ok := seen[recipient.FeedID]
+				if !ok {
+					seen[recipient.FeedID] = len(recipients)
+					recipients = append(recipients, recipient)
+				} else if recipients[i].Tag == "" {
+					recipients[i].Tag = recipient.Tag
+				}
+			}
+		}
+	}
+
+	return recipients, nil
+}
+
+// parseAddressList returns the addresses in an address list header. Values
+// which aren't valid RFC 5322 lists, as some MTAs write to Delivered-To and
+// friends, are split on commas instead.
+func parseAddressList(value string) []string {
+	var addresses []string
+	list, err := mail.ParseAddressList(value)
+	if err == nil {
+		for _, address := range list {
+			addresses = append(addresses, address.Address)
+		}
+
+		return addresses
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if start := strings.LastIndex(part, "<"); start != -1 {
+			part = strings.TrimSuffix(part[start+1:], ">")
+		}
+
+		if part != "" {
+			addresses = append(addresses, part)
+		}
+	}
+
+	return addresses
+}
@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/emersion/go-message"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestResolveHeader(t *testing.T) {
+	db, err := database.New(zap.NewNop(), ":memory:")
+	require.NoError(t, err)
+
+	for _, id := range []string{"abc123", "def456", "ghi789"} {
+		_, err := db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: id, Name: id})
+		require.NoError(t, err)
+	}
+
+	_, err = db.CreateFeedAlias(context.Background(), sqlc.CreateFeedAliasParams{Alias: "weekly-go", FeedID: "def456"})
+	require.NoError(t, err)
+
+	// ghi789's address has been rotated to rot000
+	err = db.UpdateFeedAddress(context.Background(), sqlc.UpdateFeedAddressParams{Address: "rot000", ID: "ghi789"})
+	require.NoError(t, err)
+
+	err = db.CreateDisabledFeedAlias(context.Background(), sqlc.CreateDisabledFeedAliasParams{Alias: "ghi789", FeedID: "ghi789"})
+	require.NoError(t, err)
+
+	resolver := NewResolver(&db, "mailfeed.xyz")
+
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		want    []Recipient
+	}{
+		{
+			name:    "bare address",
+			headers: map[string][]string{"To": {"abc123@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "abc123"}},
+		},
+		{
+			name:    "display name",
+			headers: map[string][]string{"To": {`"My Feed" <abc123@mailfeed.xyz>`}},
+			want:    []Recipient{{FeedID: "abc123"}},
+		},
+		{
+			name:    "multiple addresses",
+			headers: map[string][]string{"To": {"someone@example.com, Feed <abc123@MailFeed.xyz>, def456@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "abc123"}, {FeedID: "def456"}},
+		},
+		{
+			name: "cc",
+			headers: map[string][]string{
+				"To": {"someone@example.com"},
+				"Cc": {"def456@mailfeed.xyz"},
+			},
+			want: []Recipient{{FeedID: "def456"}},
+		},
+		{
+			name: "forwarded",
+			headers: map[string][]string{
+				"To":            {"me@example.com"},
+				"Delivered-To":  {"me@example.com", "abc123@mailfeed.xyz"},
+				"X-Original-To": {"abc123@mailfeed.xyz"},
+			},
+			want: []Recipient{{FeedID: "abc123"}},
+		},
+		{
+			name: "envelope to",
+			headers: map[string][]string{
+				"To":          {"undisclosed-recipients:;"},
+				"Envelope-To": {"<def456@mailfeed.xyz>"},
+			},
+			want: []Recipient{{FeedID: "def456"}},
+		},
+		{
+			name:    "plus address",
+			headers: map[string][]string{"To": {"abc123+Tech@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "abc123", Tag: "tech"}},
+		},
+		{
+			name:    "dot address",
+			headers: map[string][]string{"To": {"abc123.tech@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "abc123", Tag: "tech"}},
+		},
+		{
+			name:    "alias",
+			headers: map[string][]string{"To": {"Weekly-Go@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "def456"}},
+		},
+		{
+			name:    "tagged alias",
+			headers: map[string][]string{"To": {"weekly-go+generics@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "def456", Tag: "generics"}},
+		},
+		{
+			name: "same feed twice",
+			headers: map[string][]string{
+				"To":           {"abc123@mailfeed.xyz"},
+				"Delivered-To": {"abc123+tech@mailfeed.xyz"},
+			},
+			want: []Recipient{{FeedID: "abc123", Tag: "tech"}},
+		},
+		{
+			name:    "rotated address",
+			headers: map[string][]string{"To": {"rot000+tech@mailfeed.xyz"}},
+			want:    []Recipient{{FeedID: "ghi789", Tag: "tech"}},
+		},
+		{
+			name:    "disabled address",
+			headers: map[string][]string{"To": {"ghi789@mailfeed.xyz, ghi789+tech@mailfeed.xyz"}},
+		},
+		{
+			name:    "wrong domain",
+			headers: map[string][]string{"To": {"abc123@example.com"}},
+		},
+		{
+			name:    "unknown feed",
+			headers: map[string][]string{"To": {"nope@mailfeed.xyz"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipients, err := resolver.ResolveHeader(context.Background(), message.HeaderFromMap(tt.headers))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, recipients)
+		})
+	}
+}
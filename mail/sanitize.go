@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Policy is the allowlist newsletter HTML is sanitized against. Elements and
+// attributes it doesn't name are removed, keeping the text inside removed elements
+// other than scripts and styles. It can be loaded from a JSON file with LoadPolicy.
+type Policy struct {
+	// Elements are the elements which are kept.
+	Elements []string `json:"elements"`
+	// Attributes are the attributes kept on every allowed element.
+	Attributes []string `json:"attributes"`
+	// ElementAttributes are the attributes kept on particular elements.
+	ElementAttributes map[string][]string `json:"elementAttributes"`
+	// URLSchemes are the schemes links and images may use. Relative URLs are
+	// removed, as they'd resolve against wherever the newsletter is read.
+	URLSchemes []string `json:"urlSchemes"`
+	// Styles are the CSS properties kept in style attributes.
+	Styles []string `json:"styles"`
+}
+
+// DefaultPolicy keeps the markup newsletters lay themselves out with: text formatting,
+// lists, tables, images and links, along with the presentational attributes and
+// inline styles used by HTML email.
+func DefaultPolicy() Policy {
+	return Policy{
+		Elements: []string{
+			"a", "abbr", "b", "blockquote", "br", "caption", "center", "cite", "code", "col",
+			"colgroup", "dd", "del", "div", "dl", "dt", "em", "figcaption", "figure", "font",
+			"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "ins", "li", "mark", "ol",
+			"p", "pre", "q", "s", "small", "span", "strike", "strong", "sub", "sup", "table",
+			"tbody", "td", "tfoot", "th", "thead", "tr", "u", "ul",
+		},
+		Attributes: []string{
+			"align", "bgcolor", "border", "color", "dir", "height", "lang", "title", "valign", "width",
+		},
+		ElementAttributes: map[string][]string{
+			"a":     {"href", "name"},
+			"img":   {"src", "alt"},
+			"font":  {"face", "size"},
+			"ol":    {"start", "type"},
+			"table": {"cellpadding", "cellspacing"},
+			"td":    {"colspan", "rowspan"},
+			"th":    {"colspan", "rowspan"},
+		},
+		URLSchemes: []string{"http", "https", "mailto"},
+		Styles: []string{
+			"background-color", "border", "border-bottom", "border-collapse", "border-color",
+			"border-left", "border-radius", "border-right", "border-spacing", "border-style",
+			"border-top", "border-width", "color", "display", "font", "font-family", "font-size",
+			"font-style", "font-weight", "height", "letter-spacing", "line-height", "margin",
+			"margin-bottom", "margin-left", "margin-right", "margin-top", "max-width", "min-width",
+			"padding", "padding-bottom", "padding-left", "padding-right", "padding-top",
+			"table-layout", "text-align", "text-decoration", "text-transform", "vertical-align",
+			"white-space", "width", "word-break",
+		},
+	}
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// sanitizer builds the bluemonday policy enforcing p. Links to other sites open
+// in a new tab without sending a referrer.
+func (p Policy) sanitizer() *bluemonday.Policy {
+	sanitizer := bluemonday.NewPolicy()
+	sanitizer.AllowElements(p.Elements...)
+	if len(p.Attributes) > 0 {
+		sanitizer.AllowAttrs(p.Attributes...).Globally()
+	}
+
+	for element, attributes := range p.ElementAttributes {
+		sanitizer.AllowAttrs(attributes...).OnElements(element)
+	}
+
+	if len(p.Styles) > 0 {
+		sanitizer.AllowStyles(p.Styles...).Globally()
+	}
+
+	// cid: URLs refer to the message's own parts, and are rewritten to media URLs after sanitizing.
+	sanitizer.AllowURLSchemes(append([]string{"cid"}, p.URLSchemes...)...)
+	sanitizer.RequireParseableURLs(true)
+	sanitizer.AllowRelativeURLs(false)
+	sanitizer.RequireNoReferrerOnFullyQualifiedLinks(true)
+	sanitizer.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return sanitizer
+}
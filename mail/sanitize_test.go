@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestSanitizeGolden runs anonymised newsletters through the conversion pipeline,
+// so changes to what they're rendered as show up in their golden files.
+func TestSanitizeGolden(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/newsletters/*.eml")
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	deliverer := NewDeliverer(zap.NewNop(), nil, nil)
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			raw, err := os.ReadFile(fixture)
+			require.NoError(t, err)
+
+			converted, err := deliverer.convert(raw, time.Time{})
+			require.NoError(t, err)
+
+			golden := strings.TrimSuffix(fixture, ".eml") + ".golden.html"
+			if *update {
+				require.NoError(t, os.WriteFile(golden, []byte(converted.body), 0o644))
+			}
+
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(want), converted.body)
+		})
+	}
+}
+
+func TestSanitizeRemovesActiveContent(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/sanitize/*.html")
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	// the formatting around the active content is kept
+	wanted := map[string][]string{
+		"mailchimp.html": {
+			`<a href="mailto:hello@shop.example"`,
+			`<span style="color: #e52b50; font-weight: bold">20% off</span>`,
+		},
+		"substack.html": {
+			`<a href="https://go.dev/doc/go1.22"`,
+			`<code>for i := range 10</code>`,
+		},
+	}
+
+	sanitizer := DefaultPolicy().sanitizer()
+	for _, fixture := range fixtures {
+		input, err := os.ReadFile(fixture)
+		require.NoError(t, err)
+
+		got := sanitizer.Sanitize(string(input))
+		lower := strings.ToLower(got)
+		for _, unwanted := range []string{
+			"<script", "document.cookie", "onload", "onclick", "onerror", "<form", "<input",
+			"<iframe", "<object", "<embed", "<svg", "<math", "<link", "<base", "<meta", "<style",
+			"javascript:", "vbscript:", "data:", "expression(", "/relative/path", "evil.example",
+		} {
+			require.NotContains(t, lower, unwanted, fixture)
+		}
+
+		for _, kept := range wanted[filepath.Base(fixture)] {
+			require.Contains(t, got, kept, fixture)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"elements": ["p"], "urlSchemes": ["https"]}`), 0o644))
+
+	policy, err := LoadPolicy(path)
+	require.NoError(t, err)
+	require.Equal(t, Policy{Elements: []string{"p"}, URLSchemes: []string{"https"}}, policy)
+
+	got := policy.sanitizer().Sanitize(`<p style="color: red">Hello <b>world</b></p>`)
+	require.Equal(t, `<p>Hello world</p>`, got)
+}
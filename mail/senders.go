@@ -0,0 +1,283 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/emersion/go-message"
+)
+
+// Sender rule actions.
+const (
+	SenderAllow = "allow"
+	SenderBlock = "block"
+)
+
+// ErrInvalidSenderPattern is returned for sender rule patterns which can't match an address.
+var ErrInvalidSenderPattern = errors.New("sender patterns must be an address or a domain, optionally with * wildcards")
+
+// NormalizeSenderPattern checks a sender rule's pattern and returns it as it's stored.
+// A pattern is an address, such as "news@example.com", or a domain, such as
+// "example.com", which matches every address at it. Either may use "*" wildcards,
+// such as "news-*@example.com" or "*.example.com". Patterns ignore case.
+func NormalizeSenderPattern(pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if !strings.Contains(pattern, "@") {
+		pattern = "*@" + pattern
+	} else if strings.HasPrefix(pattern, "@") {
+		pattern = "*" + pattern
+	}
+
+	local, domain, _ := strings.Cut(pattern, "@")
+	if local == "" || domain == "" || strings.Contains(domain, "@") || strings.ContainsAny(pattern, " \t\r\n<>,;\"()") {
+		return "", ErrInvalidSenderPattern
+	}
+
+	return pattern, nil
+}
+
+// matchSender reports whether sender matches pattern, where "*" matches any run of characters.
+func matchSender(pattern string, sender string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == sender
+	}
+
+	if !strings.HasPrefix(sender, parts[0]) {
+		return false
+	}
+
+	sender = sender[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(sender, part)
+		if i < 0 {
+			return false
+		}
+
+		sender = sender[i+len(part):]
+	}
+
+	return len(sender) >= len(last) && strings.HasSuffix(sender, last)
+}
+
+// senderAddress returns the lower case address a message is from, or "" if it has none.
+func senderAddress(header message.Header) string {
+	address, err := mail.ParseAddress(headerText(header, "From"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(address.Address)
+}
+
+type verdict int
+
+const (
+	verdictDeliver verdict = iota
+	verdictQuarantine
+	verdictBlock
+)
+
+// screenSender decides whether mail from sender is delivered to a feed, quarantined
+// for review or blocked. Feeds which require DKIM quarantine mail without an aligned
+// signature before any rule is checked, since otherwise From could be forged to match
+// one. A rule for sender's exact address beats rules with wildcards, and a block beats
+// an allow. Mail no rule matches is delivered, unless the feed is locked: then the
+// first SenderLimit senders are allowed as they arrive, and anyone after them is
+// quarantined. It returns the reason mail isn't delivered.
+func screenSender(ctx context.Context, q *sqlc.Queries, feedID string, sender string, auth Authentication) (verdict, string, error) {
+	feed, err := q.GetFeed(ctx, feedID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	if feed.RequireDkim && !auth.DKIMAligned {
+		return verdictQuarantine, "message has no DKIM signature from its sender's domain", nil
+	}
+
+	rules, err := q.ListSenderRules(ctx, feedID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to list sender rules: %w", err)
+	}
+
+	var allowed, blocked bool
+	var blockedBy string
+	for _, rule := range rules {
+		if !matchSender(rule.Pattern, sender) {
+			continue
+		}
+
+		if !strings.Contains(rule.Pattern, "*") {
+			if rule.Action == SenderBlock {
+				return verdictBlock, "blocked by " + rule.Pattern, nil
+			}
+
+			return verdictDeliver, "", nil
+		}
+
+		switch rule.Action {
+		case SenderBlock:
+			blocked = true
+			blockedBy = rule.Pattern
+		case SenderAllow:
+			allowed = true
+		}
+	}
+
+	switch {
+	case blocked:
+		return verdictBlock, "blocked by " + blockedBy, nil
+	case allowed:
+		return verdictDeliver, "", nil
+	}
+
+	if !feed.Locked {
+		return verdictDeliver, "", nil
+	}
+
+	if sender == "" {
+		return verdictQuarantine, "feed is locked and the message has no sender", nil
+	}
+
+	count, err := q.CountAutoSenderRules(ctx, feedID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to count senders: %w", err)
+	}
+
+	if count >= feed.SenderLimit {
+		return verdictQuarantine, "feed is locked to its first senders", nil
+	}
+
+	_, err = q.CreateSenderRule(ctx, sqlc.CreateSenderRuleParams{
+		FeedID:  feedID,
+		Pattern: sender,
+		Action:  SenderAllow,
+		Auto:    true,
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to allow sender: %w", err)
+	}
+
+	return verdictDeliver, "", nil
+}
+
+// ReleaseQuarantine moves a quarantined message into its feed, and allows its sender
+// if allow is set. The feed's filter rules are applied to it as they are on delivery,
+// so it may still be dropped, or redirected to another feed whose sender rules it's
+// screened by. It returns the feed the message was added to, which is empty if it
+// wasn't, or sql.ErrNoRows if the feed has no such message.
+func ReleaseQuarantine(ctx context.Context, db *database.Database, feedID string, id int64, allow bool) (string, error) {
+	var added string
+	err := db.InTx(ctx, func(q *sqlc.Queries) error {
+		quarantined, err := q.GetQuarantine(ctx, sqlc.GetQuarantineParams{ID: id, FeedID: feedID})
+		if err != nil {
+			return err
+		}
+
+		email, err := q.GetEmail(ctx, quarantined.EmailID)
+		if err != nil {
+			return fmt.Errorf("failed to get email: %w", err)
+		}
+
+		if err := q.DeleteQuarantine(ctx, quarantined.ID); err != nil {
+			return fmt.Errorf("failed to delete quarantined message: %w", err)
+		}
+
+		if allow && quarantined.Sender != "" {
+			_, err := q.CreateSenderRule(ctx, sqlc.CreateSenderRuleParams{
+				FeedID:  feedID,
+				Pattern: quarantined.Sender,
+				Action:  SenderAllow,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to allow sender: %w", err)
+			}
+		}
+
+		msg, err := storedFiltered(email)
+		if err != nil {
+			return err
+		}
+
+		// Emails stored without their raw message only have the sender they were quarantined for.
+		if msg.sender == "" {
+			msg.sender = quarantined.Sender
+		}
+
+		rules, err := q.ListFilterRules(ctx, feedID)
+		if err != nil {
+			return fmt.Errorf("failed to list filter rules: %w", err)
+		}
+
+		result := applyFilters(feedFilters(rules), msg, quarantined.Category)
+		if result.Drop {
+			return nil
+		}
+
+		target := feedID
+		if result.Target != "" {
+			target = result.Target
+			auth := Authentication{DKIM: email.Dkim, SPF: email.Spf, DMARC: email.Dmarc, DKIMAligned: email.DkimAligned}
+			verdict, reason, err := screenSender(ctx, q, target, msg.sender, auth)
+			if err != nil {
+				return err
+			}
+
+			switch verdict {
+			case verdictBlock:
+				return nil
+			case verdictQuarantine:
+				_, err := q.CreateQuarantine(ctx, sqlc.CreateQuarantineParams{
+					FeedID:    target,
+					EmailID:   email.ID,
+					Sender:    msg.sender,
+					Category:  result.Category,
+					Reason:    reason,
+					MessageID: quarantined.MessageID,
+				})
+
+				// The target feed already has it waiting for review.
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("failed to quarantine message: %w", err)
+				}
+
+				return nil
+			}
+		}
+
+		confirmURL, _ := detectConfirmation(email.Subject, email.Description)
+		_, err = q.CreateFeedItem(ctx, sqlc.CreateFeedItemParams{
+			ID:         newItemID(),
+			FeedID:     target,
+			Subject:    result.Subject,
+			Body:       email.Description,
+			Date:       email.Date,
+			Category:   result.Category,
+			EmailID:    sql.NullInt64{Int64: email.ID, Valid: true},
+			MessageID:  quarantined.MessageID,
+			ConfirmUrl: confirmURL,
+			Renamed:    result.Renamed,
+		})
+
+		// The feed already has a copy of the message.
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to insert feed item: %w", err)
+		}
+
+		added = target
+		return nil
+	})
+
+	return added, err
+}
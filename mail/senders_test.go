@@ -0,0 +1,191 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNormalizeSenderPattern(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"News@Example.com":    "news@example.com",
+		"example.com":         "*@example.com",
+		"@example.com":        "*@example.com",
+		"*.example.com":       "*@*.example.com",
+		"news-*@example.com ": "news-*@example.com",
+	} {
+		got, err := NormalizeSenderPattern(pattern)
+		require.NoError(t, err, pattern)
+		require.Equal(t, want, got, pattern)
+	}
+
+	for _, pattern := range []string{"", "news@", "a@b@c", "News <news@example.com>"} {
+		_, err := NormalizeSenderPattern(pattern)
+		require.ErrorIs(t, err, ErrInvalidSenderPattern, pattern)
+	}
+}
+
+func TestMatchSender(t *testing.T) {
+	require.True(t, matchSender("news@example.com", "news@example.com"))
+	require.False(t, matchSender("news@example.com", "other@example.com"))
+	require.True(t, matchSender("*@example.com", "news@example.com"))
+	require.False(t, matchSender("*@example.com", "news@example.com.evil"))
+	require.True(t, matchSender("*@*.example.com", "news@mail.example.com"))
+	require.False(t, matchSender("*@*.example.com", "news@example.com"))
+	require.True(t, matchSender("news-*@example.com", "news-weekly@example.com"))
+	require.False(t, matchSender("a*a@example.com", "a@example.com"))
+}
+
+func TestDeliverScreensSenders(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	letters := newsletter.NewQueue(10)
+	deliverer := NewDeliverer(logger, &db, letters)
+
+	// deliver reports whether a message from sender was added to the feed
+	n := 0
+	deliver := func(sender string) bool {
+		n++
+		raw := fmt.Sprintf("Message-ID: <%d@example.com>\r\n"+
+			"From: %s\r\n"+
+			"Subject: Hello\r\n"+
+			"\r\n"+
+			"Hello\r\n", n, sender)
+		require.NoError(t, deliverer.Deliver(ctx, Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+
+		select {
+		case <-letters.Receive():
+			return true
+		default:
+			return false
+		}
+	}
+
+	_, err = db.CreateSenderRule(ctx, sqlc.CreateSenderRuleParams{FeedID: "abc123", Pattern: "*@spam.com", Action: SenderBlock})
+	require.NoError(t, err)
+	_, err = db.CreateSenderRule(ctx, sqlc.CreateSenderRuleParams{FeedID: "abc123", Pattern: "friend@spam.com", Action: SenderAllow})
+	require.NoError(t, err)
+
+	// unlocked feeds take anything no rule blocks
+	require.True(t, deliver("news@example.com"))
+	require.False(t, deliver("ads@spam.com"))
+	require.True(t, deliver("Friend <FRIEND@spam.com>"))
+
+	err = db.SetFeedLock(ctx, sqlc.SetFeedLockParams{Locked: true, SenderLimit: 2, ID: "abc123"})
+	require.NoError(t, err)
+
+	// the first two senders are allowed, then the rest are quarantined
+	require.True(t, deliver("first@example.com"))
+	require.True(t, deliver("second@example.com"))
+	require.True(t, deliver("first@example.com"))
+	require.False(t, deliver("third@example.com"))
+	require.True(t, deliver("friend@spam.com"))
+	require.False(t, deliver("ads@spam.com"))
+
+	quarantined, err := db.ListQuarantine(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.Equal(t, "third@example.com", quarantined[0].Sender)
+	require.Equal(t, "feed is locked to its first senders", quarantined[0].Reason)
+
+	count, err := db.CountAutoSenderRules(ctx, "abc123")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+}
+
+func TestDeliverStoresBlockedMessageOnce(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	_, err = db.CreateSenderRule(ctx, sqlc.CreateSenderRuleParams{FeedID: "abc123", Pattern: "*@spam.com", Action: SenderBlock})
+	require.NoError(t, err)
+
+	// an IMAP resync or SMTP retry delivers the same message again
+	deliverer := NewDeliverer(logger, &db, newsletter.NewQueue(10))
+	raw := "Message-ID: <1@spam.com>\r\nFrom: ads@spam.com\r\nSubject: Hello\r\n\r\nHello\r\n"
+	for i := 0; i < 2; i++ {
+		require.NoError(t, deliverer.Deliver(ctx, Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+	}
+
+	emails, err := db.ListEmails(ctx)
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+}
+
+func TestReleaseQuarantineAppliesFilters(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, id := range []string{"abc123", "def456"} {
+		_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: id, Name: "Feed " + id})
+		require.NoError(t, err)
+	}
+
+	// without a DNS resolver nothing is signed, so everything is quarantined
+	require.NoError(t, db.SetFeedRequireDKIM(ctx, sqlc.SetFeedRequireDKIMParams{RequireDkim: true, ID: "abc123"}))
+
+	for _, rule := range []sqlc.CreateFilterRuleParams{
+		{Field: FilterSubject, Pattern: "(?i)sponsored", Action: FilterDrop},
+		{Field: FilterSender, Pattern: "news@example.com", Action: FilterRename, Value: "News: {subject}"},
+		{Field: FilterBody, Pattern: "invoice", Action: FilterRedirect, TargetFeedID: sql.NullString{String: "def456", Valid: true}},
+	} {
+		rule.FeedID = "abc123"
+		_, err := db.CreateFilterRule(ctx, rule)
+		require.NoError(t, err)
+	}
+
+	deliverer := NewDeliverer(logger, &db, newsletter.NewQueue(10))
+	for i, msg := range []struct{ from, subject, body string }{
+		{"ads@example.com", "Sponsored: buy now", "Hello"},
+		{"news@example.com", "Please confirm your subscription", `<a href="https://example.com/confirm?token=abc">Confirm</a>`},
+		{"billing@example.com", "Your receipt", "Your invoice is attached"},
+	} {
+		raw := fmt.Sprintf("Message-ID: <%d@example.com>\r\nFrom: %s\r\nSubject: %s\r\nContent-Type: text/html\r\n\r\n<p>%s</p>\r\n", i, msg.from, msg.subject, msg.body)
+		require.NoError(t, deliverer.Deliver(ctx, Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+	}
+
+	quarantined, err := db.ListQuarantine(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 3)
+
+	var added []string
+	for _, q := range quarantined {
+		feed, err := ReleaseQuarantine(ctx, &db, "abc123", q.ID, false)
+		require.NoError(t, err)
+		added = append(added, feed)
+	}
+
+	require.Equal(t, []string{"", "abc123", "def456"}, added)
+
+	items, err := db.ListFeedItems(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "News: Please confirm your subscription", items[0].Subject)
+	require.True(t, items[0].Renamed)
+	require.Equal(t, "https://example.com/confirm?token=abc", items[0].ConfirmUrl)
+
+	redirected, err := db.ListFeedItems(ctx, "def456")
+	require.NoError(t, err)
+	require.Len(t, redirected, 1)
+	require.Equal(t, "Your receipt", redirected[0].Subject)
+}
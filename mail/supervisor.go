@@ -0,0 +1,258 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultHealthInterval is how often both connections are checked.
+	defaultHealthInterval = 1 * time.Minute
+	// defaultIdleRefresh is how often IDLE is re-issued, well within the
+	// 29 minute limit servers may enforce.
+	defaultIdleRefresh = 10 * time.Minute
+	defaultMinBackoff  = 1 * time.Second
+	defaultMaxBackoff  = 5 * time.Minute
+)
+
+var errClosed = errors.New("mail has been closed")
+
+// connect opens the fetch and IDLE connections, then restores the cursor
+// for the selected mailbox.
+func (m *Mail) connect() error {
+	c, err := m.dial(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create mail client: %v", err)
+	}
+
+	selectCmd, err := c.Select(mailboxName, nil).Wait()
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("failed to select INBOX: %v", err)
+	}
+
+	m.logger.Debug("selected INBOX", zap.Uint32("UIDNext", selectCmd.UIDNext), zap.Uint32("UIDValidity", selectCmd.UIDValidity))
+
+	idleClient, idle, err := m.startIdle()
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("failed to start IDLE: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		idleClient.Close()
+		c.Close()
+		return errClosed
+	}
+
+	// The clients are only published once the cursor is loaded, so a failure
+	// doesn't leave them open for the next attempt to overwrite.
+	m.uidValidity = selectCmd.UIDValidity
+	if err := m.loadCursor(context.Background()); err != nil {
+		idleClient.Close()
+		c.Close()
+		return fmt.Errorf("failed to load mailbox cursor: %v", err)
+	}
+
+	m.c = c
+	m.idleClient = idleClient
+	m.idle = idle
+	return nil
+}
+
+// startIdle opens a second connection which idles on the mailbox and signals
+// fetchReady whenever the server reports new messages.
+func (m *Mail) startIdle() (*imapclient.Client, *imapclient.IdleCommand, error) {
+	options := imapclient.Options{
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Expunge: func(seqNum uint32) {
+				m.logger.Info("message has been expunged", zap.Uint32("seqNum", seqNum))
+			},
+			Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+				if data.NumMessages != nil {
+					m.logger.Info("a new message has been received")
+					// Fetch picks up every new message, so a pending signal is enough.
+					select {
+					case m.fetchReady <- struct{}{}:
+					default:
+					}
+				}
+			},
+		},
+	}
+
+	c, err := m.dial(&options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create mail client: %v", err)
+	}
+
+	if _, err := c.Select(mailboxName, nil).Wait(); err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("failed to select INBOX: %v", err)
+	}
+
+	m.logger.Debug("Starting idle")
+	idle, err := c.Idle()
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("failed to start IDLE: %v", err)
+	}
+
+	return c, idle, nil
+}
+
+// refreshIdle stops and re-issues IDLE, which fails if the IDLE connection
+// has silently gone away.
+func (m *Mail) refreshIdle() error {
+	m.logger.Debug("refreshing idle")
+	if err := m.idle.Close(); err != nil {
+		return fmt.Errorf("failed to stop IDLE: %w", err)
+	}
+
+	if err := m.idle.Wait(); err != nil {
+		return fmt.Errorf("failed to stop IDLE: %w", err)
+	}
+
+	idle, err := m.idleClient.Idle()
+	if err != nil {
+		return fmt.Errorf("failed to start IDLE: %w", err)
+	}
+
+	m.mu.Lock()
+	m.idle = idle
+	m.mu.Unlock()
+
+	return nil
+}
+
+// checkConnections returns an error if either connection is no longer usable.
+func (m *Mail) checkConnections() error {
+	if m.idleClient.State() == imap.ConnStateLogout {
+		return errors.New("IDLE connection has been closed")
+	}
+
+	if err := m.c.Noop().Wait(); err != nil {
+		return fmt.Errorf("fetch connection is unhealthy: %w", err)
+	}
+
+	return nil
+}
+
+// reconnect replaces both connections, backing off exponentially between
+// attempts. It returns false if the Mail was closed before reconnecting.
+func (m *Mail) reconnect() bool {
+	m.mu.Lock()
+	m.closeConnections()
+	m.mu.Unlock()
+
+	backoff := m.minBackoff
+	for {
+		err := m.connect()
+		if err == nil {
+			m.logger.Info("reconnected to IMAP server")
+			return true
+		}
+
+		if errors.Is(err, errClosed) {
+			return false
+		}
+
+		m.logger.Error("failed to reconnect", zap.Error(err), zap.Duration("backoff", backoff))
+		select {
+		case <-m.done:
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, m.maxBackoff)
+	}
+}
+
+// closeConnections closes both connections, which also ends IDLE.
+// The caller must hold m.mu.
+func (m *Mail) closeConnections() {
+	for _, c := range []*imapclient.Client{m.idleClient, m.c} {
+		if c == nil {
+			continue
+		}
+
+		if err := c.Close(); err != nil {
+			m.logger.Debug("failed to close connection", zap.Error(err))
+		}
+	}
+}
+
+// StartFetch catches up on any messages received since the last processed UID,
+// then fetches new messages as the IDLE connection reports them. It supervises
+// both connections, reconnecting and catching up again whenever one is lost or a
+// message fails to be stored, until Close is called.
+func (m *Mail) StartFetch() {
+	health := time.NewTicker(m.healthInterval)
+	defer health.Stop()
+
+	refresh := time.NewTicker(m.idleRefresh)
+	defer refresh.Stop()
+
+	m.logger.Info("catching up on missed messages", zap.Uint32("UID", m.SeqNum))
+	err := m.Fetch()
+
+	m.logger.Info("starting fetch loop")
+	// retry backs off catching up again while it keeps failing, such as when a
+	// message can't be stored until the database recovers.
+	retry := m.minBackoff
+	for {
+		if err != nil {
+			m.logger.Warn("failed to fetch messages, reconnecting", zap.Error(err))
+			if !m.reconnect() {
+				return
+			}
+
+			m.logger.Info("catching up on missed messages", zap.Uint32("UID", m.SeqNum))
+			err = m.Fetch()
+			if err == nil {
+				retry = m.minBackoff
+				continue
+			}
+
+			select {
+			case <-m.done:
+				return
+			case <-time.After(retry):
+			}
+
+			retry = min(retry*2, m.maxBackoff)
+			continue
+		}
+
+		select {
+		case <-m.done:
+			return
+		case <-m.fetchReady:
+			err = m.Fetch()
+		case <-health.C:
+			err = m.checkConnections()
+		case <-refresh.C:
+			err = m.refreshIdle()
+		}
+	}
+}
+
+func (m *Mail) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+
+	m.closed = true
+	close(m.done)
+	m.closeConnections()
+}
@@ -0,0 +1,278 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// killableListener keeps track of accepted connections so a test can drop
+// them all, as a flaky server or network would.
+type killableListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *killableListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+
+	return conn, err
+}
+
+func (l *killableListener) killAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+func startTestServer(t *testing.T) (*killableListener, *imapmemserver.User) {
+	user := imapmemserver.NewUser("user", "password")
+	require.NoError(t, user.Create(mailboxName, nil))
+
+	memServer := imapmemserver.New()
+	memServer.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(*imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return memServer.NewSession(), nil, nil
+		},
+		Caps: imap.CapSet{
+			imap.CapIMAP4rev1: {},
+			imap.CapIMAP4rev2: {},
+		},
+		InsecureAuth: true,
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	listener := &killableListener{Listener: ln}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return listener, user
+}
+
+func appendTestMessage(t *testing.T, user *imapmemserver.User, subject string) {
+	msg := "Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+		"From: The Newsletter <the@newsletter.com>\r\n" +
+		"To: abc123@mailfeed.xyz\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>" + subject + "</p>\r\n"
+
+	_, err := user.Append(mailboxName, bytes.NewReader([]byte(msg)), &imap.AppendOptions{})
+	require.NoError(t, err)
+}
+
+// testDialer dials the test server at addr.
+func testDialer(addr string) dialFunc {
+	return func(options *imapclient.Options) (*imapclient.Client, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		c := imapclient.New(conn, options)
+		if err := c.Login("user", "password").Wait(); err != nil {
+			c.Close()
+			return nil, err
+		}
+
+		return c, nil
+	}
+}
+
+func TestStartFetchReconnects(t *testing.T) {
+	listener, user := startTestServer(t)
+	dial := testDialer(listener.Addr().String())
+
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	// received before mailfeed starts, so only the catch up fetch can find it
+	appendTestMessage(t, user, "first")
+
+	letters := newsletter.NewQueue(10)
+	m, err := newMail(logger, dial, &db, NewResolver(&db, "mailfeed.xyz"), NewDeliverer(logger, &db, letters))
+	require.NoError(t, err)
+
+	m.healthInterval = 50 * time.Millisecond
+	m.idleRefresh = 100 * time.Millisecond
+	m.minBackoff = 10 * time.Millisecond
+	m.maxBackoff = 50 * time.Millisecond
+
+	go m.StartFetch()
+	defer m.Close()
+
+	receive := func() *newsletter.NewsLetter {
+		select {
+		case letter := <-letters.Receive():
+			return letter
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for newsletter")
+			return nil
+		}
+	}
+
+	require.Equal(t, "first", receive().Subject)
+
+	appendTestMessage(t, user, "second")
+	require.Equal(t, "second", receive().Subject)
+
+	listener.killAll()
+	appendTestMessage(t, user, "third")
+	require.Equal(t, "third", receive().Subject)
+
+	listener.killAll()
+	time.Sleep(200 * time.Millisecond)
+	appendTestMessage(t, user, "fourth")
+	require.Equal(t, "fourth", receive().Subject)
+
+	select {
+	case letter := <-letters.Receive():
+		t.Fatalf("unexpected duplicate newsletter %q", letter.Subject)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestFetchRetriesFailedMessages(t *testing.T) {
+	listener, user := startTestServer(t)
+
+	logger := zap.NewNop()
+	path := filepath.Join(t.TempDir(), "mailfeed.db")
+	db, err := database.New(logger, path)
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	// a second connection makes storing feed items fail, as a full disk would
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Exec("CREATE TRIGGER fail_feed_item BEFORE INSERT ON feed_item BEGIN SELECT RAISE(ABORT, 'disk full'); END")
+	require.NoError(t, err)
+
+	appendTestMessage(t, user, "first")
+	appendTestMessage(t, user, "second")
+
+	m, err := newMail(logger, testDialer(listener.Addr().String()), &db, NewResolver(&db, "mailfeed.xyz"), NewDeliverer(logger, &db, newsletter.NewQueue(10)))
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Error(t, m.Fetch())
+	require.Equal(t, uint32(1), m.SeqNum)
+
+	_, err = db.GetMailbox(context.Background(), mailboxName)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = conn.Exec("DROP TRIGGER fail_feed_item")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Fetch())
+	require.Equal(t, uint32(3), m.SeqNum)
+
+	items, err := db.ListFeedItems(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+
+	mailbox, err := db.GetMailbox(context.Background(), mailboxName)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), mailbox.LastUid)
+}
+
+// trackedConn records when it's closed.
+type trackedConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestConnectClosesConnectionsWhenCursorFails(t *testing.T) {
+	listener, _ := startTestServer(t)
+
+	logger := zap.NewNop()
+	path := filepath.Join(t.TempDir(), "mailfeed.db")
+	db, err := database.New(logger, path)
+	require.NoError(t, err)
+
+	// the cursor can't be loaded while the database is failing
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Exec("DROP TABLE mailbox")
+	require.NoError(t, err)
+
+	var conns []*trackedConn
+	dial := func(options *imapclient.Options) (*imapclient.Client, error) {
+		netConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+
+		tracked := &trackedConn{Conn: netConn, closed: make(chan struct{})}
+		conns = append(conns, tracked)
+
+		c := imapclient.New(tracked, options)
+		if err := c.Login("user", "password").Wait(); err != nil {
+			c.Close()
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	_, err = newMail(logger, dial, &db, NewResolver(&db, "mailfeed.xyz"), NewDeliverer(logger, &db, newsletter.NewQueue(10)))
+	require.Error(t, err)
+	require.Len(t, conns, 2)
+
+	for _, tracked := range conns {
+		select {
+		case <-tracked.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection was left open")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// line is a line of plain text, along with how deeply it is quoted.
+type line struct {
+	text  string
+	depth int
+}
+
+// textToHTML converts a plain text body to HTML. Blank lines separate paragraphs,
+// and URLs become links. If the text is format=flowed (RFC 3676), soft line breaks
+// are joined and quoted lines become blockquotes.
+func textToHTML(text string, flowed bool, delSp bool) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+
+	var logical []line
+	if flowed {
+		logical = unflow(lines, delSp)
+	} else {
+		for _, text := range lines {
+			logical = append(logical, line{text: text})
+		}
+	}
+
+	var b strings.Builder
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + strings.Join(paragraph, "<br>\n") + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	depth := 0
+	for _, line := range logical {
+		if line.depth != depth {
+			flush()
+			for ; depth < line.depth; depth++ {
+				b.WriteString("<blockquote>\n")
+			}
+
+			for ; depth > line.depth; depth-- {
+				b.WriteString("</blockquote>\n")
+			}
+		}
+
+		if strings.TrimSpace(line.text) == "" {
+			flush()
+			continue
+		}
+
+		paragraph = append(paragraph, linkify(line.text))
+	}
+
+	flush()
+	for ; depth > 0; depth-- {
+		b.WriteString("</blockquote>\n")
+	}
+
+	return b.String()
+}
+
+// unflow joins the soft line breaks of format=flowed text: lines ending in a space
+// continue on the next line, unless it is quoted differently.
+func unflow(lines []string, delSp bool) []line {
+	var logical []line
+	continuing := false
+	for _, text := range lines {
+		depth := 0
+		for strings.HasPrefix(text, ">") {
+			text = text[1:]
+			depth++
+		}
+
+		// Lines starting with a space, "From " or ">" are stuffed with a space.
+		text = strings.TrimPrefix(text, " ")
+
+		soft := strings.HasSuffix(text, " ") && text != "-- "
+		if soft && delSp {
+			text = text[:len(text)-1]
+		}
+
+		if continuing && logical[len(logical)-1].depth == depth {
+			logical[len(logical)-1].text += text
+		} else {
+			logical = append(logical, line{text: text, depth: depth})
+		}
+
+		continuing = soft
+	}
+
+	return logical
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// linkify escapes text as HTML, turning URLs in it into links.
+func linkify(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range urlPattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		url := trimURL(text[start:end])
+		end = start + len(url)
+
+		href := url
+		if strings.HasPrefix(strings.ToLower(url), "www.") {
+			href = "http://" + url
+		}
+
+		b.WriteString(html.EscapeString(text[last:start]))
+		b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(url) + `</a>`)
+		last = end
+	}
+
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// trimURL removes punctuation which ends the sentence around a URL rather than the URL itself.
+func trimURL(url string) string {
+	for len(url) > 0 {
+		last := url[len(url)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"", last) >= 0:
+		case last == ')' && strings.Count(url, "(") < strings.Count(url, ")"):
+		default:
+			return url
+		}
+
+		url = url[:len(url)-1]
+	}
+
+	return url
+}
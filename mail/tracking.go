@@ -0,0 +1,219 @@
+package mail
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+//go:embed tracking.json
+var builtinTrackingRules []byte
+
+// TrackingRules describe the trackers removed from newsletters: images which report
+// when a newsletter is opened, and links which go through a redirector to report
+// clicks. They can be loaded from a JSON file with LoadTrackingRules.
+type TrackingRules struct {
+	// Pixels are regular expressions matching the URLs of tracking images.
+	Pixels []string `json:"pixels"`
+	// Redirects are the redirectors whose links are replaced by the URL they redirect to.
+	Redirects []RedirectRule `json:"redirects"`
+	// RemoveTinyImages removes images sized 1x1 or smaller, whatever their URL.
+	RemoveTinyImages bool `json:"removeTinyImages"`
+}
+
+// RedirectRule matches the links of a redirector which passes the original URL in its query string.
+type RedirectRule struct {
+	// Pattern is a regular expression matching the redirector's links.
+	Pattern string `json:"pattern"`
+	// Param is the query parameter holding the original URL.
+	Param string `json:"param"`
+}
+
+// DefaultTrackingRules are the built-in rules, covering the trackers used by common
+// newsletter platforms such as Mailchimp, Substack, ConvertKit and SendGrid.
+func DefaultTrackingRules() TrackingRules {
+	var rules TrackingRules
+	if err := json.Unmarshal(builtinTrackingRules, &rules); err != nil {
+		panic(fmt.Sprintf("failed to parse built-in tracking rules: %v", err))
+	}
+
+	return rules
+}
+
+// LoadTrackingRules reads rules from a JSON file.
+func LoadTrackingRules(path string) (TrackingRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TrackingRules{}, fmt.Errorf("failed to read tracking rules: %w", err)
+	}
+
+	var rules TrackingRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return TrackingRules{}, fmt.Errorf("failed to parse tracking rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// Merge returns the rules in both r and other.
+func (r TrackingRules) Merge(other TrackingRules) TrackingRules {
+	return TrackingRules{
+		Pixels:           append(append([]string{}, r.Pixels...), other.Pixels...),
+		Redirects:        append(append([]RedirectRule{}, r.Redirects...), other.Redirects...),
+		RemoveTinyImages: r.RemoveTinyImages || other.RemoveTinyImages,
+	}
+}
+
+// maxRedirects bounds how many redirectors are unwrapped from a single link.
+const maxRedirects = 5
+
+// trackingFilter applies compiled tracking rules to HTML bodies.
+type trackingFilter struct {
+	pixels           []*regexp.Regexp
+	redirects        []redirect
+	removeTinyImages bool
+}
+
+type redirect struct {
+	pattern *regexp.Regexp
+	param   string
+}
+
+// filter compiles the rules' patterns.
+func (r TrackingRules) filter() (*trackingFilter, error) {
+	f := &trackingFilter{removeTinyImages: r.RemoveTinyImages}
+	for _, pattern := range r.Pixels {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pixel pattern %q: %w", pattern, err)
+		}
+
+		f.pixels = append(f.pixels, re)
+	}
+
+	for _, rule := range r.Redirects {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile redirect pattern %q: %w", rule.Pattern, err)
+		}
+
+		f.redirects = append(f.redirects, redirect{pattern: re, param: rule.Param})
+	}
+
+	return f, nil
+}
+
+// apply removes tracking pixels from body and unwraps tracked links.
+func (f *trackingFilter) apply(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	f.walk(doc)
+
+	var buf strings.Builder
+	if err := html.Render(&buf, doc); err != nil {
+		return "", fmt.Errorf("failed to render body: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+func (f *trackingFilter) walk(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode {
+			switch c.DataAtom {
+			case atom.Img:
+				if f.isPixel(c) {
+					n.RemoveChild(c)
+					c = next
+					continue
+				}
+			case atom.A:
+				for i, attr := range c.Attr {
+					if attr.Key == "href" {
+						c.Attr[i].Val = f.unwrap(attr.Val)
+					}
+				}
+			}
+		}
+
+		f.walk(c)
+		c = next
+	}
+}
+
+// isPixel reports whether img is a tracking image.
+func (f *trackingFilter) isPixel(img *html.Node) bool {
+	src := attr(img, "src")
+	for _, pixel := range f.pixels {
+		if pixel.MatchString(src) {
+			return true
+		}
+	}
+
+	return f.removeTinyImages && isTiny(attr(img, "width")) && isTiny(attr(img, "height"))
+}
+
+// unwrap follows link through any redirectors matching the rules, returning the URL it leads to.
+func (f *trackingFilter) unwrap(link string) string {
+	for i := 0; i < maxRedirects; i++ {
+		target, ok := f.target(link)
+		if !ok {
+			break
+		}
+
+		link = target
+	}
+
+	return link
+}
+
+// target returns the URL a redirector's link leads to, if it can be recovered.
+func (f *trackingFilter) target(link string) (string, bool) {
+	for _, redirect := range f.redirects {
+		if !redirect.pattern.MatchString(link) {
+			continue
+		}
+
+		u, err := url.Parse(link)
+		if err != nil {
+			return "", false
+		}
+
+		target, err := url.Parse(u.Query().Get(redirect.param))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			continue
+		}
+
+		return target.String(), true
+	}
+
+	return "", false
+}
+
+// isTiny reports whether an image dimension is at most a pixel.
+func isTiny(dimension string) bool {
+	size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(dimension), "px"))
+	return err == nil && size <= 1
+}
+
+func attr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
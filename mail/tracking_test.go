@@ -0,0 +1,102 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackingFilter(t *testing.T) {
+	tracking, err := DefaultTrackingRules().filter()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "substack pixel",
+			body: `<p>Hi</p><img src="https://eotrx.substackcdn.com/open?token=abc123" alt="">`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "mailchimp pixel",
+			body: `<p>Hi</p><img src="https://shop.us1.list-manage.com/track/open.php?u=1&id=2&e=3" width="1">`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "sendgrid pixel",
+			body: `<p>Hi</p><img src="https://u123.ct.sendgrid.net/wf/open?upn=abc">`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "convertkit pixel",
+			body: `<p>Hi</p><img src="https://open.convertkit-mail2.com/abc123">`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "tiny image",
+			body: `<p>Hi</p><img src="https://tracker.example/p.gif" width="1" height="1px">`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "image kept",
+			body: `<img src="https://substackcdn.com/image/fetch/gopher.png" width="550" height="300">`,
+			want: `<img src="https://substackcdn.com/image/fetch/gopher.png" width="550" height="300"/>`,
+		},
+		{
+			name: "redirect unwrapped",
+			body: `<a href="https://click.convertkit-mail.com/abc?url=https%3A%2F%2Fgo.dev%2Fblog">Blog</a>`,
+			want: `<a href="https://go.dev/blog">Blog</a>`,
+		},
+		{
+			name: "nested redirects unwrapped",
+			body: `<a href="https://eur01.safelinks.protection.outlook.com/?url=https%3A%2F%2Fwww.google.com%2Furl%3Fq%3Dhttps%253A%252F%252Fgo.dev%252F">Go</a>`,
+			want: `<a href="https://go.dev/">Go</a>`,
+		},
+		{
+			name: "unrecoverable redirect kept",
+			body: `<a href="https://shop.us1.list-manage.com/track/click?u=1&amp;id=2">Shop</a>`,
+			want: `<a href="https://shop.us1.list-manage.com/track/click?u=1&amp;id=2">Shop</a>`,
+		},
+		{
+			name: "unsafe target kept wrapped",
+			body: `<a href="https://www.google.com/url?q=javascript:alert(1)">Go</a>`,
+			want: `<a href="https://www.google.com/url?q=javascript:alert(1)">Go</a>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := tracking.apply(test.body)
+			require.NoError(t, err)
+			require.Equal(t, "<html><head></head><body>"+test.want+"</body></html>", got)
+		})
+	}
+}
+
+func TestLoadTrackingRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracking.json")
+	err := os.WriteFile(path, []byte(`{
+		"pixels": ["^https://open\\.example\\.com/"],
+		"redirects": [{"pattern": "^https://click\\.example\\.com/", "param": "to"}]
+	}`), 0o644)
+	require.NoError(t, err)
+
+	rules, err := LoadTrackingRules(path)
+	require.NoError(t, err)
+
+	tracking, err := DefaultTrackingRules().Merge(rules).filter()
+	require.NoError(t, err)
+
+	got, err := tracking.apply(`<img src="https://open.example.com/1"><img src="https://eotrx.substackcdn.com/open">` +
+		`<a href="https://click.example.com/1?to=https%3A%2F%2Fgo.dev">Go</a>`)
+	require.NoError(t, err)
+	require.Equal(t, `<html><head></head><body><a href="https://go.dev">Go</a></body></html>`, got)
+
+	_, err = TrackingRules{Pixels: []string{"("}}.filter()
+	require.Error(t, err)
+}
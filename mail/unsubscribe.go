@@ -0,0 +1,256 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// unsubscribeTimeout limits how long unsubscribing from a sender may take.
+const unsubscribeTimeout = 30 * time.Second
+
+// ErrNoUnsubscribeMethod is returned when a sender can't be unsubscribed from
+// automatically: it has no one-click URL, and no mailto address or no relay to send to it.
+var ErrNoUnsubscribeMethod = errors.New("sender can't be unsubscribed from automatically")
+
+// ListUnsubscribe is how a message says it can be unsubscribed from, in its
+// List-Unsubscribe (RFC 2369) and List-Unsubscribe-Post (RFC 8058) headers.
+type ListUnsubscribe struct {
+	// URL is the first web link, which people open to unsubscribe.
+	URL string
+	// Mailto is the first mailto link, which unsubscribes whoever mails it.
+	Mailto string
+	// OneClick is set when URL unsubscribes with a single POST, without anyone opening it.
+	OneClick bool
+}
+
+// IsZero reports whether the message can't be unsubscribed from.
+func (l ListUnsubscribe) IsZero() bool {
+	return l.URL == "" && l.Mailto == ""
+}
+
+// parseListUnsubscribe reads a message's List-Unsubscribe headers. One-click
+// unsubscription needs an HTTPS URL, as RFC 8058 requires.
+func parseListUnsubscribe(header message.Header) ListUnsubscribe {
+	var unsubscribe ListUnsubscribe
+	for _, value := range strings.Split(headerText(header, "List-Unsubscribe"), ",") {
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, "<") || !strings.HasSuffix(value, ">") {
+			continue
+		}
+
+		link := strings.TrimSpace(value[1 : len(value)-1])
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https":
+			if unsubscribe.URL == "" && u.Host != "" {
+				unsubscribe.URL = link
+			}
+		case "mailto":
+			if unsubscribe.Mailto == "" {
+				unsubscribe.Mailto = link
+			}
+		}
+	}
+
+	post := strings.TrimSpace(header.Get("List-Unsubscribe-Post"))
+	unsubscribe.OneClick = strings.EqualFold(post, "List-Unsubscribe=One-Click") &&
+		strings.HasPrefix(strings.ToLower(unsubscribe.URL), "https://")
+
+	return unsubscribe
+}
+
+// Relay is the SMTP server mailto unsubscriptions are sent through. Credentials
+// are only sent once the connection is upgraded with STARTTLS.
+type Relay struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+// Unsubscriber unsubscribes feeds from their senders, with a one-click POST if the
+// sender supports it, or else by mailing its mailto address through the relay.
+type Unsubscriber struct {
+	client HTTPClient
+	relay  Relay
+}
+
+// NewUnsubscriber creates an Unsubscriber which POSTs with client, or a client which
+// only connects to public addresses if it's nil. Redirects are never followed, as
+// RFC 8058 doesn't need them and they'd let a sender send the POST anywhere. Mailto
+// addresses aren't used if relay has no Addr.
+func NewUnsubscriber(client HTTPClient, relay Relay) *Unsubscriber {
+	if client == nil {
+		client = &http.Client{Transport: publicTransport()}
+	}
+
+	if c, ok := client.(*http.Client); ok {
+		refusing := *c
+		refusing.CheckRedirect = refuseRedirects
+		client = &refusing
+	}
+
+	return &Unsubscriber{client: client, relay: relay}
+}
+
+// refuseRedirects is an http.Client CheckRedirect function which returns redirects
+// as they are, rather than following them.
+func refuseRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// CanUnsubscribe reports whether Unsubscribe can unsubscribe from target.
+func (u *Unsubscriber) CanUnsubscribe(target ListUnsubscribe) bool {
+	return target.OneClick && target.URL != "" || target.Mailto != "" && u.relay.Addr != ""
+}
+
+// Unsubscribe unsubscribes the address from, which the sender mails, from target.
+func (u *Unsubscriber) Unsubscribe(ctx context.Context, from string, target ListUnsubscribe) error {
+	ctx, cancel := context.WithTimeout(ctx, unsubscribeTimeout)
+	defer cancel()
+
+	switch {
+	case target.OneClick && target.URL != "":
+		return u.post(ctx, target.URL)
+	case target.Mailto != "" && u.relay.Addr != "":
+		return u.mail(ctx, from, target.Mailto)
+	default:
+		return ErrNoUnsubscribeMethod
+	}
+}
+
+// post makes an RFC 8058 one-click unsubscription request.
+func (u *Unsubscriber) post(ctx context.Context, link string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, link, strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post unsubscribe request: %w", err)
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	// Senders may redirect to a page saying they've unsubscribed, but a temporary or
+	// permanent redirect asks for the request to be made again, elsewhere.
+	if resp.StatusCode >= http.StatusBadRequest || resp.StatusCode == http.StatusTemporaryRedirect || resp.StatusCode == http.StatusPermanentRedirect {
+		return fmt.Errorf("unsubscribe link returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// mail sends the message a mailto link describes, as RFC 6068 reads it, from from.
+func (u *Unsubscriber) mail(ctx context.Context, from string, link string) error {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("failed to parse mailto link: %w", err)
+	}
+
+	addresses := parsed.Opaque
+	if unescaped, err := url.PathUnescape(addresses); err == nil {
+		addresses = unescaped
+	}
+
+	// The sender chooses the link, so it may only name itself, rather than use the
+	// relay to mail anyone else.
+	recipient, err := mail.ParseAddress(addresses)
+	if err != nil {
+		return fmt.Errorf("mailto link %q doesn't have exactly one valid address", link)
+	}
+
+	query := parsed.Query()
+	subject := query.Get("subject")
+	if subject == "" {
+		subject = "unsubscribe"
+	}
+
+	body := query.Get("body")
+	if body == "" {
+		body = "unsubscribe"
+	}
+
+	_, domain, _ := strings.Cut(from, "@")
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: <%s>\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", recipient.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", newItemID(), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	return u.relay.send(ctx, domain, from, []string{recipient.Address}, msg.Bytes())
+}
+
+// send delivers msg to the relay, upgrading to TLS if it's offered.
+func (r Relay) send(ctx context.Context, localName string, from string, to []string, msg []byte) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to relay: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c := smtp.NewClient(conn)
+	defer c.Close()
+
+	if localName == "" {
+		localName = "localhost"
+	}
+
+	if err := c.Hello(localName); err != nil {
+		return fmt.Errorf("failed to greet relay: %w", err)
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		host, _, _ := net.SplitHostPort(r.Addr)
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start TLS with relay: %w", err)
+		}
+	}
+
+	if r.Username != "" {
+		if _, ok := c.TLSConnectionState(); !ok {
+			return errors.New("relay doesn't support STARTTLS, so credentials won't be sent to it")
+		}
+
+		if err := c.Auth(sasl.NewPlainClient("", r.Username, r.Password)); err != nil {
+			return fmt.Errorf("failed to authenticate with relay: %w", err)
+		}
+	}
+
+	if err := c.SendMail(from, to, bytes.NewReader(msg)); err != nil {
+		return fmt.Errorf("failed to send unsubscribe message: %w", err)
+	}
+
+	return c.Quit()
+}
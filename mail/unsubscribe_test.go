@@ -0,0 +1,260 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseListUnsubscribe(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   ListUnsubscribe
+	}{
+		{
+			name: "one-click",
+			header: map[string]string{
+				"List-Unsubscribe":      "<mailto:leave@example.com?subject=unsubscribe>, <https://example.com/unsubscribe/abc>",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+			},
+			want: ListUnsubscribe{URL: "https://example.com/unsubscribe/abc", Mailto: "mailto:leave@example.com?subject=unsubscribe", OneClick: true},
+		},
+		{
+			name:   "link only",
+			header: map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe/abc>"},
+			want:   ListUnsubscribe{URL: "https://example.com/unsubscribe/abc"},
+		},
+		{
+			name: "one-click needs https",
+			header: map[string]string{
+				"List-Unsubscribe":      "<http://example.com/unsubscribe/abc>",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+			},
+			want: ListUnsubscribe{URL: "http://example.com/unsubscribe/abc"},
+		},
+		{
+			name:   "malformed",
+			header: map[string]string{"List-Unsubscribe": "https://example.com/unsubscribe/abc, <javascript:alert(1)>"},
+		},
+		{
+			name: "none",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := message.Header{}
+			for key, value := range test.header {
+				header.Set(key, value)
+			}
+
+			require.Equal(t, test.want, parseListUnsubscribe(header))
+		})
+	}
+}
+
+// sink is a fake SMTP server which keeps the messages sent to it.
+type sink struct {
+	messages chan sunk
+}
+
+type sunk struct {
+	from string
+	to   []string
+	data string
+}
+
+func (s *sink) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &sinkSession{sink: s}, nil
+}
+
+type sinkSession struct {
+	sink *sink
+	msg  sunk
+}
+
+func (s *sinkSession) Reset()                                    { s.msg = sunk{} }
+func (s *sinkSession) Logout() error                             { return nil }
+func (s *sinkSession) AuthPlain(username, password string) error { return smtp.ErrAuthUnsupported }
+
+func (s *sinkSession) Mail(from string, opts *smtp.MailOptions) error {
+	s.msg.from = from
+	return nil
+}
+
+func (s *sinkSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	s.msg.to = append(s.msg.to, to)
+	return nil
+}
+
+func (s *sinkSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.msg.data = string(data)
+	s.sink.messages <- s.msg
+	return nil
+}
+
+func startSink(t *testing.T) (string, <-chan sunk) {
+	backend := &sink{messages: make(chan sunk, 10)}
+	server := smtp.NewServer(backend)
+	server.Domain = "relay.example.com"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = server.Serve(ln)
+	}()
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return ln.Addr().String(), backend.messages
+}
+
+func TestUnsubscribe(t *testing.T) {
+	var posted []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+		posted = append(posted, r.URL.Path+" "+string(body))
+	}))
+	defer server.Close()
+
+	addr, messages := startSink(t)
+	ctx := context.Background()
+
+	unsubscriber := NewUnsubscriber(server.Client(), Relay{Addr: addr})
+	oneClick := ListUnsubscribe{URL: server.URL + "/unsubscribe/abc", Mailto: "mailto:leave@example.com", OneClick: true}
+	require.True(t, unsubscriber.CanUnsubscribe(oneClick))
+	require.NoError(t, unsubscriber.Unsubscribe(ctx, "abc123@mailfeed.xyz", oneClick))
+	require.Equal(t, []string{"/unsubscribe/abc List-Unsubscribe=One-Click"}, posted)
+
+	// without one-click, the mailto link is mailed through the relay
+	mailto := ListUnsubscribe{URL: server.URL + "/unsubscribe/abc", Mailto: "mailto:leave@example.com?subject=remove%20me&body=abc123"}
+	require.NoError(t, unsubscriber.Unsubscribe(ctx, "abc123@mailfeed.xyz", mailto))
+	require.Len(t, posted, 1)
+
+	msg := <-messages
+	require.Equal(t, "abc123@mailfeed.xyz", msg.from)
+	require.Equal(t, []string{"leave@example.com"}, msg.to)
+	require.Contains(t, msg.data, "From: <abc123@mailfeed.xyz>\r\n")
+	require.Contains(t, msg.data, "Subject: remove me\r\n")
+	require.True(t, strings.HasSuffix(msg.data, "\r\n\r\nabc123\r\n"))
+
+	// the sender can't use the relay to mail anyone but itself
+	for _, link := range []string{
+		"mailto:leave@example.com,victim@example.org",
+		"mailto:leave@example.com%2C%20victim@example.org",
+		"mailto:",
+	} {
+		require.Error(t, unsubscriber.Unsubscribe(ctx, "abc123@mailfeed.xyz", ListUnsubscribe{Mailto: link}), link)
+	}
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected message to %v", msg.to)
+	default:
+	}
+
+	// credentials aren't sent over plain text
+	withPassword := NewUnsubscriber(server.Client(), Relay{Addr: addr, Username: "user", Password: "secret"})
+	require.Error(t, withPassword.Unsubscribe(ctx, "abc123@mailfeed.xyz", mailto))
+
+	// a link which has to be opened can't be followed automatically
+	withoutRelay := NewUnsubscriber(server.Client(), Relay{})
+	require.False(t, withoutRelay.CanUnsubscribe(mailto))
+	require.ErrorIs(t, withoutRelay.Unsubscribe(ctx, "abc123@mailfeed.xyz", mailto), ErrNoUnsubscribeMethod)
+}
+
+func TestUnsubscribeRefusesInternalRequests(t *testing.T) {
+	var requested []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+		case "/done":
+			http.Redirect(w, r, "/unsubscribed", http.StatusSeeOther)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	// senders can't make the server POST to itself or its network
+	public := NewUnsubscriber(nil, Relay{})
+	for _, link := range []string{
+		server.URL + "/unsubscribe",
+		"https://localhost/unsubscribe",
+		"https://10.0.0.1/unsubscribe",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/unsubscribe",
+	} {
+		err := public.Unsubscribe(ctx, "abc123@mailfeed.xyz", ListUnsubscribe{URL: link, OneClick: true})
+		require.ErrorIs(t, err, errPrivateAddress, link)
+	}
+
+	require.Empty(t, requested)
+
+	// or follow redirects, which would repeat the POST wherever they point
+	unsubscriber := NewUnsubscriber(server.Client(), Relay{})
+	require.Error(t, unsubscriber.Unsubscribe(ctx, "abc123@mailfeed.xyz", ListUnsubscribe{URL: server.URL + "/moved", OneClick: true}))
+	require.NoError(t, unsubscriber.Unsubscribe(ctx, "abc123@mailfeed.xyz", ListUnsubscribe{URL: server.URL + "/done", OneClick: true}))
+	require.Equal(t, []string{"/moved", "/done"}, requested)
+}
+
+func TestDeliverStoresSubscriptions(t *testing.T) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	deliverer := NewDeliverer(logger, &db, newsletter.NewQueue(10))
+	deliver := func(id string, date string, header string) {
+		raw := "Message-ID: <" + id + "@example.com>\r\n" +
+			"From: News <News@Example.com>\r\n" +
+			"Date: " + date + "\r\n" +
+			"Subject: Hello\r\n" +
+			header +
+			"\r\n" +
+			"Hello\r\n"
+		require.NoError(t, deliverer.Deliver(ctx, Envelope{Recipients: []Recipient{{FeedID: "abc123"}}}, []byte(raw)))
+	}
+
+	deliver("1", "Mon, 02 Jan 2006 15:04:05 +0000", "List-Unsubscribe: <https://example.com/u/1>\r\n")
+	deliver("2", "Tue, 03 Jan 2006 15:04:05 +0000", "List-Unsubscribe: <https://example.com/u/2>\r\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	// an older message arriving late doesn't replace the newer one's headers
+	deliver("3", "Sun, 01 Jan 2006 15:04:05 +0000", "List-Unsubscribe: <https://example.com/u/3>\r\n")
+	deliver("4", "Mon, 02 Jan 2006 15:04:05 +0000", "")
+
+	subscriptions, err := db.ListSubscriptions(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+	require.Equal(t, "news@example.com", subscriptions[0].Sender)
+	require.Equal(t, "https://example.com/u/2", subscriptions[0].UnsubscribeUrl)
+	require.True(t, subscriptions[0].OneClick)
+	require.Equal(t, "2006-01-03 15:04:05", subscriptions[0].LastReceived)
+}
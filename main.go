@@ -6,8 +6,11 @@ import (
 	"os/signal"
 
 	"os"
+	"strings"
 
 	"github.com/alex-emery/mailfeed/internal/service"
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/alex-emery/mailfeed/rss"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
@@ -16,12 +19,28 @@ func main() {
 	dbPath := flag.String("db", "mailfeed.db", "path to sqlite database")
 	port := flag.String("port", "8080", "port to run server on")
 	host := flag.String("host", "localhost", "host to run server on")
+	smtpAddr := flag.String("smtp", "", "address to receive mail on over SMTP, disabled if empty")
+	lmtp := flag.Bool("lmtp", false, "receive mail over LMTP instead of SMTP")
+	fingerprint := flag.String("fingerprint", strings.Join(mail.DefaultFingerprint, ","), "headers hashed to deduplicate messages without a Message-ID, disabled if empty")
+	items := flag.Int("items", rss.DefaultItemLimit, "number of most recent items served in a feed")
+	cacheSize := flag.Int("cache", 64, "number of feeds kept in memory between requests, disabled if 0")
+	cacheTTL := flag.Duration("cache-ttl", rss.DefaultCacheTTL, "how long feeds are kept in memory, after which changes made outside the server, such as by -reprocess, are served")
+	queueSize := flag.Int("queue", 100, "number of newsletters which can wait to be added to their feeds")
+	policy := flag.String("policy", "", "JSON file with the allowlist newsletter HTML is sanitized against")
+	tracking := flag.String("tracking", "", "JSON file with tracking pixel and redirect rules used alongside the built-in ones")
+	authServID := flag.String("authserv-id", "", "authserv-id of the mail server whose Authentication-Results headers are trusted, or the topmost header's if empty")
+	confirmDomains := flag.String("confirm-domains", "", "comma separated domains whose subscription confirmation links are followed automatically")
+	relay := flag.String("unsubscribe-relay", "", "SMTP server host:port unsubscribe requests to mailto links are sent through, disabled if empty")
+	reprocess := flag.String("reprocess", "", "reprocess stored messages for a feed ID, or \"all\", then exit")
 	flag.Parse()
 	_ = godotenv.Load()
 
 	emailUsername := os.Getenv("EMAIL_USERNAME")
 	emailPassword := os.Getenv("EMAIL_PASSWORD")
 	emailServer := os.Getenv("EMAIL_SERVER")
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	relayUsername := os.Getenv("RELAY_USERNAME")
+	relayPassword := os.Getenv("RELAY_PASSWORD")
 
 	logger, err := zap.NewDevelopment()
 	if err != nil {
@@ -35,12 +54,44 @@ func main() {
 	}()
 
 	options := service.ServiceOptions{
-		EmailServer:   emailServer,
-		EmailUsername: emailUsername,
-		EmailPassword: emailPassword,
-		DBPath:        *dbPath,
-		Port:          *port,
-		Domain:        *host,
+		EmailServer:       emailServer,
+		EmailUsername:     emailUsername,
+		EmailPassword:     emailPassword,
+		DBPath:            *dbPath,
+		Port:              *port,
+		Domain:            *host,
+		SMTPAddr:          *smtpAddr,
+		LMTP:              *lmtp,
+		Fingerprint:       splitList(*fingerprint),
+		FeedItemLimit:     *items,
+		FeedCacheSize:     *cacheSize,
+		FeedCacheTTL:      *cacheTTL,
+		QueueSize:         *queueSize,
+		PolicyPath:        *policy,
+		TrackingRulesPath: *tracking,
+		AdminToken:        adminToken,
+		AuthServID:        *authServID,
+		ConfirmDomains:    splitList(*confirmDomains),
+		UnsubscribeRelay: mail.Relay{
+			Addr:     *relay,
+			Username: relayUsername,
+			Password: relayPassword,
+		},
+	}
+
+	if *reprocess != "" {
+		feedID := *reprocess
+		if feedID == "all" {
+			feedID = ""
+		}
+
+		count, err := service.Reprocess(logger, options, feedID)
+		if err != nil {
+			logger.Fatal("failed to reprocess messages", zap.Error(err))
+		}
+
+		logger.Info("reprocessed messages", zap.Int("count", count))
+		return
 	}
 
 	svc, err := service.New(logger, options)
@@ -62,3 +113,15 @@ func main() {
 		log.Fatal("failed to shutdown service", err)
 	}
 }
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -8,6 +8,12 @@ type NewsLetter struct {
 	Date    time.Time
 	Subject string
 	Body    string
+	// Category is the tag the newsletter was addressed with, if any.
+	Category string
+	// EmailID is the stored email the newsletter was converted from.
+	EmailID int64
+	// MessageID identifies the message the newsletter came from, so copies can be ignored.
+	MessageID string
 }
 
 func New(inbox, subject, body string, date time.Time) *NewsLetter {
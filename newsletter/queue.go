@@ -0,0 +1,99 @@
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueClosed is returned when sending to a closed queue.
+var ErrQueueClosed = errors.New("queue is closed")
+
+// Queue is a bounded queue of newsletters waiting to be picked up by their feeds.
+// Senders wait while it is full, and how often and for how long is recorded so
+// backpressure shows up in its stats.
+type Queue struct {
+	letters   chan *NewsLetter
+	done      chan struct{}
+	closeOnce sync.Once
+
+	sent        atomic.Int64
+	blocked     atomic.Int64
+	blockedTime atomic.Int64
+}
+
+// QueueStats are a snapshot of a queue's state.
+type QueueStats struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+	// Sent is the number of newsletters queued.
+	Sent int64 `json:"sent"`
+	// Blocked is the number of sends which had to wait for room in the queue.
+	Blocked int64 `json:"blocked"`
+	// BlockedTime is the total time senders spent waiting.
+	BlockedTime time.Duration `json:"blockedTime"`
+}
+
+func NewQueue(size int) *Queue {
+	return &Queue{
+		letters: make(chan *NewsLetter, size),
+		done:    make(chan struct{}),
+	}
+}
+
+// Send queues letter, waiting while the queue is full. It gives up if ctx is done
+// or the queue is closed first.
+func (q *Queue) Send(ctx context.Context, letter *NewsLetter) error {
+	select {
+	case <-q.done:
+		return ErrQueueClosed
+	default:
+	}
+
+	select {
+	case q.letters <- letter:
+		q.sent.Add(1)
+		return nil
+	default:
+	}
+
+	q.blocked.Add(1)
+	start := time.Now()
+	defer func() {
+		q.blockedTime.Add(int64(time.Since(start)))
+	}()
+
+	select {
+	case q.letters <- letter:
+		q.sent.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-q.done:
+		return ErrQueueClosed
+	}
+}
+
+// Receive returns the channel newsletters are delivered on.
+func (q *Queue) Receive() <-chan *NewsLetter {
+	return q.letters
+}
+
+// Close stops the queue accepting newsletters and releases any waiting senders.
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Length:      len(q.letters),
+		Capacity:    cap(q.letters),
+		Sent:        q.sent.Load(),
+		Blocked:     q.blocked.Load(),
+		BlockedTime: time.Duration(q.blockedTime.Load()),
+	}
+}
@@ -0,0 +1,43 @@
+package newsletter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueBackpressure(t *testing.T) {
+	q := NewQueue(1)
+	require.NoError(t, q.Send(context.Background(), &NewsLetter{Subject: "first"}))
+
+	// the queue is full, so the next send waits for it to be drained
+	sent := make(chan error)
+	go func() {
+		sent <- q.Send(context.Background(), &NewsLetter{Subject: "second"})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, "first", (<-q.Receive()).Subject)
+	require.NoError(t, <-sent)
+	require.Equal(t, "second", (<-q.Receive()).Subject)
+
+	stats := q.Stats()
+	require.Equal(t, int64(2), stats.Sent)
+	require.Equal(t, int64(1), stats.Blocked)
+	require.NotZero(t, stats.BlockedTime)
+
+	// waiting senders give up when their context is done or the queue is closed
+	require.NoError(t, q.Send(context.Background(), &NewsLetter{}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, q.Send(ctx, &NewsLetter{}), context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Close()
+	}()
+	require.ErrorIs(t, q.Send(context.Background(), &NewsLetter{}), ErrQueueClosed)
+	require.Equal(t, 1, q.Stats().Length)
+}
@@ -0,0 +1,476 @@
+package rss
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alex-emery/mailfeed/account"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// FeedInfo describes a feed in the feed management API.
+type FeedInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Address is where the feed receives mail.
+	Address string `json:"address"`
+	// Private feeds are only served to requests with their read token.
+	Private bool `json:"private"`
+	// Locked feeds quarantine mail from senders no rule allows, once SenderLimit
+	// senders have been allowed automatically.
+	Locked      bool  `json:"locked"`
+	SenderLimit int64 `json:"senderLimit"`
+	// RequireDKIM feeds quarantine mail without a valid DKIM signature from its
+	// sender's domain.
+	RequireDKIM bool  `json:"requireDkim"`
+	ItemCount   int64 `json:"itemCount"`
+	// LastReceived is when the feed's newest item was sent, if it has any.
+	LastReceived *time.Time `json:"lastReceived,omitempty"`
+	// ReadToken is only set when a feed is made private, as only its hash is kept.
+	ReadToken string `json:"readToken,omitempty"`
+}
+
+// feedInfo describes a feed from its summary. Every query's summary has the same fields,
+// so they're converted to sqlc.GetFeedSummaryRow.
+func (s *Server) feedInfo(summary sqlc.GetFeedSummaryRow) FeedInfo {
+	info := FeedInfo{
+		ID:          summary.ID,
+		Name:        summary.Name,
+		Address:     fmt.Sprintf("%s@%s", summary.Address, s.domain),
+		Private:     summary.Private,
+		Locked:      summary.Locked,
+		SenderLimit: summary.SenderLimit,
+		RequireDKIM: summary.RequireDkim,
+		ItemCount:   summary.ItemCount,
+	}
+
+	lastReceived := summary.LastReceived
+	if date, err := time.Parse("2006-01-02 15:04:05", lastReceived.String); lastReceived.Valid && err == nil {
+		info.LastReceived = &date
+	}
+
+	return info
+}
+
+// getFeedInfo returns the FeedInfo of the feed with id.
+func (s *Server) getFeedInfo(ctx context.Context, id string) (FeedInfo, error) {
+	summary, err := s.db.GetFeedSummary(ctx, id)
+	if err != nil {
+		return FeedInfo{}, fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	return s.feedInfo(summary), nil
+}
+
+// authorize reports whether r may manage feed: if it's signed in to the account
+// owning feed, or token is either feed's own token or the admin token.
+func (s *Server) authorize(r *http.Request, feed sqlc.Feed, token string) bool {
+	if owner, ok := account.FromContext(r.Context()); ok && feed.AccountID.Valid && feed.AccountID.Int64 == owner.ID {
+		return true
+	}
+
+	return tokenMatches(token, feed.TokenHash) || tokenMatches(token, s.adminTokenHash)
+}
+
+// authorizedFeed returns the feed with id if the request's bearer token may manage
+// it. Otherwise it responds with an error and returns false.
+func (s *Server) authorizedFeed(w http.ResponseWriter, r *http.Request, id string) (sqlc.Feed, bool) {
+	feed, err := s.db.GetFeed(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return sqlc.Feed{}, false
+	}
+
+	if err != nil {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return sqlc.Feed{}, false
+	}
+
+	if !s.authorize(r, feed, bearerToken(r)) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mailfeed"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return sqlc.Feed{}, false
+	}
+
+	return feed, true
+}
+
+// RequireAdmin only lets requests bearing the admin token through to next. Nothing
+// gets through if there's no admin token.
+func (s *Server) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokenMatches(bearerToken(r), s.adminTokenHash) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mailfeed"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Lists every feed to the admin, or a signed in account's feeds.
+func (s *Server) ListFeeds(w http.ResponseWriter, r *http.Request) {
+	var feeds []FeedInfo
+	var err error
+	if tokenMatches(bearerToken(r), s.adminTokenHash) {
+		feeds, err = s.listFeeds(r.Context())
+	} else if owner, ok := account.FromContext(r.Context()); ok {
+		feeds, err = s.listAccountFeeds(r.Context(), owner.ID)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mailfeed"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Error listing feeds", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, feeds)
+}
+
+func (s *Server) listFeeds(ctx context.Context) ([]FeedInfo, error) {
+	summaries, err := s.db.ListFeedSummaries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feeds: %w", err)
+	}
+
+	feeds := make([]FeedInfo, 0, len(summaries))
+	for _, summary := range summaries {
+		feeds = append(feeds, s.feedInfo(sqlc.GetFeedSummaryRow(summary)))
+	}
+
+	return feeds, nil
+}
+
+func (s *Server) listAccountFeeds(ctx context.Context, accountID int64) ([]FeedInfo, error) {
+	summaries, err := s.db.ListAccountFeedSummaries(ctx, sql.NullInt64{Int64: accountID, Valid: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feeds: %w", err)
+	}
+
+	feeds := make([]FeedInfo, 0, len(summaries))
+	for _, summary := range summaries {
+		feeds = append(feeds, s.feedInfo(sqlc.GetFeedSummaryRow(summary)))
+	}
+
+	return feeds, nil
+}
+
+// Gets a single feed.
+func (s *Server) GetFeedInfo(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	info, err := s.getFeedInfo(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, info)
+}
+
+// UpdateFeedRequest changes the fields which are set.
+type UpdateFeedRequest struct {
+	Name *string
+	// Private feeds are given a new read token each time they're made private.
+	Private *bool
+	// Locked feeds allow the first SenderLimit senders and quarantine the rest.
+	Locked      *bool
+	SenderLimit *int64
+	// RequireDKIM feeds only take mail with an aligned DKIM signature.
+	RequireDKIM *bool
+}
+
+// Renames a feed, changes whether it's private, locks it to its senders or makes it
+// require DKIM.
+func (s *Server) UpdateFeed(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	req := UpdateFeedRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == nil && req.Private == nil && req.Locked == nil && req.SenderLimit == nil && req.RequireDKIM == nil {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	if req.SenderLimit != nil && *req.SenderLimit < 0 {
+		http.Error(w, "Sender limit can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+
+		req.Name = &name
+	}
+
+	readToken, err := s.updateFeed(r.Context(), feed, req)
+	if err != nil {
+		s.logger.Error("Error updating feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	info, err := s.getFeedInfo(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	info.ReadToken = readToken
+	s.writeJSON(w, http.StatusOK, info)
+}
+
+// Deletes a feed along with its items, aliases and any emails no other feed has.
+func (s *Server) DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	if err := s.deleteFeed(r.Context(), feed.ID); err != nil {
+		s.logger.Error("Error deleting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Gives a feed a fresh address, for when its address starts receiving spam. The old
+// address is kept as a disabled alias, so it no longer receives mail and is never
+// given to another feed. The feed is still served from the same URL.
+func (s *Server) RotateFeedAddress(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	if err := s.rotateAddress(r.Context(), feed); err != nil {
+		s.logger.Error("Error rotating feed address", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	info, err := s.getFeedInfo(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, info)
+}
+
+// updateFeed makes the changes req asks for to feed, all or none of them. A feed made
+// private is given a new read token, which is returned.
+func (s *Server) updateFeed(ctx context.Context, feed sqlc.Feed, req UpdateFeedRequest) (string, error) {
+	var readToken, readTokenHash string
+	if req.Private != nil && *req.Private {
+		var err error
+		readToken, readTokenHash, err = newToken()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := s.db.InTx(ctx, func(q *sqlc.Queries) error {
+		if req.Name != nil {
+			if _, err := q.RenameFeed(ctx, sqlc.RenameFeedParams{Name: *req.Name, ID: feed.ID}); err != nil {
+				return fmt.Errorf("failed to rename feed: %w", err)
+			}
+		}
+
+		if req.Private != nil {
+			err := q.UpdateFeedPrivacy(ctx, sqlc.UpdateFeedPrivacyParams{Private: *req.Private, ReadTokenHash: readTokenHash, ID: feed.ID})
+			if err != nil {
+				return fmt.Errorf("failed to update feed privacy: %w", err)
+			}
+		}
+
+		if req.Locked != nil || req.SenderLimit != nil {
+			params := sqlc.SetFeedLockParams{Locked: feed.Locked, SenderLimit: feed.SenderLimit, ID: feed.ID}
+			if req.Locked != nil {
+				params.Locked = *req.Locked
+			}
+
+			if req.SenderLimit != nil {
+				params.SenderLimit = *req.SenderLimit
+			}
+
+			if err := q.SetFeedLock(ctx, params); err != nil {
+				return fmt.Errorf("failed to lock feed: %w", err)
+			}
+		}
+
+		if req.RequireDKIM != nil {
+			err := q.SetFeedRequireDKIM(ctx, sqlc.SetFeedRequireDKIMParams{RequireDkim: *req.RequireDKIM, ID: feed.ID})
+			if err != nil {
+				return fmt.Errorf("failed to update DKIM requirement: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.cache.invalidate(feed.ID)
+	return readToken, nil
+}
+
+func (s *Server) deleteFeed(ctx context.Context, id string) error {
+	err := s.db.InTx(ctx, func(q *sqlc.Queries) error {
+		emails, err := q.ListFeedEmailIDs(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to list emails: %w", err)
+		}
+
+		// Items and aliases are deleted with the feed.
+		if _, err := q.DeleteFeed(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete feed: %w", err)
+		}
+
+		for _, email := range emails {
+			if err := q.DeleteUnusedEmail(ctx, email.Int64); err != nil {
+				return fmt.Errorf("failed to delete email: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.cache.invalidate(id)
+	return nil
+}
+
+func (s *Server) rotateAddress(ctx context.Context, feed sqlc.Feed) error {
+	return s.db.InTx(ctx, func(q *sqlc.Queries) error {
+		address, err := newAddress(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		old := feedAddress(feed)
+		err = q.CreateDisabledFeedAlias(ctx, sqlc.CreateDisabledFeedAliasParams{
+			Alias:  strings.ToLower(old),
+			FeedID: feed.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to disable old address: %w", err)
+		}
+
+		err = q.UpdateFeedAddress(ctx, sqlc.UpdateFeedAddressParams{Address: address, ID: feed.ID})
+		if err != nil {
+			return fmt.Errorf("failed to update address: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// feedAddress returns the local part feed receives mail at: its ID, until its
+// address is rotated.
+func feedAddress(feed sqlc.Feed) string {
+	if feed.Address == "" {
+		return feed.ID
+	}
+
+	return feed.Address
+}
+
+// randomAddress generates the addresses newAddress tries. Tests replace it to make
+// addresses collide.
+var randomAddress = func() string { return GenerateRandomString(6) }
+
+// newAddress returns a random address no feed or alias uses.
+func newAddress(ctx context.Context, q *sqlc.Queries) (string, error) {
+	for i := 0; i < 10; i++ {
+		address := randomAddress()
+		used, err := addressInUse(ctx, q, address)
+		if err != nil {
+			return "", err
+		}
+
+		if !used {
+			return address, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find an unused address")
+}
+
+// addressInUse reports whether name is a feed's ID, address or alias.
+func addressInUse(ctx context.Context, q *sqlc.Queries, name string) (bool, error) {
+	_, err := q.GetFeed(ctx, name)
+	if err == nil {
+		return true, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	_, err = q.GetFeedByAddress(ctx, name)
+	if err == nil {
+		return true, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	_, err = q.GetFeedAlias(ctx, strings.ToLower(name))
+	if err == nil {
+		return true, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to get feed alias: %w", err)
+	}
+
+	return false, nil
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("Error writing response", zap.Error(err))
+	}
+}
@@ -0,0 +1,556 @@
+package rss
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/account"
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/go-chi/chi"
+	"github.com/mmcdole/gofeed"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newAPI(t *testing.T) (*database.Database, http.Handler) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{Domain: "mailfeed.xyz", AdminToken: "admin"})
+
+	r := chi.NewRouter()
+	r.Get("/api/feeds", s.ListFeeds)
+	r.Get("/api/feeds/{id}", s.GetFeedInfo)
+	r.Patch("/api/feeds/{id}", s.UpdateFeed)
+	r.Delete("/api/feeds/{id}", s.DeleteFeed)
+	r.Post("/api/feeds/{id}/rotate", s.RotateFeedAddress)
+	r.Get("/api/feeds/{id}/senders", s.ListSenderRules)
+	r.Post("/api/feeds/{id}/senders", s.CreateSenderRule)
+	r.Delete("/api/feeds/{id}/senders/{rule}", s.DeleteSenderRule)
+	r.Get("/api/feeds/{id}/quarantine", s.ListQuarantine)
+	r.Post("/api/feeds/{id}/quarantine/{message}/release", s.ReleaseQuarantine)
+	r.Delete("/api/feeds/{id}/quarantine/{message}", s.DiscardQuarantine)
+	r.Get("/api/feeds/{id}/filters", s.ListFilterRules)
+	r.Post("/api/feeds/{id}/filters", s.CreateFilterRule)
+	r.Post("/api/feeds/{id}/filters/dry-run", s.DryRunFilterRule)
+	r.Put("/api/feeds/{id}/filters/{filter}", s.UpdateFilterRule)
+	r.Delete("/api/feeds/{id}/filters/{filter}", s.DeleteFilterRule)
+	r.Post("/rss", s.CreateFeed)
+	r.Get("/rss/{id}", s.GetFeed)
+	r.Post("/rss/{id}/aliases", s.CreateAlias)
+	r.Get("/item/{feed}/{item}", s.GetItem)
+	r.Get("/media/{id}", s.GetMedia)
+	r.Post("/manage", s.ManageFeed)
+	r.Post("/manage/{id}", s.ManageFeedAction)
+	return &db, r
+}
+
+func call(handler http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestRequireAdmin(t *testing.T) {
+	db, err := database.New(zap.NewNop(), ":memory:")
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	admin := New(zap.NewNop(), &db, nil, Options{Domain: "mailfeed.xyz", AdminToken: "admin"})
+	for token, code := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "admin": http.StatusOK} {
+		require.Equal(t, code, call(admin.RequireAdmin(ok), "GET", "/debug/queue", token, "").Code)
+	}
+
+	// nothing gets through without an admin token
+	s := New(zap.NewNop(), &db, nil, Options{Domain: "mailfeed.xyz"})
+	require.Equal(t, http.StatusUnauthorized, call(s.RequireAdmin(ok), "GET", "/debug/queue", "", "").Code)
+}
+
+func TestListFeeds(t *testing.T) {
+	db, api := newAPI(t)
+
+	for _, id := range []string{"abc123", "def456"} {
+		_, err := db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: id, Name: "Feed " + id})
+		require.NoError(t, err)
+	}
+
+	for _, date := range []string{"2006-01-02 15:04:05", "2006-01-03 15:04:05"} {
+		_, err := db.CreateFeedItem(context.Background(), sqlc.CreateFeedItemParams{
+			ID:     GenerateRandomString(12),
+			FeedID: "abc123",
+			Date:   date,
+		})
+		require.NoError(t, err)
+	}
+
+	w := call(api, "GET", "/api/feeds", "admin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var feeds []FeedInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&feeds))
+	require.Len(t, feeds, 2)
+
+	require.Equal(t, "abc123", feeds[0].ID)
+	require.Equal(t, "Feed abc123", feeds[0].Name)
+	require.Equal(t, "abc123@mailfeed.xyz", feeds[0].Address)
+	require.Equal(t, int64(2), feeds[0].ItemCount)
+	require.Equal(t, "2006-01-03T15:04:05Z", feeds[0].LastReceived.Format("2006-01-02T15:04:05Z07:00"))
+
+	require.Equal(t, int64(0), feeds[1].ItemCount)
+	require.Nil(t, feeds[1].LastReceived)
+
+	require.Equal(t, http.StatusNotFound, call(api, "GET", "/api/feeds/missing", "admin", "").Code)
+}
+
+func TestUpdateFeed(t *testing.T) {
+	db, api := newAPI(t)
+
+	_, err := db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	w := call(api, "PATCH", "/api/feeds/abc123", "admin", `{"name": "Renamed"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var info FeedInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.Equal(t, "Renamed", info.Name)
+
+	require.Equal(t, http.StatusBadRequest, call(api, "PATCH", "/api/feeds/abc123", "admin", `{"name": " "}`).Code)
+	require.Equal(t, http.StatusNotFound, call(api, "PATCH", "/api/feeds/missing", "admin", `{"name": "Renamed"}`).Code)
+}
+
+func TestDeleteFeed(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"abc123", "def456"} {
+		_, err := db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: id, Name: id})
+		require.NoError(t, err)
+	}
+
+	_, err := db.CreateFeedAlias(ctx, sqlc.CreateFeedAliasParams{Alias: "news", FeedID: "abc123"})
+	require.NoError(t, err)
+
+	// one email went only to the deleted feed, the other to both
+	own, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	shared, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	for _, item := range []sqlc.CreateFeedItemParams{
+		{ID: "item1", FeedID: "abc123", EmailID: sql.NullInt64{Int64: own.ID, Valid: true}},
+		{ID: "item2", FeedID: "abc123", EmailID: sql.NullInt64{Int64: shared.ID, Valid: true}},
+		{ID: "item3", FeedID: "def456", EmailID: sql.NullInt64{Int64: shared.ID, Valid: true}},
+	} {
+		item.Date = "2006-01-02 15:04:05"
+		_, err = db.CreateFeedItem(ctx, item)
+		require.NoError(t, err)
+	}
+
+	err = db.CreateAttachment(ctx, sqlc.CreateAttachmentParams{ID: "logo", EmailID: own.ID, ContentType: "image/png", Data: []byte("png")})
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusNoContent, call(api, "DELETE", "/api/feeds/abc123", "admin", "").Code)
+	require.Equal(t, http.StatusNotFound, call(api, "DELETE", "/api/feeds/abc123", "admin", "").Code)
+
+	_, err = db.GetFeedItem(ctx, "item1")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = db.GetFeedAlias(ctx, "news")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = db.GetEmail(ctx, own.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = db.GetAttachment(ctx, "logo")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = db.GetEmail(ctx, shared.ID)
+	require.NoError(t, err)
+
+	_, err = db.GetFeedItem(ctx, "item3")
+	require.NoError(t, err)
+}
+
+func TestRotateFeedAddress(t *testing.T) {
+	db, api := newAPI(t)
+
+	_, err := db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	w := call(api, "POST", "/api/feeds/abc123/rotate", "admin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var info FeedInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.Equal(t, "abc123", info.ID)
+	require.NotEqual(t, "abc123@mailfeed.xyz", info.Address)
+
+	address := strings.TrimSuffix(info.Address, "@mailfeed.xyz")
+	feed, err := db.GetFeedByAddress(context.Background(), address)
+	require.NoError(t, err)
+	require.Equal(t, "abc123", feed.ID)
+
+	alias, err := db.GetFeedAlias(context.Background(), "abc123")
+	require.NoError(t, err)
+	require.True(t, alias.Disabled)
+
+	// neither address can be given out again
+	used, err := addressInUse(context.Background(), db.Queries, address)
+	require.NoError(t, err)
+	require.True(t, used)
+	require.Equal(t, http.StatusConflict, call(api, "POST", "/rss/abc123/aliases", "admin", `{"alias": "abc123"}`).Code)
+
+	require.Equal(t, http.StatusNotFound, call(api, "POST", "/api/feeds/missing/rotate", "admin", "").Code)
+}
+
+func TestNewAddressSkipsUsedAddresses(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	_, err := db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	// taken1 was the feed's address before it was rotated
+	err = db.CreateDisabledFeedAlias(ctx, sqlc.CreateDisabledFeedAliasParams{Alias: "taken1", FeedID: "abc123"})
+	require.NoError(t, err)
+
+	generate := randomAddress
+	defer func() { randomAddress = generate }()
+
+	var generated []string
+	next := []string{"taken1", "abc123", "fresh1", "fresh2"}
+	randomAddress = func() string {
+		address := next[0]
+		next = next[1:]
+		generated = append(generated, address)
+		return address
+	}
+
+	address, err := newAddress(ctx, db.Queries)
+	require.NoError(t, err)
+	require.Equal(t, "fresh1", address)
+	require.Equal(t, []string{"taken1", "abc123", "fresh1"}, generated)
+
+	// new feeds are given an unused ID too
+	next = []string{"taken1", "fresh2"}
+	created := createFeed(t, api, `{"name": "Another Feed"}`)
+	require.Equal(t, "fresh2", created.ID)
+}
+
+func TestPrivateFeedItems(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	created := createFeed(t, api, `{"name": "Secret", "private": true}`)
+
+	email, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	_, err = db.CreateFeedItem(ctx, sqlc.CreateFeedItemParams{
+		ID:      "item1",
+		FeedID:  created.ID,
+		Subject: "Issue 42",
+		Body:    `<p><img src="https://mailfeed.xyz/media/logo"></p>`,
+		Date:    "2006-01-02 15:04:05",
+		EmailID: sql.NullInt64{Int64: email.ID, Valid: true},
+	})
+	require.NoError(t, err)
+
+	for _, attachment := range []sqlc.CreateAttachmentParams{
+		{ID: "logo", ContentID: "logo@newsletter.com", ContentType: "image/png", Inline: true, Data: []byte("png")},
+		{ID: "issue", Filename: "issue-42.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+	} {
+		attachment.EmailID = email.ID
+		attachment.Size = int64(len(attachment.Data))
+		require.NoError(t, db.CreateAttachment(ctx, attachment))
+	}
+
+	// links from the feed carry the read token
+	w := call(api, "GET", "/rss/"+created.ID+"?token="+url.QueryEscape(created.ReadToken), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	feed, err := gofeed.NewParser().Parse(w.Body)
+	require.NoError(t, err)
+	require.Len(t, feed.Items, 1)
+
+	query := "?token=" + url.QueryEscape(created.ReadToken)
+	require.Equal(t, "https://mailfeed.xyz/item/"+created.ID+"/item1"+query, feed.Items[0].Link)
+	require.Equal(t, "https://mailfeed.xyz/media/issue"+query, feed.Items[0].Enclosures[0].URL)
+	require.Contains(t, feed.Items[0].Description, "https://mailfeed.xyz/media/logo"+query)
+
+	itemURL := "/item/" + created.ID + "/item1"
+	for _, path := range []string{itemURL, "/media/logo", "/media/issue"} {
+		require.Equal(t, http.StatusNotFound, call(api, "GET", path, "", "").Code, path)
+		require.Equal(t, http.StatusNotFound, call(api, "GET", path+"?token="+url.QueryEscape(created.Token), "", "").Code, path)
+		require.Equal(t, http.StatusOK, call(api, "GET", path+query, "", "").Code, path)
+	}
+
+	w = call(api, "GET", itemURL+query, "", "")
+	require.Contains(t, w.Body.String(), "https://mailfeed.xyz/media/logo"+query)
+	require.Contains(t, call(api, "GET", "/media/issue"+query, "", "").Header().Get("Cache-Control"), "private")
+
+	// they're public again with the feed
+	require.Equal(t, http.StatusOK, call(api, "PATCH", "/api/feeds/"+created.ID, created.Token, `{"private": false}`).Code)
+	require.Equal(t, http.StatusOK, call(api, "GET", itemURL, "", "").Code)
+	require.Equal(t, http.StatusOK, call(api, "GET", "/media/issue", "", "").Code)
+}
+
+func createFeed(t *testing.T, api http.Handler, body string) CreateFeedResponse {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/rss", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	api.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var created CreateFeedResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	return created
+}
+
+func TestFeedTokens(t *testing.T) {
+	db, api := newAPI(t)
+
+	created := createFeed(t, api, `{"name": "Mine"}`)
+	require.NotEmpty(t, created.Token)
+	require.Equal(t, created.ID+"@mailfeed.xyz", created.Address)
+	require.Empty(t, created.ReadToken)
+
+	other := createFeed(t, api, `{"name": "Theirs"}`)
+
+	// only the token's hash is stored
+	stored, err := db.GetFeed(context.Background(), created.ID)
+	require.NoError(t, err)
+	require.NotContains(t, stored.TokenHash, created.Token)
+	require.True(t, tokenMatches(created.Token, stored.TokenHash))
+
+	path := "/api/feeds/" + created.ID
+	require.Equal(t, http.StatusOK, call(api, "GET", path, created.Token, "").Code)
+	require.Equal(t, http.StatusUnauthorized, call(api, "GET", path, "", "").Code)
+	require.Equal(t, http.StatusUnauthorized, call(api, "GET", path, other.Token, "").Code)
+	require.Equal(t, http.StatusUnauthorized, call(api, "PATCH", path, other.Token, `{"name": "Stolen"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, call(api, "DELETE", path, other.Token, "").Code)
+	require.Equal(t, http.StatusUnauthorized, call(api, "POST", path+"/rotate", other.Token, "").Code)
+	require.Equal(t, http.StatusUnauthorized, call(api, "POST", "/rss/"+created.ID+"/aliases", other.Token, `{"alias": "stolen"}`).Code)
+
+	// only the admin may list every feed
+	require.Equal(t, http.StatusUnauthorized, call(api, "GET", "/api/feeds", created.Token, "").Code)
+
+	require.Equal(t, http.StatusNoContent, call(api, "DELETE", path, created.Token, "").Code)
+}
+
+func TestPrivateFeed(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	created := createFeed(t, api, `{"name": "Secret", "private": true}`)
+	require.True(t, created.Private)
+	require.NotEmpty(t, created.ReadToken)
+
+	feedURL := "/rss/" + created.ID
+	require.Equal(t, http.StatusNotFound, call(api, "GET", feedURL, "", "").Code)
+	require.Equal(t, http.StatusNotFound, call(api, "GET", feedURL+"?token="+created.Token, "", "").Code)
+	require.Equal(t, http.StatusOK, call(api, "GET", feedURL+"?token="+created.ReadToken, "", "").Code)
+
+	// its owner reads it while signed in, without the token
+	owner, err := db.CreateAccount(ctx, sqlc.CreateAccountParams{Email: "reader@example.com", PasswordHash: "hash", Created: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	stranger, err := db.CreateAccount(ctx, sqlc.CreateAccountParams{Email: "stranger@example.com", PasswordHash: "hash", Created: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	err = db.SetFeedAccount(ctx, sqlc.SetFeedAccountParams{AccountID: sql.NullInt64{Int64: owner.ID, Valid: true}, ID: created.ID})
+	require.NoError(t, err)
+
+	as := func(signedIn sqlc.Account) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", feedURL, nil)
+		api.ServeHTTP(w, r.WithContext(account.NewContext(r.Context(), signedIn)))
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, as(owner))
+	require.Equal(t, http.StatusNotFound, as(stranger))
+
+	// making it private again replaces the read token
+	w := call(api, "PATCH", "/api/feeds/"+created.ID, created.Token, `{"private": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var info FeedInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.NotEmpty(t, info.ReadToken)
+	require.NotEqual(t, created.ReadToken, info.ReadToken)
+	require.Equal(t, http.StatusNotFound, call(api, "GET", feedURL+"?token="+created.ReadToken, "", "").Code)
+	require.Equal(t, http.StatusOK, call(api, "GET", feedURL+"?token="+info.ReadToken, "", "").Code)
+
+	w = call(api, "PATCH", "/api/feeds/"+created.ID, created.Token, `{"private": false}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusOK, call(api, "GET", feedURL, "", "").Code)
+}
+
+func TestManageFeed(t *testing.T) {
+	db, api := newAPI(t)
+
+	created := createFeed(t, api, `{"name": "Mine"}`)
+
+	manage := func(path string, form url.Values) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		api.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	body := manage("/manage", url.Values{"id": {created.ID}, "token": {"wrong"}})
+	require.Contains(t, body, "the token is wrong")
+
+	body = manage("/manage", url.Values{"id": {created.ID}, "token": {created.Token}})
+	require.Contains(t, body, "<h1>Mine</h1>")
+
+	body = manage("/manage/"+created.ID, url.Values{"token": {created.Token}, "action": {"rename"}, "name": {"Renamed"}})
+	require.Contains(t, body, "<h1>Renamed</h1>")
+
+	body = manage("/manage/"+created.ID, url.Values{"token": {"wrong"}, "action": {"delete"}})
+	require.Contains(t, body, "the token is wrong")
+
+	body = manage("/manage/"+created.ID, url.Values{"token": {created.Token}, "action": {"delete"}})
+	require.Contains(t, body, "Feed deleted")
+
+	_, err := db.GetFeed(context.Background(), created.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestDashboard(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	owner, err := db.CreateAccount(ctx, sqlc.CreateAccountParams{Email: "reader@example.com", PasswordHash: "hash", Created: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	stranger, err := db.CreateAccount(ctx, sqlc.CreateAccountParams{Email: "stranger@example.com", PasswordHash: "hash", Created: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	as := func(signedIn sqlc.Account, method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		api.ServeHTTP(w, r.WithContext(account.NewContext(r.Context(), signedIn)))
+		return w
+	}
+
+	// feeds created while signed in belong to the account, and are managed without their token
+	w := as(owner, "POST", "/rss", `{"name": "Mine"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var created CreateFeedResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+
+	_, err = db.CreateFeedItem(ctx, sqlc.CreateFeedItemParams{ID: "item1", FeedID: created.ID, Subject: "Issue 1", Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, as(owner, "PATCH", "/api/feeds/"+created.ID, `{"name": "Renamed"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, as(stranger, "PATCH", "/api/feeds/"+created.ID, `{"name": "Stolen"}`).Code)
+
+	w = as(owner, "GET", "/api/feeds", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var feeds []FeedInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&feeds))
+	require.Len(t, feeds, 1)
+	require.Equal(t, "Renamed", feeds[0].Name)
+
+	w = as(stranger, "GET", "/api/feeds", "")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&feeds))
+	require.Empty(t, feeds)
+
+	// a feed made anonymously can be claimed with its token
+	anonymous := createFeed(t, api, `{"name": "Anonymous"}`)
+	form := url.Values{"token": {anonymous.Token}, "action": {"claim"}}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/manage/"+anonymous.ID, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	api.ServeHTTP(w, r.WithContext(account.NewContext(r.Context(), owner)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// but not once an account owns it
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/manage/"+anonymous.ID, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	api.ServeHTTP(w, r.WithContext(account.NewContext(r.Context(), stranger)))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "already belongs to another account")
+
+	claimed, err := db.GetFeed(ctx, anonymous.ID)
+	require.NoError(t, err)
+	require.Equal(t, owner.ID, claimed.AccountID.Int64)
+
+	s := New(zap.NewNop(), db, nil, Options{Domain: "mailfeed.xyz"})
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/dashboard", nil)
+	s.Dashboard(w, r.WithContext(account.NewContext(r.Context(), owner)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	require.Contains(t, body, "reader@example.com")
+	require.Contains(t, body, "<h2>Renamed</h2>")
+	require.Contains(t, body, created.ID+"@mailfeed.xyz")
+	require.Contains(t, body, `<a href="/item/`+created.ID+`/item1">Issue 1</a>`)
+	require.Contains(t, body, "<h2>Anonymous</h2>")
+
+	// anyone else is sent to sign in
+	w = httptest.NewRecorder()
+	s.Dashboard(w, httptest.NewRequest("GET", "/dashboard", nil))
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	require.Equal(t, "/signin", w.Header().Get("Location"))
+}
+
+func TestUpdateFeedIsAtomic(t *testing.T) {
+	logger := zap.NewNop()
+	path := filepath.Join(t.TempDir(), "mailfeed.db")
+	db, err := database.New(logger, path)
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{Domain: "mailfeed.xyz", AdminToken: "admin"})
+	api := chi.NewRouter()
+	api.Patch("/api/feeds/{id}", s.UpdateFeed)
+
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Feed"})
+	require.NoError(t, err)
+
+	// a second connection makes the last update fail
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Exec("CREATE TRIGGER fail_require_dkim BEFORE UPDATE OF require_dkim ON feed BEGIN SELECT RAISE(ABORT, 'disk full'); END")
+	require.NoError(t, err)
+
+	w := call(api, "PATCH", "/api/feeds/abc123", "admin", `{"name": "Renamed", "private": true, "requireDkim": true}`)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// none of the changes are kept
+	feed, err := db.GetFeed(ctx, "abc123")
+	require.NoError(t, err)
+	require.Equal(t, "Feed", feed.Name)
+	require.False(t, feed.Private)
+	require.False(t, feed.RequireDkim)
+}
@@ -0,0 +1,116 @@
+package rss
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// feedCache holds the most recently served feeds, so popular feeds aren't rebuilt
+// from the database on every request. Cached feeds are never modified, only replaced.
+// Feeds are invalidated as this process changes them, and expire after ttl so
+// changes made elsewhere, such as by reprocessing from the command line, are seen.
+type feedCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]*list.Element
+	// order holds feed IDs, most recently used first.
+	order *list.List
+	// version is bumped on every invalidation, so a feed loaded before an
+	// invalidation isn't cached after it.
+	version uint64
+}
+
+type cacheEntry struct {
+	id      string
+	feed    *feed
+	expires time.Time
+}
+
+// newFeedCache returns a cache holding up to size feeds for ttl each, or nil if size
+// isn't positive. A nil cache caches nothing.
+func newFeedCache(size int, ttl time.Duration) *feedCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &feedCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached feed with id, along with the cache's version to pass to put.
+func (c *feedCache) get(id string) (*feed, uint64) {
+	if c == nil {
+		return nil, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, c.version
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, id)
+		return nil, c.version
+	}
+
+	c.order.MoveToFront(element)
+	return entry.feed, c.version
+}
+
+// put caches a feed loaded at version, unless the cache has been invalidated since.
+func (c *feedCache) put(id string, f *feed, version uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.version {
+		return
+	}
+
+	expires := c.now().Add(c.ttl)
+	if element, ok := c.entries[id]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.feed, entry.expires = f, expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(&cacheEntry{id: id, feed: f, expires: expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+// invalidate drops the cached feed with id.
+func (c *feedCache) invalidate(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
@@ -0,0 +1,112 @@
+package rss
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/alex-emery/mailfeed/account"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/internal/website"
+	"go.uber.org/zap"
+)
+
+// dashboardItems is the number of recent items shown for each feed on the dashboard.
+const dashboardItems = 5
+
+type dashboardFeed struct {
+	FeedInfo
+	Items         []dashboardItem
+	Subscriptions []Subscription
+}
+
+type dashboardItem struct {
+	Subject string
+	Date    time.Time
+	URL     string
+	// ConfirmURL confirms the subscription the item asks to be confirmed, unless
+	// Confirmed says it was confirmed automatically.
+	ConfirmURL string
+	Confirmed  bool
+}
+
+// Shows the signed in account's feeds with their most recent items, or sends
+// anyone who isn't signed in to sign in.
+func (s *Server) Dashboard(w http.ResponseWriter, r *http.Request) {
+	owner, ok := account.FromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	infos, err := s.listAccountFeeds(r.Context(), owner.ID)
+	if err != nil {
+		s.logger.Error("Error listing feeds", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	feeds := make([]dashboardFeed, 0, len(infos))
+	for _, info := range infos {
+		items, err := s.db.ListRecentFeedItems(r.Context(), sqlc.ListRecentFeedItemsParams{
+			FeedID: info.ID,
+			Limit:  dashboardItems,
+		})
+		if err != nil {
+			s.logger.Error("Error listing feed items", zap.Error(err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		subscriptions, err := s.listSubscriptions(r.Context(), info.ID)
+		if err != nil {
+			s.logger.Error("Error listing subscriptions", zap.Error(err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		feed := dashboardFeed{FeedInfo: info, Subscriptions: subscriptions}
+		for _, item := range items {
+			date, err := time.Parse("2006-01-02 15:04:05", item.Date)
+			if err != nil {
+				s.logger.Error("Error parsing date", zap.Error(err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			feed.Items = append(feed.Items, dashboardItem{
+				Subject:    item.Subject,
+				Date:       date,
+				URL:        fmt.Sprintf("/item/%s/%s", info.ID, item.ID),
+				ConfirmURL: item.ConfirmUrl,
+				Confirmed:  item.Confirmed,
+			})
+		}
+
+		feeds = append(feeds, feed)
+	}
+
+	tmpl, err := template.ParseFS(website.Templates, "templates/dashboard.html")
+	if err != nil {
+		s.logger.Error("Error parsing template", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	templateOptions := struct {
+		Email  string
+		Domain string
+		Feeds  []dashboardFeed
+	}{
+		Email:  owner.Email,
+		Domain: s.domain,
+		Feeds:  feeds,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, templateOptions); err != nil {
+		s.logger.Error("Error executing template", zap.Error(err))
+	}
+}
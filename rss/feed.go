@@ -0,0 +1,379 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"mime"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/gorilla/feeds"
+)
+
+func NewFeed(title string) *feeds.Feed {
+	now := time.Now()
+	feed := &feeds.Feed{
+		Title:   title,
+		Author:  &feeds.Author{Name: "Mail Feed", Email: "feed@mailfeed.xyz"},
+		Created: now,
+	}
+
+	return feed
+}
+
+// feed is a feeds.Feed along with each item's category and attachments, which feeds.Item
+// has no fields for. Items hold their feed_item ID, which is turned into a permalink when
+// the feed is served.
+type feed struct {
+	*feeds.Feed
+	id          string
+	categories  map[*feeds.Item]string
+	attachments map[*feeds.Item][]sqlc.ListFeedAttachmentsRow
+	// stored is the feed's row, which says who may read a private feed.
+	stored sqlc.Feed
+}
+
+func newFeed(id string, title string) *feed {
+	return &feed{
+		Feed:        NewFeed(title),
+		id:          id,
+		categories:  make(map[*feeds.Item]string),
+		attachments: make(map[*feeds.Item][]sqlc.ListFeedAttachmentsRow),
+	}
+}
+
+// buildFeed builds a feed from its stored items and their attachments. The feed is
+// marked updated when its newest item was.
+func buildFeed(stored sqlc.Feed, items []sqlc.FeedItem, attachments []sqlc.ListFeedAttachmentsRow) (*feed, error) {
+	byItem := make(map[string][]sqlc.ListFeedAttachmentsRow)
+	for _, attachment := range attachments {
+		byItem[attachment.ItemID] = append(byItem[attachment.ItemID], attachment)
+	}
+
+	built := newFeed(stored.ID, stored.Name)
+	built.stored = stored
+	for _, item := range items {
+		date, err := time.Parse("2006-01-02 15:04:05", item.Date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse date: %w", err)
+		}
+
+		added := &feeds.Item{
+			Id:          item.ID,
+			Title:       item.Subject,
+			Description: item.Body,
+			Created:     date,
+		}
+
+		if item.ConfirmUrl != "" {
+			surfaceConfirmation(added, item)
+		}
+
+		built.add(added, item.Category)
+		if attached := byItem[item.ID]; len(attached) > 0 {
+			built.attachments[added] = attached
+		}
+
+		if date.After(built.Updated) {
+			built.Updated = date
+		}
+	}
+
+	return built, nil
+}
+
+// surfaceConfirmation makes a subscription confirmation stand out in the feed, with its
+// confirmation link at the top of the item, unless it was confirmed automatically.
+func surfaceConfirmation(added *feeds.Item, item sqlc.FeedItem) {
+	if item.Confirmed {
+		added.Title = "Subscription confirmed: " + item.Subject
+		added.Description = "<p><strong>This subscription was confirmed automatically.</strong></p>" + item.Body
+		return
+	}
+
+	added.Title = "Confirm your subscription: " + item.Subject
+	added.Description = fmt.Sprintf(`<p><strong><a href="%s">Confirm your subscription</a></strong></p>`, html.EscapeString(item.ConfirmUrl)) + item.Body
+}
+
+func (f *feed) add(item *feeds.Item, category string) {
+	f.Items = append(f.Items, item)
+	if category != "" {
+		f.categories[item] = category
+	}
+}
+
+// rssFeed replaces feeds.RssFeed's items with ones whose guid says whether it
+// is a permalink, which feeds.RssItem has no field for.
+type rssFeed struct {
+	*feeds.RssFeed
+	Items []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	*feeds.RssItem
+	Guid *rssGuid `xml:"guid,omitempty"`
+}
+
+type rssGuid struct {
+	ID          string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssFeedXml struct {
+	XMLName          xml.Name `xml:"rss"`
+	Version          string   `xml:"version,attr"`
+	ContentNamespace string   `xml:"xmlns:content,attr"`
+	Channel          *rssFeed
+}
+
+func (r *rssFeed) FeedXml() interface{} {
+	return &rssFeedXml{
+		Version:          "2.0",
+		ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+		Channel:          r,
+	}
+}
+
+// toRss returns the feed as RSS 2.0. base is the URL item permalinks are relative to,
+// and token is the read token they're given if the feed is private.
+func (f *feed) toRss(base string, token string) (string, error) {
+	feed := *f.Feed
+	feed.Items = f.items(base, token)
+	rss := &rssFeed{RssFeed: (&feeds.Rss{Feed: &feed}).RssFeed()}
+	for i, item := range f.Items {
+		entry := &rssItem{RssItem: rss.RssFeed.Items[i]}
+		entry.Category = f.categories[item]
+		if entry.RssItem.Guid != "" {
+			entry.Guid = &rssGuid{ID: entry.RssItem.Guid, IsPermaLink: true}
+		}
+
+		rss.Items = append(rss.Items, entry)
+	}
+
+	return feeds.ToXML(rss)
+}
+
+// items returns copies of the feed's items with their IDs and links set to their permalinks.
+// An item's first attachment becomes its enclosure, as RSS only allows one. Links to
+// items and media carry token, so that they can be followed from private feeds.
+func (f *feed) items(base string, token string) []*feeds.Item {
+	items := make([]*feeds.Item, len(f.Items))
+	for i, item := range f.Items {
+		copied := *item
+		if item.Id != "" {
+			copied.Id = withToken(fmt.Sprintf("%s/item/%s/%s", base, f.id, item.Id), token)
+			copied.Link = &feeds.Link{Href: copied.Id}
+		}
+
+		copied.Description = mediaWithToken(item.Description, base, token)
+		if attachments := f.attachments[item]; len(attachments) > 0 {
+			copied.Enclosure = &feeds.Enclosure{
+				Url:    withToken(mediaURL(base, attachments[0].ID), token),
+				Length: strconv.FormatInt(attachments[0].Size, 10),
+				Type:   attachments[0].ContentType,
+			}
+		}
+
+		items[i] = &copied
+	}
+
+	return items
+}
+
+// atomFeed replaces feeds.AtomFeed's entries with ones whose category is written
+// as a term attribute, as Atom requires, rather than as text.
+type atomFeed struct {
+	*feeds.AtomFeed
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	*feeds.AtomEntry
+	Category *atomCategory `xml:"category,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (a *atomFeed) FeedXml() interface{} {
+	return a
+}
+
+// toAtom returns the feed as Atom 1.0. self is the URL the feed is served from,
+// base is the URL item permalinks are relative to and token is the read token
+// they're given if the feed is private.
+func (f *feed) toAtom(self string, base string, token string) (string, error) {
+	atom := &atomFeed{AtomFeed: (&feeds.Atom{Feed: f.withContent(base, token)}).AtomFeed()}
+	atom.Id = self
+	atom.Link = &feeds.AtomLink{Href: self, Rel: "self", Type: "application/atom+xml"}
+	for i, item := range f.Items {
+		entry := &atomEntry{AtomEntry: atom.AtomFeed.Entries[i]}
+		// feeds.Atom always adds an alternate link, even if the item has none.
+		links := entry.Links[:0]
+		for _, link := range entry.Links {
+			if link.Href != "" {
+				links = append(links, link)
+			}
+		}
+		entry.Links = links
+
+		// feeds.Atom only links the first attachment.
+		if attachments := f.attachments[item]; len(attachments) > 1 {
+			for _, attachment := range attachments[1:] {
+				entry.Links = append(entry.Links, feeds.AtomLink{
+					Href:   withToken(mediaURL(base, attachment.ID), token),
+					Rel:    "enclosure",
+					Type:   attachment.ContentType,
+					Length: strconv.FormatInt(attachment.Size, 10),
+				})
+			}
+		}
+
+		if category := f.categories[item]; category != "" {
+			entry.Category = &atomCategory{Term: category}
+		}
+
+		atom.Entries = append(atom.Entries, entry)
+	}
+
+	return feeds.ToXML(atom)
+}
+
+// toJSON returns the feed as JSON Feed 1.1. self is the URL the feed is served from,
+// base is the URL item permalinks are relative to and token is the read token
+// they're given if the feed is private.
+func (f *feed) toJSON(self string, base string, token string) (string, error) {
+	jsonFeed := (&feeds.JSON{Feed: f.withContent(base, token)}).JSONFeed()
+	jsonFeed.FeedUrl = self
+	for i, item := range f.Items {
+		if category := f.categories[item]; category != "" {
+			jsonFeed.Items[i].Tags = []string{category}
+		}
+
+		for _, attachment := range f.attachments[item] {
+			jsonFeed.Items[i].Attachments = append(jsonFeed.Items[i].Attachments, feeds.JSONAttachment{
+				Url:      withToken(mediaURL(base, attachment.ID), token),
+				MIMEType: attachment.ContentType,
+				Title:    attachment.Filename,
+				Size:     int32(attachment.Size),
+			})
+		}
+	}
+
+	return jsonFeed.ToJSON()
+}
+
+// withContent returns a copy of the feed for formats which distinguish an item's
+// content from its summary. Newsletters are served whole, so the body becomes the content.
+func (f *feed) withContent(base string, token string) *feeds.Feed {
+	copied := *f.Feed
+	copied.Items = f.items(base, token)
+	for _, item := range copied.Items {
+		item.Content = item.Description
+		item.Description = ""
+	}
+
+	return &copied
+}
+
+// mediaURL returns the URL an attachment is served from.
+func mediaURL(base string, id string) string {
+	return fmt.Sprintf("%s/media/%s", base, id)
+}
+
+// withToken adds a private feed's read token to link. Links are unchanged if token
+// is empty.
+func withToken(link string, token string) string {
+	if token == "" {
+		return link
+	}
+
+	return link + "?token=" + url.QueryEscape(token)
+}
+
+// mediaWithToken adds a private feed's read token to the links to inline images
+// in body, which were pointed at base's media when the message was delivered.
+func mediaWithToken(body string, base string, token string) string {
+	if token == "" {
+		return body
+	}
+
+	media := regexp.MustCompile(regexp.QuoteMeta(mediaURL(base, "")) + `[0-9A-Za-z_-]+`)
+	return media.ReplaceAllStringFunc(body, func(link string) string {
+		return withToken(link, token)
+	})
+}
+
+// format is a syndication format a feed can be served in.
+type format int
+
+const (
+	formatRss format = iota
+	formatAtom
+	formatJSON
+)
+
+var contentTypes = map[format]string{
+	formatRss:  "application/rss+xml",
+	formatAtom: "application/atom+xml",
+	formatJSON: "application/feed+json",
+}
+
+// negotiate picks the format an Accept header prefers most, by its q-values, with
+// the order the client listed them breaking ties. RSS is served without an Accept
+// header, or if a wildcard accepts it and no other format is asked for. It reports
+// false if the header accepts none of the formats.
+func negotiate(accept string) (format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatRss, true
+	}
+
+	best, bestQ := formatRss, 0.0
+	wildcard, rssRefused := false, false
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if weight, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(weight, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		var f format
+		switch mediaType {
+		case "*/*", "application/*":
+			wildcard = wildcard || q > 0
+			continue
+		case "application/rss+xml":
+			f = formatRss
+			rssRefused = q <= 0
+		case "application/atom+xml":
+			f = formatAtom
+		case "application/feed+json", "application/json":
+			f = formatJSON
+		default:
+			continue
+		}
+
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+
+	if bestQ > 0 {
+		return best, true
+	}
+
+	return formatRss, wildcard && !rssRefused
+}
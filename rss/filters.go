@@ -0,0 +1,281 @@
+package rss
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// FilterRule drops, tags, renames or redirects the messages delivered to a feed
+// which it matches. See mail.FilterRule for what each field means.
+type FilterRule struct {
+	ID      int64  `json:"id"`
+	Field   string `json:"field"`
+	Header  string `json:"header,omitempty"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+	Value   string `json:"value,omitempty"`
+	Target  string `json:"target,omitempty"`
+}
+
+// FilterRuleRequest is a filter rule to add, change or try out. Field is "sender",
+// "subject", "header" or "body", and Action is "drop", "tag", "rename" or "redirect".
+type FilterRuleRequest struct {
+	Field   string
+	Header  string
+	Pattern string
+	Action  string
+	Value   string
+	Target  string
+}
+
+// DryRunMatch is a stored email a filter rule matches, and what it would do to it.
+type DryRunMatch struct {
+	EmailID int64             `json:"emailId"`
+	Sender  string            `json:"sender"`
+	Subject string            `json:"subject"`
+	Date    time.Time         `json:"date"`
+	Result  mail.FilterResult `json:"result"`
+}
+
+// DryRunResponse lists the emails in a feed a filter rule would have matched.
+type DryRunResponse struct {
+	Checked int           `json:"checked"`
+	Matches []DryRunMatch `json:"matches"`
+}
+
+// Lists a feed's filter rules, in the order they're applied.
+func (s *Server) ListFilterRules(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	rules, err := s.db.ListFilterRules(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error listing filter rules", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]FilterRule, 0, len(rules))
+	for _, rule := range rules {
+		infos = append(infos, filterRule(rule))
+	}
+
+	s.writeJSON(w, http.StatusOK, infos)
+}
+
+// Adds a filter rule to a feed, after its existing rules.
+func (s *Server) CreateFilterRule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	rule, ok := s.decodeFilterRule(w, r, feed)
+	if !ok {
+		return
+	}
+
+	created, err := s.db.CreateFilterRule(r.Context(), sqlc.CreateFilterRuleParams{
+		FeedID:       feed.ID,
+		Field:        rule.Field,
+		Header:       rule.Header,
+		Pattern:      rule.Pattern,
+		Action:       rule.Action,
+		Value:        rule.Value,
+		TargetFeedID: sql.NullString{String: rule.Target, Valid: rule.Target != ""},
+	})
+	if err != nil {
+		s.logger.Error("Error creating filter rule", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, filterRule(created))
+}
+
+// Replaces one of a feed's filter rules, keeping its place in the order.
+func (s *Server) UpdateFilterRule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "filter"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	rule, ok := s.decodeFilterRule(w, r, feed)
+	if !ok {
+		return
+	}
+
+	updated, err := s.db.UpdateFilterRule(r.Context(), sqlc.UpdateFilterRuleParams{
+		Field:        rule.Field,
+		Header:       rule.Header,
+		Pattern:      rule.Pattern,
+		Action:       rule.Action,
+		Value:        rule.Value,
+		TargetFeedID: sql.NullString{String: rule.Target, Valid: rule.Target != ""},
+		ID:           id,
+		FeedID:       feed.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Error updating filter rule", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, filterRule(updated))
+}
+
+// Deletes one of a feed's filter rules.
+func (s *Server) DeleteFilterRule(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "filter"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := s.db.DeleteFilterRule(r.Context(), sqlc.DeleteFilterRuleParams{ID: id, FeedID: feed.ID})
+	if err != nil {
+		s.logger.Error("Error deleting filter rule", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if deleted == 0 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Shows which of the emails stored for a feed a filter rule would have matched, and
+// what it would have done to them, without storing the rule.
+func (s *Server) DryRunFilterRule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	rule, ok := s.decodeFilterRule(w, r, feed)
+	if !ok {
+		return
+	}
+
+	emails, err := s.db.ListRawEmailsForFeed(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error listing emails", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := DryRunResponse{Matches: []DryRunMatch{}}
+	for _, email := range emails {
+		result, matched, err := mail.DryRunFilter(rule, email)
+		if err != nil {
+			s.logger.Warn("Error filtering email", zap.Int64("id", email.ID), zap.Error(err))
+			continue
+		}
+
+		response.Checked++
+		if !matched {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02 15:04:05", email.Date)
+		if err != nil {
+			s.logger.Error("Error parsing date", zap.Error(err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		response.Matches = append(response.Matches, DryRunMatch{
+			EmailID: email.ID,
+			Sender:  email.Sender,
+			Subject: email.Subject,
+			Date:    date,
+			Result:  result,
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// decodeFilterRule reads a filter rule for feed from the request. Rules may only
+// redirect to another feed the request may manage, or which has the same owner.
+// Otherwise it writes an error and returns false.
+func (s *Server) decodeFilterRule(w http.ResponseWriter, r *http.Request, feed sqlc.Feed) (mail.FilterRule, bool) {
+	req := FilterRuleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return mail.FilterRule{}, false
+	}
+
+	rule, err := mail.FilterRule(req).Normalize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return mail.FilterRule{}, false
+	}
+
+	if rule.Action != mail.FilterRedirect {
+		return rule, true
+	}
+
+	target, err := s.db.GetFeed(r.Context(), rule.Target)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return mail.FilterRule{}, false
+	}
+
+	sameOwner := feed.AccountID.Valid && feed.AccountID == target.AccountID
+	if err != nil || target.ID == feed.ID || !sameOwner && !s.authorize(r, target, bearerToken(r)) {
+		http.Error(w, "Messages can only be redirected to another feed you manage", http.StatusBadRequest)
+		return mail.FilterRule{}, false
+	}
+
+	return rule, true
+}
+
+func filterRule(rule sqlc.FilterRule) FilterRule {
+	return FilterRule{
+		ID:      rule.ID,
+		Field:   rule.Field,
+		Header:  rule.Header,
+		Pattern: rule.Pattern,
+		Action:  rule.Action,
+		Value:   rule.Value,
+		Target:  rule.TargetFeedID.String,
+	}
+}
@@ -0,0 +1,114 @@
+package rss
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterRules(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	mine := createFeed(t, api, `{"name": "Mine"}`)
+	other := createFeed(t, api, `{"name": "Other"}`)
+
+	w := call(api, "POST", "/api/feeds/"+mine.ID+"/filters", mine.Token, `{"field": "subject", "pattern": "(", "action": "drop"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// messages can't be redirected to a feed the request can't manage
+	w = call(api, "POST", "/api/feeds/"+mine.ID+"/filters", mine.Token, fmt.Sprintf(`{"field": "subject", "pattern": "x", "action": "redirect", "target": %q}`, other.ID))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = call(api, "POST", "/api/feeds/"+mine.ID+"/filters", "admin", fmt.Sprintf(`{"field": "subject", "pattern": "x", "action": "redirect", "target": %q}`, other.ID))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = call(api, "POST", "/api/feeds/"+mine.ID+"/filters", mine.Token, `{"field": "Sender", "pattern": "Example.com", "action": "tag", "value": "example"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	rule := FilterRule{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rule))
+	require.Equal(t, "sender", rule.Field)
+	require.Equal(t, "*@example.com", rule.Pattern)
+
+	w = call(api, "GET", "/api/feeds/"+mine.ID+"/filters", mine.Token, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	rules := []FilterRule{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rules))
+	require.Len(t, rules, 2)
+	require.Equal(t, "redirect", rules[0].Action)
+	require.Equal(t, other.ID, rules[0].Target)
+	require.Equal(t, rule, rules[1])
+
+	path := fmt.Sprintf("/api/feeds/%s/filters/%d", mine.ID, rule.ID)
+	w = call(api, "PUT", path, mine.Token, `{"field": "header", "header": "list-id", "pattern": "golang", "action": "rename", "value": "Go: {subject}"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rule))
+	require.Equal(t, FilterRule{ID: rule.ID, Field: "header", Header: "List-Id", Pattern: "golang", Action: "rename", Value: "Go: {subject}"}, rule)
+	require.Equal(t, http.StatusUnauthorized, call(api, "PUT", path, other.Token, `{"field": "subject", "pattern": "x", "action": "drop"}`).Code)
+
+	// rules are only changed through their own feed
+	elsewhere := fmt.Sprintf("/api/feeds/%s/filters/%d", other.ID, rule.ID)
+	require.Equal(t, http.StatusNotFound, call(api, "PUT", elsewhere, other.Token, `{"field": "subject", "pattern": "x", "action": "drop"}`).Code)
+
+	// a dry run shows which stored emails a rule matches, without storing it
+	for i, subject := range []string{"Weekly digest", "Sponsored: buy now", "Another sponsored post"} {
+		var raw bytes.Buffer
+		gz := gzip.NewWriter(&raw)
+		_, err := fmt.Fprintf(gz, "From: news@example.com\r\nSubject: %s\r\n\r\nHello\r\n", subject)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		email, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{
+			Date:        fmt.Sprintf("2006-01-0%d 15:04:05", i+1),
+			Sender:      "news@example.com",
+			Subject:     subject,
+			Description: "<p>Hello</p>",
+			Raw:         raw.Bytes(),
+		})
+		require.NoError(t, err)
+
+		_, err = db.CreateFeedItem(ctx, sqlc.CreateFeedItemParams{
+			ID:      GenerateRandomString(12),
+			FeedID:  mine.ID,
+			Subject: subject,
+			Date:    email.Date,
+			EmailID: sql.NullInt64{Int64: email.ID, Valid: true},
+		})
+		require.NoError(t, err)
+	}
+
+	w = call(api, "POST", "/api/feeds/"+mine.ID+"/filters/dry-run", mine.Token, `{"field": "subject", "pattern": "(?i)sponsored", "action": "tag", "value": "ads"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	dryRun := DryRunResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&dryRun))
+	require.Equal(t, 3, dryRun.Checked)
+	require.Len(t, dryRun.Matches, 2)
+	require.Equal(t, "Sponsored: buy now", dryRun.Matches[0].Subject)
+	require.Equal(t, "ads", dryRun.Matches[0].Result.Category)
+
+	w = call(api, "GET", "/api/feeds/"+mine.ID+"/filters", mine.Token, "")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rules))
+	require.Len(t, rules, 2)
+
+	require.Equal(t, http.StatusNoContent, call(api, "DELETE", path, mine.Token, "").Code)
+	require.Equal(t, http.StatusNotFound, call(api, "DELETE", path, mine.Token, "").Code)
+
+	// rules redirecting to a feed are deleted with it
+	require.Equal(t, http.StatusNoContent, call(api, "DELETE", "/api/feeds/"+other.ID, other.Token, "").Code)
+
+	stored, err := db.ListFilterRules(ctx, mine.ID)
+	require.NoError(t, err)
+	require.Empty(t, stored)
+}
@@ -0,0 +1,184 @@
+package rss
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/alex-emery/mailfeed/account"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/internal/website"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Shows the page feeds are managed from in a browser.
+func (s *Server) ManagePage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(website.Templates, "templates/manage.html")
+	if err != nil {
+		s.logger.Error("Error parsing template", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, nil); err != nil {
+		s.logger.Error("Error executing template", zap.Error(err))
+	}
+}
+
+// Shows the management panel of the feed in the form, if the form's token may manage it.
+func (s *Server) ManageFeed(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	feed, ok := s.manageableFeed(w, r, strings.TrimSpace(r.PostForm.Get("id")))
+	if !ok {
+		return
+	}
+
+	s.renderPanel(w, r, feed, "")
+}
+
+// Applies an action from a feed's management panel: rename, private, public, rotate,
+// claim or delete. Claiming adds the feed to the signed in account.
+func (s *Server) ManageFeedAction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	feed, ok := s.manageableFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	var readToken string
+	var err error
+	switch r.PostForm.Get("action") {
+	case "rename":
+		name := strings.TrimSpace(r.PostForm.Get("name"))
+		if name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+
+		_, err = s.updateFeed(r.Context(), feed, UpdateFeedRequest{Name: &name})
+	case "private", "public":
+		private := r.PostForm.Get("action") == "private"
+		readToken, err = s.updateFeed(r.Context(), feed, UpdateFeedRequest{Private: &private})
+	case "rotate":
+		err = s.rotateAddress(r.Context(), feed)
+	case "claim":
+		owner, ok := account.FromContext(r.Context())
+		if !ok {
+			http.Error(w, "Sign in to add feeds to your account", http.StatusBadRequest)
+			return
+		}
+
+		// the token manages the feed, but doesn't take it from the account which owns it
+		if feed.AccountID.Valid && feed.AccountID.Int64 != owner.ID {
+			s.executePanel(w, panel{Error: "That feed already belongs to another account."})
+			return
+		}
+
+		feed.AccountID = sql.NullInt64{Int64: owner.ID, Valid: true}
+		err = s.db.SetFeedAccount(r.Context(), sqlc.SetFeedAccountParams{AccountID: feed.AccountID, ID: feed.ID})
+		// the cached feed says who may read it while it's private
+		s.cache.invalidate(feed.ID)
+	case "delete":
+		if err := s.deleteFeed(r.Context(), feed.ID); err != nil {
+			s.logger.Error("Error deleting feed", zap.Error(err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		_, signedIn := account.FromContext(r.Context())
+		s.executePanel(w, panel{Feed: FeedInfo{Name: feed.Name}, Deleted: true, SignedIn: signedIn})
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Error managing feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderPanel(w, r, feed, readToken)
+}
+
+// manageableFeed returns the feed with id if the form's token may manage it. Otherwise
+// it renders an error, which doesn't say whether the feed exists, and returns false.
+func (s *Server) manageableFeed(w http.ResponseWriter, r *http.Request, id string) (sqlc.Feed, bool) {
+	feed, err := s.db.GetFeed(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return sqlc.Feed{}, false
+	}
+
+	if err != nil || !s.authorize(r, feed, r.PostForm.Get("token")) {
+		// htmx only swaps in successful responses
+		s.executePanel(w, panel{Error: "That feed doesn't exist, or the token is wrong."})
+		return sqlc.Feed{}, false
+	}
+
+	return feed, true
+}
+
+// panel is what templates/feed.html renders.
+type panel struct {
+	Feed    FeedInfo
+	Domain  string
+	Token   string
+	Error   string
+	Deleted bool
+	// ReadToken is set when the feed has just been made private.
+	ReadToken string
+	// SignedIn is set when the request is from an account, and Owned when that
+	// account owns the feed.
+	SignedIn bool
+	Owned    bool
+}
+
+func (s *Server) renderPanel(w http.ResponseWriter, r *http.Request, feed sqlc.Feed, readToken string) {
+	info, err := s.getFeedInfo(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	owner, signedIn := account.FromContext(r.Context())
+	s.executePanel(w, panel{
+		Feed:      info,
+		Domain:    s.domain,
+		Token:     r.PostForm.Get("token"),
+		ReadToken: readToken,
+		SignedIn:  signedIn,
+		Owned:     signedIn && feed.AccountID.Valid && feed.AccountID.Int64 == owner.ID,
+	})
+}
+
+func (s *Server) executePanel(w http.ResponseWriter, data panel) {
+	tmpl, err := template.ParseFS(website.Templates, "templates/feed.html")
+	if err != nil {
+		s.logger.Error("Error parsing template", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the panel holds the feed's token
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, data); err != nil {
+		s.logger.Error("Error executing template", zap.Error(err))
+	}
+}
@@ -7,80 +7,119 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/alex-emery/mailfeed/account"
 	"github.com/alex-emery/mailfeed/database"
 	"github.com/alex-emery/mailfeed/database/sqlc"
 	"github.com/alex-emery/mailfeed/internal/website"
+	"github.com/alex-emery/mailfeed/mail"
 	"github.com/alex-emery/mailfeed/newsletter"
 	"github.com/go-chi/chi"
-	"github.com/gorilla/feeds"
 	"go.uber.org/zap"
 )
 
-func NewFeed(title string) *feeds.Feed {
-	now := time.Now()
-	feed := &feeds.Feed{
-		Title:   title,
-		Author:  &feeds.Author{Name: "Mail Feed", Email: "feed@mailfeed.xyz"},
-		Created: now,
+// baseURL returns the public URL of the service, which permalinks are relative to.
+func (s *Server) baseURL() string {
+	return "https://" + s.domain
+}
+
+// selfURL returns the URL r was made to.
+func selfURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
 	}
 
-	return feed
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
 }
 
-func (s *Server) AddToFeed(letter *newsletter.NewsLetter) {
-	s.logger.Info("Adding to feed", zap.String("subject", letter.Subject))
-	if _, ok := s.feeds[letter.Inbox]; !ok {
-		feed, err := s.db.GetFeed(context.Background(), letter.Inbox)
-		if err != nil {
-			s.logger.Error("Error getting inbox", zap.Error(err))
+// Run refreshes feeds as newsletters are queued for them, until ctx is cancelled.
+// Newsletters are stored before they're queued, so nothing is lost if Run stops
+// with newsletters still queued.
+func (s *Server) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case letter := <-s.queue.Receive():
+			s.refresh(letter)
 		}
-
-		s.feeds[letter.Inbox] = NewFeed(feed.Name)
 	}
+}
 
-	date := letter.Date.Format("2006-01-02 15:04:05")
-	_, err := s.db.CreateFeedItem(context.Background(), sqlc.CreateFeedItemParams{
-		FeedID:  letter.Inbox,
-		Subject: letter.Subject,
-		Body:    letter.Body,
-		Date:    date,
-	})
+// refresh makes sure the next request for letter's feed includes it.
+func (s *Server) refresh(letter *newsletter.NewsLetter) {
+	s.logger.Info("Adding to feed", zap.String("feed", letter.Inbox), zap.String("subject", letter.Subject))
+	s.cache.invalidate(letter.Inbox)
+}
 
-	if err != nil {
-		s.logger.Error("Error creating feed item", zap.Error(err))
-		return
-	}
+// DefaultItemLimit is the number of items served in a feed if Options doesn't say.
+const DefaultItemLimit = 50
 
-	s.feeds[letter.Inbox].Items = append(s.feeds[letter.Inbox].Items, &feeds.Item{
-		Title:       letter.Subject,
-		Description: letter.Body,
-		Created:     letter.Date,
-	})
+// DefaultCacheTTL is how long feeds are cached for if Options doesn't say.
+const DefaultCacheTTL = time.Minute
+
+// Options configure how feeds are served.
+type Options struct {
+	// Domain is the domain feeds receive mail on and are served from.
+	Domain string
+	// ItemLimit is the number of most recent items served in a feed, DefaultItemLimit if zero.
+	ItemLimit int
+	// CacheSize is the number of feeds kept in memory between requests. Feeds are
+	// always read from the database if it is zero.
+	CacheSize int
+	// CacheTTL is how long a feed is cached for, DefaultCacheTTL if zero. Feeds this
+	// server changes are dropped from the cache straight away, but changes made by
+	// other processes are only seen once their cached copy expires.
+	CacheTTL time.Duration
+	// AdminToken may list and manage every feed. Only feeds' own tokens are accepted if it's empty.
+	AdminToken string
+	// Unsubscriber unsubscribes feeds from their senders. If it's nil, only senders
+	// supporting one-click unsubscription can be, as there's no relay for mailto links.
+	Unsubscriber *mail.Unsubscriber
 }
 
 type Server struct {
-	feeds    map[string]*feeds.Feed
-	logger   *zap.Logger
-	feedChan <-chan *newsletter.NewsLetter
-	db       *database.Database
-	domain   string
+	logger    *zap.Logger
+	queue     *newsletter.Queue
+	db        *database.Database
+	domain    string
+	itemLimit int
+	cache     *feedCache
+	// adminTokenHash is empty if there's no admin token.
+	adminTokenHash string
+	unsubscriber   *mail.Unsubscriber
 }
 
 type CreateFeedRequest struct {
 	Name string
+	// Private feeds are only served to requests with the read token they're created with.
+	Private bool
+}
+
+// CreateFeedResponse is returned to API clients which create a feed. Token is the
+// feed's management token, which is only ever returned here.
+type CreateFeedResponse struct {
+	FeedInfo
+	Token string `json:"token"`
 }
 
 // Creates a feed. Feeds consist of a name and an ID.
 // The name is used as the feeds title, and is human friendly.
 // The ID is used as the email username and is random.
+// The feed's owner is given a token which authorizes managing it.
 func (s *Server) CreateFeed(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	req := CreateFeedRequest{}
-	if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+	form := r.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
+	if form {
 		err := r.ParseForm()
 		if err != nil {
 			s.logger.Error("Error parsing form", zap.Error(err))
@@ -94,6 +133,8 @@ func (s *Server) CreateFeed(w http.ResponseWriter, r *http.Request) {
 				break
 			}
 		}
+
+		req.Private = r.Form.Get("private") == "on"
 	} else {
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			w.WriteHeader(500)
@@ -105,12 +146,58 @@ func (s *Server) CreateFeed(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	feed, err := s.db.CreateFeed(r.Context(), sqlc.CreateFeedParams{
-		ID:   GenerateRandomString(6),
-		Name: req.Name,
+	token, tokenHash, err := newToken()
+	if err != nil {
+		s.logger.Error("Error generating token", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+
+	var readToken, readTokenHash string
+	if req.Private {
+		readToken, readTokenHash, err = newToken()
+		if err != nil {
+			s.logger.Error("Error generating token", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	// feeds created while signed in belong to the account
+	var owner sql.NullInt64
+	if signedIn, ok := account.FromContext(r.Context()); ok {
+		owner = sql.NullInt64{Int64: signedIn.ID, Valid: true}
+	}
+
+	// a feed receives mail at its ID, so it mustn't be another feed's address or alias
+	var feed sqlc.Feed
+	err = s.db.InTx(r.Context(), func(q *sqlc.Queries) error {
+		id, err := newAddress(r.Context(), q)
+		if err != nil {
+			return err
+		}
+
+		feed, err = q.CreateFeed(r.Context(), sqlc.CreateFeedParams{
+			ID:            id,
+			Name:          req.Name,
+			TokenHash:     tokenHash,
+			Private:       req.Private,
+			ReadTokenHash: readTokenHash,
+			AccountID:     owner,
+		})
+		return err
 	})
 	if err != nil {
+		s.logger.Error("Error creating feed", zap.Error(err))
 		w.WriteHeader(500)
+		return
+	}
+
+	if !form {
+		info := s.feedInfo(sqlc.GetFeedSummaryRow{ID: feed.ID, Name: feed.Name, Address: feed.ID, Private: feed.Private})
+		info.ReadToken = readToken
+		s.writeJSON(w, http.StatusOK, CreateFeedResponse{FeedInfo: info, Token: token})
+		return
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -122,87 +209,368 @@ func (s *Server) CreateFeed(w http.ResponseWriter, r *http.Request) {
 	}
 
 	templateOptions := struct {
-		ID     string
-		Domain string
+		ID        string
+		Domain    string
+		Token     string
+		ReadToken string
+		SignedIn  bool
 	}{
-		ID:     feed.ID,
-		Domain: s.domain,
+		ID:        feed.ID,
+		Domain:    s.domain,
+		Token:     token,
+		ReadToken: readToken,
+		SignedIn:  owner.Valid,
 	}
 
 	err = tmpl.Execute(w, templateOptions)
 	if err != nil {
 		s.logger.Error("Error executing template", zap.Error(err))
 	}
-
-	s.feeds[feed.ID] = NewFeed(feed.Name)
 }
 
 // Gets a feed for a given id, which is the username part of the email address.
+// The feed is RSS unless the Accept header asks for Atom or JSON Feed, and isn't
+// served at all if the header accepts none of them.
 func (s *Server) GetFeed(w http.ResponseWriter, r *http.Request) {
+	f, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		w.Header().Add("Vary", "Accept")
+		http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+		return
+	}
+
+	s.serveFeed(w, r, f)
+}
+
+// Gets a feed as Atom.
+func (s *Server) GetAtomFeed(w http.ResponseWriter, r *http.Request) {
+	s.serveFeed(w, r, formatAtom)
+}
+
+// Gets a feed as JSON Feed.
+func (s *Server) GetJSONFeed(w http.ResponseWriter, r *http.Request) {
+	s.serveFeed(w, r, formatJSON)
+}
+
+// loadFeed returns the feed with id and its most recent items, from the cache if it's there.
+func (s *Server) loadFeed(ctx context.Context, id string) (*feed, error) {
+	cached, version := s.cache.get(id)
+	if cached != nil {
+		return cached, nil
+	}
+
+	stored, err := s.db.GetFeed(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feed: %w", err)
+	}
+
+	items, err := s.db.ListRecentFeedItems(ctx, sqlc.ListRecentFeedItemsParams{
+		FeedID: id,
+		Limit:  int64(s.itemLimit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feed items: %w", err)
+	}
+
+	attachments, err := s.db.ListFeedAttachments(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+
+	loaded, err := buildFeed(stored, items, attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.put(id, loaded, version)
+	return loaded, nil
+}
+
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request, f format) {
 	inboxID := chi.URLParam(r, "id")
 
-	if s.feeds[inboxID] == nil {
+	feed, err := s.loadFeed(r.Context(), inboxID)
+	// private feeds are hidden from anyone without their read token, except their owner
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !s.canRead(r, feed.stored)) {
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
-	content, err := s.feeds[inboxID].ToRss()
 	if err != nil {
+		s.logger.Error("Error loading feed", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// private feeds link to their items and media with the read token too
+	var token string
+	if feed.stored.Private {
+		token = r.URL.Query().Get("token")
+	}
+
+	self := withToken(selfURL(r), token)
+
+	var content string
+	switch f {
+	case formatAtom:
+		content, err = feed.toAtom(self, s.baseURL(), token)
+	case formatJSON:
+		content, err = feed.toJSON(self, s.baseURL(), token)
+	default:
+		content, err = feed.toRss(s.baseURL(), token)
 	}
 
-	w.Header().Set("Content-Type", "application/rss+xml")
 	if err != nil {
+		s.logger.Error("Error generating feed", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", contentTypes[f])
+	w.Header().Add("Vary", "Accept")
 	_, err = w.Write([]byte(content))
 	if err != nil {
 		s.logger.Error("Error writing response", zap.Error(err))
 	}
 }
 
-func New(logger *zap.Logger, db *database.Database, feedChan <-chan *newsletter.NewsLetter, domain string) (*Server, error) {
-	s := &Server{
-		feeds:    make(map[string]*feeds.Feed),
-		logger:   logger,
-		feedChan: feedChan,
-		db:       db,
-		domain:   domain,
+// canRead reports whether r may read feed's items and media: if feed is public, r
+// has its read token, or r is signed in to the account owning it.
+func (s *Server) canRead(r *http.Request, feed sqlc.Feed) bool {
+	if !feed.Private || tokenMatches(r.URL.Query().Get("token"), feed.ReadTokenHash) {
+		return true
 	}
 
-	rssFeeds, err := db.ListFeeds(context.Background())
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("failed to initialise, error listing inboxes: %v", err)
+	owner, ok := account.FromContext(r.Context())
+	return ok && feed.AccountID.Valid && feed.AccountID.Int64 == owner.ID
+}
+
+// Gets a single newsletter from a feed as a standalone web page.
+func (s *Server) GetItem(w http.ResponseWriter, r *http.Request) {
+	feedID := chi.URLParam(r, "feed")
+	itemID := chi.URLParam(r, "item")
+
+	item, err := s.db.GetFeedItem(r.Context(), itemID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && item.FeedID != feedID) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
 	}
 
-	for _, inbox := range rssFeeds {
-		s.logger.Debug("Initialising feed", zap.String("name", inbox.Name), zap.String("id", inbox.ID))
-		feed := NewFeed(inbox.Name)
-		s.feeds[inbox.ID] = feed
+	if err != nil {
+		s.logger.Error("Error getting feed item", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
-		items, err := db.ListFeedItems(context.Background(), inbox.ID)
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("failed to initialise, error listing feed items: %v", err)
-		}
-		for _, item := range items {
-			s.logger.Debug("Adding item to feed", zap.String("subject", item.Subject))
-			date, err := time.Parse("2006-01-02 15:04:05", item.Date)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse date: %v", err)
-			}
-			feed.Items = append(feed.Items, &feeds.Item{
-				Title:       item.Subject,
-				Description: item.Body,
-				Created:     date,
-			})
-		}
+	feed, err := s.db.GetFeed(r.Context(), feedID)
+	if err != nil {
+		s.logger.Error("Error getting feed", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	go func() {
-		s.AddToFeed(<-s.feedChan)
-	}()
+	// items of private feeds are hidden like the feeds themselves
+	if !s.canRead(r, feed) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var token string
+	if feed.Private {
+		token = r.URL.Query().Get("token")
+	}
+
+	date, err := time.Parse("2006-01-02 15:04:05", item.Date)
+	if err != nil {
+		s.logger.Error("Error parsing date", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFS(website.Templates, "templates/item.html")
+	if err != nil {
+		s.logger.Error("Error parsing template", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	templateOptions := struct {
+		Feed    string
+		Subject string
+		Date    time.Time
+		Body    template.HTML
+	}{
+		Feed:    feed.Name,
+		Subject: item.Subject,
+		Date:    date,
+		Body:    template.HTML(mediaWithToken(item.Body, s.baseURL(), token)),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The body is the sender's HTML, so it mustn't run scripts on our origin.
+	w.Header().Set("Content-Security-Policy", "sandbox allow-popups allow-popups-to-escape-sandbox")
+	if err := tmpl.Execute(w, templateOptions); err != nil {
+		s.logger.Error("Error executing template", zap.Error(err))
+	}
+}
+
+// Gets an inline image or attachment from a newsletter. A message delivered to several
+// feeds separately stores its attachments once per email, but their IDs are hashes of
+// the message, so any of the copies will do.
+func (s *Server) GetMedia(w http.ResponseWriter, r *http.Request) {
+	attachment, err := s.db.GetAttachment(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Error getting attachment", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// an attachment is readable by anyone who can read a feed its message is in
+	containing, err := s.db.ListAttachmentFeeds(r.Context(), attachment.ID)
+	if err != nil {
+		s.logger.Error("Error listing attachment feeds", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	readable, public := false, false
+	for _, feed := range containing {
+		readable = readable || s.canRead(r, feed)
+		public = public || !feed.Private
+	}
+
+	if !readable {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	contentType := attachment.ContentType
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		contentType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	if attachment.Inline {
+		disposition = "inline"
+	}
+
+	if attachment.Filename != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename})
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Length", strconv.Itoa(len(attachment.Data)))
+	// Attachments are whatever the sender attached, so they're never sniffed or run as pages on our origin.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	// IDs are derived from the message, so an attachment never changes. Private
+	// feeds' attachments mustn't be kept by shared caches.
+	cacheControl := "public, max-age=31536000, immutable"
+	if !public {
+		cacheControl = "private, max-age=31536000, immutable"
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	if _, err := w.Write(attachment.Data); err != nil {
+		s.logger.Error("Error writing response", zap.Error(err))
+	}
+}
+
+type CreateAliasRequest struct {
+	Alias string
+}
+
+// aliasPattern restricts aliases to names which can't be mistaken for a tagged
+// address, as '+' and '.' separate a feed from its tag.
+var aliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{2,63}$`)
+
+// Creates a human friendly alias for a feed, so it can receive mail
+// on <alias>@<domain> as well as on its ID.
+func (s *Server) CreateAlias(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	req := CreateAliasRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	alias := strings.ToLower(req.Alias)
+	if !aliasPattern.MatchString(alias) {
+		http.Error(w, "Alias must be 3-64 letters, numbers or hyphens", http.StatusBadRequest)
+		return
+	}
+
+	// an alias which is also a feed's ID or address would never be resolved
+	used, err := addressInUse(r.Context(), s.db.Queries, alias)
+	if err != nil {
+		s.logger.Error("Error checking alias", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if used {
+		http.Error(w, "Alias is already in use", http.StatusConflict)
+		return
+	}
+
+	created, err := s.db.CreateFeedAlias(r.Context(), sqlc.CreateFeedAliasParams{
+		Alias:  alias,
+		FeedID: feed.ID,
+	})
+	if err != nil {
+		s.logger.Error("Error creating feed alias", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"alias":   created.Alias,
+		"address": fmt.Sprintf("%s@%s", created.Alias, s.domain),
+	}); err != nil {
+		s.logger.Error("Error writing response", zap.Error(err))
+	}
+}
+
+func New(logger *zap.Logger, db *database.Database, queue *newsletter.Queue, options Options) *Server {
+	if options.ItemLimit <= 0 {
+		options.ItemLimit = DefaultItemLimit
+	}
+
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+
+	s := &Server{
+		logger:       logger,
+		queue:        queue,
+		db:           db,
+		domain:       options.Domain,
+		itemLimit:    options.ItemLimit,
+		cache:        newFeedCache(options.CacheSize, options.CacheTTL),
+		unsubscriber: options.Unsubscriber,
+	}
+
+	if s.unsubscriber == nil {
+		s.unsubscriber = mail.NewUnsubscriber(nil, mail.Relay{})
+	}
+
+	if options.AdminToken != "" {
+		s.adminTokenHash = hashToken(options.AdminToken)
+	}
 
-	return s, nil
+	return s
 }
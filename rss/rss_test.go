@@ -3,20 +3,46 @@ package rss
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
 	"github.com/alex-emery/mailfeed/newsletter"
 	"github.com/go-chi/chi"
-	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
+// storeItem stores letter the way mail.Deliverer does.
+func storeItem(db *database.Database, letter *newsletter.NewsLetter) error {
+	_, err := db.CreateFeedItem(context.Background(), sqlc.CreateFeedItemParams{
+		ID:       GenerateRandomString(12),
+		FeedID:   letter.Inbox,
+		Subject:  letter.Subject,
+		Body:     letter.Body,
+		Date:     letter.Date.Format("2006-01-02 15:04:05"),
+		Category: letter.Category,
+	})
+
+	return err
+}
+
+// addItem stores letter and tells s about it.
+func addItem(t *testing.T, s *Server, db *database.Database, letter *newsletter.NewsLetter) {
+	require.NoError(t, storeItem(db, letter))
+	s.refresh(letter)
+}
+
 func TestGetFeed(t *testing.T) {
 	w := httptest.NewRecorder()
 
@@ -25,32 +51,21 @@ func TestGetFeed(t *testing.T) {
 		t.Fatalf("Failed to create request: %v", err)
 	}
 
-	feed := &feeds.Feed{
-		Title: "Test Feed",
-	}
-
-	feeds := map[string]*feeds.Feed{
-		"123": feed,
-	}
-
 	logger := zap.NewNop()
 
-	feedChan := make(chan *newsletter.NewsLetter)
-
 	db, err := database.New(logger, ":memory:")
 	require.NoError(t, err)
 
-	s := &Server{
-		feeds:    feeds,
-		logger:   logger,
-		feedChan: feedChan,
-		db:       &db,
-	}
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{Domain: "mailfeed.xyz"})
 
-	s.AddToFeed(&newsletter.NewsLetter{
-		Inbox:   "123",
-		Subject: "Test Subject",
-		Body:    "Test Body",
+	addItem(t, s, &db, &newsletter.NewsLetter{
+		Inbox:    "123",
+		Subject:  "Test Subject",
+		Body:     "Test Body",
+		Category: "tech",
 	})
 
 	rctx := chi.NewRouteContext()
@@ -69,6 +84,16 @@ func TestGetFeed(t *testing.T) {
 	require.Equal(t, "Test Feed", response.Title)
 	require.Equal(t, "Test Subject", response.Items[0].Title)
 	require.Equal(t, "Test Body", response.Items[0].Description)
+	require.Equal(t, []string{"tech"}, response.Items[0].Categories)
+
+	items, err := db.ListFeedItems(context.Background(), "123")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.NotEmpty(t, items[0].ID)
+
+	permalink := "https://mailfeed.xyz/item/123/" + items[0].ID
+	require.Equal(t, permalink, response.Items[0].GUID)
+	require.Equal(t, permalink, response.Items[0].Link)
 }
 
 func TestCreateFeed(t *testing.T) {
@@ -85,20 +110,11 @@ func TestCreateFeed(t *testing.T) {
 		t.Fatalf("Failed to create request: %v", err)
 	}
 
-	feeds := map[string]*feeds.Feed{}
-
 	logger := zap.NewNop()
 
-	feedChan := make(chan *newsletter.NewsLetter)
-
 	db, err := database.New(logger, ":memory:")
 	require.NoError(t, err)
-	s := &Server{
-		feeds:    feeds,
-		logger:   logger,
-		feedChan: feedChan,
-		db:       &db,
-	}
+	s := New(logger, &db, nil, Options{})
 
 	s.CreateFeed(w, r)
 
@@ -106,7 +122,443 @@ func TestCreateFeed(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
 	}
 
-	for _, v := range s.feeds {
-		require.Equal(t, v.Title, feedName)
+	feeds, err := db.ListFeeds(context.Background())
+	require.NoError(t, err)
+	require.Len(t, feeds, 1)
+	require.Equal(t, feedName, feeds[0].Name)
+}
+
+func TestCreateAlias(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{Domain: "mailfeed.xyz", AdminToken: "admin"})
+
+	createAlias := func(id, alias string) int {
+		w := httptest.NewRecorder()
+		reqBodyBytes, _ := json.Marshal(CreateAliasRequest{Alias: alias})
+		r, err := http.NewRequest("POST", "/", bytes.NewBuffer(reqBodyBytes))
+		require.NoError(t, err)
+		r.Header.Set("Authorization", "Bearer admin")
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+		s.CreateAlias(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusCreated, createAlias("abc123", "Weekly-Go"))
+	require.Equal(t, http.StatusConflict, createAlias("abc123", "weekly-go"))
+	require.Equal(t, http.StatusConflict, createAlias("abc123", "abc123"))
+	require.Equal(t, http.StatusBadRequest, createAlias("abc123", "weekly+go"))
+	require.Equal(t, http.StatusNotFound, createAlias("nope", "monthly-go"))
+
+	alias, err := db.GetFeedAlias(context.Background(), "weekly-go")
+	require.NoError(t, err)
+	require.Equal(t, "abc123", alias.FeedID)
+}
+
+func TestGetFeedFormats(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{Domain: "mailfeed.xyz"})
+
+	addItem(t, s, &db, &newsletter.NewsLetter{
+		Inbox:    "123",
+		Subject:  "Test Subject",
+		Body:     "<p>Test Body</p>",
+		Date:     time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		Category: "tech",
+	})
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		accept      string
+		contentType string
+		feedType    string
+		// code is set when the feed isn't served
+		code int
+	}{
+		{name: "rss", handler: s.GetFeed, contentType: "application/rss+xml", feedType: "rss"},
+		{name: "atom", handler: s.GetAtomFeed, contentType: "application/atom+xml", feedType: "atom"},
+		{name: "json", handler: s.GetJSONFeed, contentType: "application/feed+json", feedType: "json"},
+		{name: "accept atom", handler: s.GetFeed, accept: "application/atom+xml;q=0.9, */*", contentType: "application/atom+xml", feedType: "atom"},
+		{name: "accept json", handler: s.GetFeed, accept: "application/feed+json", contentType: "application/feed+json", feedType: "json"},
+		{name: "accept preferred", handler: s.GetFeed, accept: "application/rss+xml;q=0.1, application/atom+xml", contentType: "application/atom+xml", feedType: "atom"},
+		{name: "accept tie", handler: s.GetFeed, accept: "application/feed+json;q=0.5, application/atom+xml;q=0.5", contentType: "application/feed+json", feedType: "json"},
+		{name: "accept refused", handler: s.GetFeed, accept: "application/atom+xml;q=0, application/feed+json;q=0.2", contentType: "application/feed+json", feedType: "json"},
+		{name: "accept anything", handler: s.GetFeed, accept: "text/html, */*", contentType: "application/rss+xml", feedType: "rss"},
+		{name: "accept none", handler: s.GetFeed, accept: "application/rss+xml;q=0, application/atom+xml;q=0, */*;q=0", code: http.StatusNotAcceptable},
+		{name: "accept other", handler: s.GetFeed, accept: "text/html", code: http.StatusNotAcceptable},
+		{name: "accept rss refused", handler: s.GetFeed, accept: "application/rss+xml;q=0, */*", code: http.StatusNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "http://mailfeed.xyz/feed/123", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", "123")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+			tt.handler(w, r)
+			if tt.code != 0 {
+				require.Equal(t, tt.code, w.Code)
+				return
+			}
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+
+			response, err := gofeed.NewParser().Parse(w.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.feedType, string(response.FeedType))
+			require.Equal(t, "Test Feed", response.Title)
+			require.Equal(t, "Test Subject", response.Items[0].Title)
+			require.Equal(t, []string{"tech"}, response.Items[0].Categories)
+			require.Contains(t, response.Items[0].Link, "https://mailfeed.xyz/item/123/")
+
+			if tt.feedType != "rss" {
+				require.Equal(t, "http://mailfeed.xyz/feed/123", response.FeedLink)
+				require.Equal(t, "<p>Test Body</p>", response.Items[0].Content)
+				require.Equal(t, response.Items[0].Link, response.Items[0].GUID)
+			}
+
+			if tt.feedType == "atom" {
+				require.Equal(t, "2006-01-02T15:04:05Z", response.Updated)
+			}
+		})
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	for _, id := range []string{"abc123", "def456"} {
+		_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: id, Name: "Test Feed"})
+		require.NoError(t, err)
+	}
+
+	_, err = db.CreateFeedItem(context.Background(), sqlc.CreateFeedItemParams{
+		ID:      "item1",
+		FeedID:  "abc123",
+		Subject: "Why is Email to RSS Great",
+		Body:    "<p>It just is.</p>",
+		Date:    "2006-01-02 15:04:05",
+	})
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{})
+
+	getItem := func(feedID, itemID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("feed", feedID)
+		rctx.URLParams.Add("item", itemID)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+		s.GetItem(w, r)
+		return w
+	}
+
+	w := getItem("abc123", "item1")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "<title>Why is Email to RSS Great</title>")
+	require.Contains(t, w.Body.String(), "<p>It just is.</p>")
+	require.Contains(t, w.Body.String(), "2 January 2006")
+	require.NotEmpty(t, w.Header().Get("Content-Security-Policy"))
+
+	require.Equal(t, http.StatusNotFound, getItem("def456", "item1").Code)
+	require.Equal(t, http.StatusNotFound, getItem("abc123", "item2").Code)
+}
+
+func TestGetMedia(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	email, err := db.CreateEmail(context.Background(), sqlc.CreateEmailParams{Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	_, err = db.CreateFeedItem(context.Background(), sqlc.CreateFeedItemParams{
+		ID:      "item1",
+		FeedID:  "abc123",
+		Subject: "Issue 42",
+		Body:    "<p>Attached.</p>",
+		Date:    "2006-01-02 15:04:05",
+		EmailID: sql.NullInt64{Int64: email.ID, Valid: true},
+	})
+	require.NoError(t, err)
+
+	for _, attachment := range []sqlc.CreateAttachmentParams{
+		{ID: "logo", ContentID: "logo@newsletter.com", ContentType: "image/png", Inline: true, Data: []byte("png")},
+		{ID: "issue", Filename: "issue-42.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+	} {
+		attachment.EmailID = email.ID
+		attachment.Size = int64(len(attachment.Data))
+		require.NoError(t, db.CreateAttachment(context.Background(), attachment))
+	}
+
+	s := New(logger, &db, nil, Options{Domain: "mailfeed.xyz"})
+
+	getMedia := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+		s.GetMedia(w, r)
+		return w
 	}
+
+	w := getMedia("logo")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	require.Equal(t, "inline", w.Header().Get("Content-Disposition"))
+	require.Equal(t, "png", w.Body.String())
+
+	w = getMedia("issue")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename=issue-42.pdf`, w.Header().Get("Content-Disposition"))
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	require.Equal(t, http.StatusNotFound, getMedia("missing").Code)
+
+	// only attachments which aren't shown inline are enclosures
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "abc123")
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	s.GetFeed(w, r)
+
+	response, err := gofeed.NewParser().Parse(w.Body)
+	require.NoError(t, err)
+	require.Len(t, response.Items[0].Enclosures, 1)
+	require.Equal(t, "https://mailfeed.xyz/media/issue", response.Items[0].Enclosures[0].URL)
+	require.Equal(t, "application/pdf", response.Items[0].Enclosures[0].Type)
+	require.Equal(t, "8", response.Items[0].Enclosures[0].Length)
+}
+
+func TestGetFeedItemLimit(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{ItemLimit: 3, CacheSize: 1})
+	getFeed := func() *gofeed.Feed {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "123")
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+		s.GetFeed(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		response, err := gofeed.NewParser().Parse(w.Body)
+		require.NoError(t, err)
+		return response
+	}
+
+	for day := 1; day <= 5; day++ {
+		addItem(t, s, &db, &newsletter.NewsLetter{
+			Inbox:   "123",
+			Subject: fmt.Sprintf("Issue %d", day),
+			Date:    time.Date(2006, 1, day, 0, 0, 0, 0, time.UTC),
+		})
+
+		// the cached feed is replaced as soon as an item is added
+		require.Equal(t, fmt.Sprintf("Issue %d", day), getFeed().Items[0].Title)
+	}
+
+	var titles []string
+	for _, item := range getFeed().Items {
+		titles = append(titles, item.Title)
+	}
+
+	require.Equal(t, []string{"Issue 5", "Issue 4", "Issue 3"}, titles)
+}
+
+func TestGetFeedCacheExpires(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{CacheSize: 1, CacheTTL: time.Minute})
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	s.cache.now = func() time.Time { return now }
+
+	addItem(t, s, &db, &newsletter.NewsLetter{Inbox: "123", Subject: "Issue 1", Date: now})
+
+	loaded, err := s.loadFeed(context.Background(), "123")
+	require.NoError(t, err)
+	require.Len(t, loaded.Items, 1)
+
+	// items stored by another process, such as reprocessing, don't invalidate the cache
+	require.NoError(t, storeItem(&db, &newsletter.NewsLetter{Inbox: "123", Subject: "Issue 2", Date: now}))
+
+	loaded, err = s.loadFeed(context.Background(), "123")
+	require.NoError(t, err)
+	require.Len(t, loaded.Items, 1)
+
+	now = now.Add(time.Minute)
+	loaded, err = s.loadFeed(context.Background(), "123")
+	require.NoError(t, err)
+	require.Len(t, loaded.Items, 2)
+}
+
+func TestGetFeedConcurrently(t *testing.T) {
+	logger := zap.NewNop()
+
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	ids := []string{"abc123", "def456", "ghi789"}
+	for _, id := range ids {
+		_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: id, Name: id})
+		require.NoError(t, err)
+	}
+
+	queue := newsletter.NewQueue(10)
+	s := New(logger, &db, queue, Options{Domain: "mailfeed.xyz", CacheSize: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	r := chi.NewRouter()
+	r.Post("/rss", s.CreateFeed)
+	r.Get("/rss/{id}", s.GetFeed)
+	r.Get("/atom/{id}", s.GetAtomFeed)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			addItem(t, s, &db, &newsletter.NewsLetter{
+				Inbox:   ids[i%len(ids)],
+				Subject: fmt.Sprintf("Issue %d", i),
+				Date:    time.Now(),
+			})
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				path := fmt.Sprintf("/rss/%s", ids[(i+j)%len(ids)])
+				if j%2 == 0 {
+					path = fmt.Sprintf("/atom/%s", ids[(i+j)%len(ids)])
+				}
+
+				resp, err := http.Get(server.URL + path)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				resp.Body.Close()
+
+				if j%5 == 0 {
+					resp, err := http.Post(server.URL+"/rss", "application/json", strings.NewReader(`{"name": "New Feed"}`))
+					if !assert.NoError(t, err) {
+						return
+					}
+
+					resp.Body.Close()
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, id := range ids {
+		items, err := db.ListFeedItems(context.Background(), id)
+		require.NoError(t, err)
+		require.NotEmpty(t, items)
+	}
+}
+
+func TestGetFeedSurfacesConfirmations(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	_, err := db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	pending, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	confirmed, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{Date: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	for _, item := range []sqlc.CreateFeedItemParams{
+		{ID: "item1", Subject: "Please confirm", Body: "<p>Confirm below</p>", ConfirmUrl: "https://example.com/confirm?a=1&b=2", EmailID: sql.NullInt64{Int64: pending.ID, Valid: true}},
+		{ID: "item2", Subject: "Welcome", Body: "<p>Hello</p>", ConfirmUrl: "https://example.com/confirm?a=3", EmailID: sql.NullInt64{Int64: confirmed.ID, Valid: true}},
+	} {
+		item.FeedID = "abc123"
+		item.Date = "2006-01-02 15:04:05"
+		_, err := db.CreateFeedItem(ctx, item)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, db.ConfirmFeedItemsForEmail(ctx, sql.NullInt64{Int64: confirmed.ID, Valid: true}))
+
+	w := call(api, "GET", "/rss/abc123", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response, err := gofeed.NewParser().Parse(w.Body)
+	require.NoError(t, err)
+
+	titles := map[string]string{}
+	for _, item := range response.Items {
+		titles[item.Title] = item.Description
+	}
+
+	require.Equal(t, `<p><strong><a href="https://example.com/confirm?a=1&amp;b=2">Confirm your subscription</a></strong></p><p>Confirm below</p>`, titles["Confirm your subscription: Please confirm"])
+	require.Contains(t, titles["Subscription confirmed: Welcome"], "confirmed automatically")
 }
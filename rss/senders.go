@@ -0,0 +1,278 @@
+package rss
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// SenderRule allows or blocks mail to a feed from the senders its pattern matches.
+type SenderRule struct {
+	ID      int64  `json:"id"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+	// Auto is set on rules a locked feed made for its first senders.
+	Auto bool `json:"auto"`
+}
+
+// CreateSenderRuleRequest is a rule to add to a feed. Pattern is an address or
+// a domain, either of which may use "*" wildcards, and Action is "allow" or "block".
+type CreateSenderRuleRequest struct {
+	Pattern string
+	Action  string
+}
+
+// QuarantinedMessage is a message a feed held back for review.
+type QuarantinedMessage struct {
+	ID      int64     `json:"id"`
+	Sender  string    `json:"sender"`
+	Subject string    `json:"subject"`
+	Reason  string    `json:"reason"`
+	Date    time.Time `json:"date"`
+}
+
+// ReleaseRequest optionally allows the sender of a released message from then on.
+type ReleaseRequest struct {
+	Allow bool
+}
+
+// Lists a feed's sender rules.
+func (s *Server) ListSenderRules(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	rules, err := s.db.ListSenderRules(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error listing sender rules", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]SenderRule, 0, len(rules))
+	for _, rule := range rules {
+		infos = append(infos, senderRule(rule))
+	}
+
+	s.writeJSON(w, http.StatusOK, infos)
+}
+
+// Adds a sender rule to a feed, replacing any rule with the same pattern.
+func (s *Server) CreateSenderRule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	req := CreateSenderRuleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	pattern, err := mail.NormalizeSenderPattern(req.Pattern)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Action != mail.SenderAllow && req.Action != mail.SenderBlock {
+		http.Error(w, `Action must be "allow" or "block"`, http.StatusBadRequest)
+		return
+	}
+
+	rule, err := s.db.CreateSenderRule(r.Context(), sqlc.CreateSenderRuleParams{
+		FeedID:  feed.ID,
+		Pattern: pattern,
+		Action:  req.Action,
+	})
+	if err != nil {
+		s.logger.Error("Error creating sender rule", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, senderRule(rule))
+}
+
+// Deletes one of a feed's sender rules.
+func (s *Server) DeleteSenderRule(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "rule"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := s.db.DeleteSenderRule(r.Context(), sqlc.DeleteSenderRuleParams{ID: id, FeedID: feed.ID})
+	if err != nil {
+		s.logger.Error("Error deleting sender rule", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if deleted == 0 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists the messages a feed has quarantined.
+func (s *Server) ListQuarantine(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	rows, err := s.db.ListQuarantine(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error listing quarantine", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	messages := make([]QuarantinedMessage, 0, len(rows))
+	for _, row := range rows {
+		date, err := time.Parse("2006-01-02 15:04:05", row.Date)
+		if err != nil {
+			s.logger.Error("Error parsing date", zap.Error(err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		messages = append(messages, QuarantinedMessage{
+			ID:      row.ID,
+			Sender:  row.Sender,
+			Subject: row.Subject,
+			Reason:  row.Reason,
+			Date:    date,
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, messages)
+}
+
+// Adds a quarantined message to its feed. With {"allow": true}, its sender is
+// allowed too.
+func (s *Server) ReleaseQuarantine(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "message"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	// The body is optional.
+	req := ReleaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	err = s.releaseQuarantine(r.Context(), feed.ID, id, req.Allow)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Error releasing message", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deletes a quarantined message, and its email if no other feed has it.
+func (s *Server) DiscardQuarantine(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "message"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	err = s.db.InTx(r.Context(), func(q *sqlc.Queries) error {
+		quarantined, err := q.GetQuarantine(r.Context(), sqlc.GetQuarantineParams{ID: id, FeedID: feed.ID})
+		if err != nil {
+			return err
+		}
+
+		if err := q.DeleteQuarantine(r.Context(), quarantined.ID); err != nil {
+			return fmt.Errorf("failed to delete quarantined message: %w", err)
+		}
+
+		if err := q.DeleteUnusedEmail(r.Context(), quarantined.EmailID); err != nil {
+			return fmt.Errorf("failed to delete email: %w", err)
+		}
+
+		return nil
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Error discarding message", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releaseQuarantine moves a quarantined message into its feed, and allows its
+// sender if allow is set.
+func (s *Server) releaseQuarantine(ctx context.Context, feedID string, id int64, allow bool) error {
+	added, err := mail.ReleaseQuarantine(ctx, s.db, feedID, id, allow)
+	if err != nil {
+		return err
+	}
+
+	if added != "" {
+		s.cache.invalidate(added)
+	}
+
+	return nil
+}
+
+func senderRule(rule sqlc.SenderRule) SenderRule {
+	return SenderRule{
+		ID:      rule.ID,
+		Pattern: rule.Pattern,
+		Action:  rule.Action,
+		Auto:    rule.Auto,
+	}
+}
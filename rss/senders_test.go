@@ -0,0 +1,127 @@
+package rss
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderRules(t *testing.T) {
+	db, api := newAPI(t)
+
+	_, err := db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Feed"})
+	require.NoError(t, err)
+
+	w := call(api, "PATCH", "/api/feeds/abc123", "admin", `{"locked": true, "senderLimit": 3, "requireDkim": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	info := FeedInfo{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.True(t, info.Locked)
+	require.EqualValues(t, 3, info.SenderLimit)
+	require.True(t, info.RequireDKIM)
+
+	w = call(api, "PATCH", "/api/feeds/abc123", "admin", `{"senderLimit": -1}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = call(api, "POST", "/api/feeds/abc123/senders", "admin", `{"pattern": "News <news@example.com>", "action": "allow"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = call(api, "POST", "/api/feeds/abc123/senders", "admin", `{"pattern": "example.com", "action": "ignore"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = call(api, "POST", "/api/feeds/abc123/senders", "", `{"pattern": "example.com", "action": "block"}`)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = call(api, "POST", "/api/feeds/abc123/senders", "admin", `{"pattern": "Example.com", "action": "block"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	rule := SenderRule{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rule))
+	require.Equal(t, "*@example.com", rule.Pattern)
+	require.Equal(t, "block", rule.Action)
+
+	w = call(api, "GET", "/api/feeds/abc123/senders", "admin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	rules := []SenderRule{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rules))
+	require.Equal(t, []SenderRule{rule}, rules)
+
+	path := fmt.Sprintf("/api/feeds/abc123/senders/%d", rule.ID)
+	require.Equal(t, http.StatusNoContent, call(api, "DELETE", path, "admin", "").Code)
+	require.Equal(t, http.StatusNotFound, call(api, "DELETE", path, "admin", "").Code)
+}
+
+func TestQuarantine(t *testing.T) {
+	db, api := newAPI(t)
+	ctx := context.Background()
+
+	_, err := db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Feed"})
+	require.NoError(t, err)
+
+	var quarantined []sqlc.Quarantine
+	for _, sender := range []string{"first@example.com", "second@example.com"} {
+		email, err := db.CreateEmail(ctx, sqlc.CreateEmailParams{
+			Date:        "2006-01-02 15:04:05",
+			Sender:      sender,
+			Subject:     "From " + sender,
+			Description: "<p>Hello</p>",
+		})
+		require.NoError(t, err)
+
+		q, err := db.CreateQuarantine(ctx, sqlc.CreateQuarantineParams{
+			FeedID:  "abc123",
+			EmailID: email.ID,
+			Sender:  sender,
+			Reason:  "feed is locked to its first senders",
+		})
+		require.NoError(t, err)
+		quarantined = append(quarantined, q)
+	}
+
+	w := call(api, "GET", "/api/feeds/abc123/quarantine", "admin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	messages := []QuarantinedMessage{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&messages))
+	require.Len(t, messages, 2)
+	require.Equal(t, "From first@example.com", messages[0].Subject)
+	require.Equal(t, "feed is locked to its first senders", messages[0].Reason)
+
+	release := fmt.Sprintf("/api/feeds/abc123/quarantine/%d/release", quarantined[0].ID)
+	require.Equal(t, http.StatusNoContent, call(api, "POST", release, "admin", `{"allow": true}`).Code)
+	require.Equal(t, http.StatusNotFound, call(api, "POST", release, "admin", "").Code)
+
+	items, err := db.ListFeedItems(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "From first@example.com", items[0].Subject)
+	require.Equal(t, "<p>Hello</p>", items[0].Body)
+	// released items get IDs like every other item's
+	require.Regexp(t, "^[0-9a-f]{12}$", items[0].ID)
+	require.Equal(t, sql.NullInt64{Int64: quarantined[0].EmailID, Valid: true}, items[0].EmailID)
+
+	rules, err := db.ListSenderRules(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.Equal(t, "first@example.com", rules[0].Pattern)
+	require.False(t, rules[0].Auto)
+
+	discard := fmt.Sprintf("/api/feeds/abc123/quarantine/%d", quarantined[1].ID)
+	require.Equal(t, http.StatusNoContent, call(api, "DELETE", discard, "admin", "").Code)
+	require.Equal(t, http.StatusNotFound, call(api, "DELETE", discard, "admin", "").Code)
+
+	_, err = db.GetEmail(ctx, quarantined[1].EmailID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	w = call(api, "GET", "/api/feeds/abc123/quarantine", "admin", "")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&messages))
+	require.Empty(t, messages)
+}
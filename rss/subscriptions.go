@@ -0,0 +1,200 @@
+package rss
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alex-emery/mailfeed/account"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Subscription is a sender mailing a feed, and how it can be unsubscribed from,
+// from the List-Unsubscribe headers of the last message it sent.
+type Subscription struct {
+	ID       int64  `json:"id"`
+	Sender   string `json:"sender"`
+	URL      string `json:"url,omitempty"`
+	Mailto   string `json:"mailto,omitempty"`
+	OneClick bool   `json:"oneClick"`
+	// Automatic is set when the sender can be unsubscribed from without opening URL.
+	Automatic    bool       `json:"automatic"`
+	LastReceived time.Time  `json:"lastReceived"`
+	Unsubscribed *time.Time `json:"unsubscribed,omitempty"`
+}
+
+// Lists the senders a feed can unsubscribe from.
+func (s *Server) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	subscriptions, err := s.listSubscriptions(r.Context(), feed.ID)
+	if err != nil {
+		s.logger.Error("Error listing subscriptions", zap.Error(err))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, subscriptions)
+}
+
+// Unsubscribes a feed from one of its senders, with a one-click POST or by mail.
+func (s *Server) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.authorizedFeed(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "subscription"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	subscription, ok := s.unsubscribeHandler(w, r, feed, id)
+	if !ok {
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, subscription)
+}
+
+// Unsubscribes one of the signed in account's feeds from a sender, from the dashboard.
+func (s *Server) DashboardUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if _, ok := account.FromContext(r.Context()); !ok {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	feed, ok := s.authorizedFeed(w, r, r.PostForm.Get("feed"))
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PostForm.Get("subscription"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if _, ok := s.unsubscribeHandler(w, r, feed, id); !ok {
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// unsubscribeHandler unsubscribes feed from the subscription with id. Otherwise it
+// writes an error and returns false.
+func (s *Server) unsubscribeHandler(w http.ResponseWriter, r *http.Request, feed sqlc.Feed, id int64) (Subscription, bool) {
+	subscription, err := s.unsubscribe(r.Context(), feed, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, mail.ErrNoUnsubscribeMethod):
+		http.Error(w, "Sender can only be unsubscribed from by opening its unsubscribe link", http.StatusUnprocessableEntity)
+	case err != nil:
+		s.logger.Error("Error unsubscribing", zap.Int64("subscription", id), zap.Error(err))
+		http.Error(w, "Failed to unsubscribe", http.StatusBadGateway)
+	default:
+		return subscription, true
+	}
+
+	return Subscription{}, false
+}
+
+// unsubscribe unsubscribes feed from the subscription with id, and records when it did.
+// Mailto links are mailed from the feed's current address.
+func (s *Server) unsubscribe(ctx context.Context, feed sqlc.Feed, id int64) (Subscription, error) {
+	stored, err := s.db.GetSubscription(ctx, sqlc.GetSubscriptionParams{ID: id, FeedID: feed.ID})
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	from := fmt.Sprintf("%s@%s", feedAddress(feed), s.domain)
+	if err := s.unsubscriber.Unsubscribe(ctx, from, listUnsubscribe(stored)); err != nil {
+		return Subscription{}, err
+	}
+
+	stored.Unsubscribed = time.Now().UTC().Format("2006-01-02 15:04:05")
+	err = s.db.SetSubscriptionUnsubscribed(ctx, sqlc.SetSubscriptionUnsubscribedParams{
+		Unsubscribed: stored.Unsubscribed,
+		ID:           stored.ID,
+	})
+	if err != nil {
+		return Subscription{}, fmt.Errorf("failed to record unsubscription: %w", err)
+	}
+
+	s.logger.Info("unsubscribed", zap.String("feed", feed.ID), zap.String("sender", stored.Sender))
+	return s.subscription(stored)
+}
+
+// listSubscriptions returns the senders feedID can unsubscribe from.
+func (s *Server) listSubscriptions(ctx context.Context, feedID string) ([]Subscription, error) {
+	stored, err := s.db.ListSubscriptions(ctx, feedID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make([]Subscription, 0, len(stored))
+	for _, row := range stored {
+		subscription, err := s.subscription(row)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Server) subscription(stored sqlc.Subscription) (Subscription, error) {
+	lastReceived, err := time.Parse("2006-01-02 15:04:05", stored.LastReceived)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("failed to parse date: %w", err)
+	}
+
+	subscription := Subscription{
+		ID:           stored.ID,
+		Sender:       stored.Sender,
+		URL:          stored.UnsubscribeUrl,
+		Mailto:       stored.UnsubscribeMailto,
+		OneClick:     stored.OneClick,
+		Automatic:    s.unsubscriber.CanUnsubscribe(listUnsubscribe(stored)),
+		LastReceived: lastReceived,
+	}
+
+	if stored.Unsubscribed != "" {
+		unsubscribed, err := time.Parse("2006-01-02 15:04:05", stored.Unsubscribed)
+		if err != nil {
+			return Subscription{}, fmt.Errorf("failed to parse date: %w", err)
+		}
+
+		subscription.Unsubscribed = &unsubscribed
+	}
+
+	return subscription, nil
+}
+
+func listUnsubscribe(stored sqlc.Subscription) mail.ListUnsubscribe {
+	return mail.ListUnsubscribe{
+		URL:      stored.UnsubscribeUrl,
+		Mailto:   stored.UnsubscribeMailto,
+		OneClick: stored.OneClick,
+	}
+}
@@ -0,0 +1,196 @@
+package rss
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alex-emery/mailfeed/account"
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/emersion/go-smtp"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSubscriptions(t *testing.T) {
+	var posted []string
+	sender := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.URL.Path)
+	}))
+	defer sender.Close()
+
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{
+		Domain:       "mailfeed.xyz",
+		AdminToken:   "admin",
+		Unsubscriber: mail.NewUnsubscriber(sender.Client(), mail.Relay{}),
+	})
+
+	api := chi.NewRouter()
+	api.Get("/api/feeds/{id}/subscriptions", s.ListSubscriptions)
+	api.Post("/api/feeds/{id}/subscriptions/{subscription}/unsubscribe", s.Unsubscribe)
+	api.Post("/dashboard/unsubscribe", s.DashboardUnsubscribe)
+
+	ctx := context.Background()
+	owner, err := db.CreateAccount(ctx, sqlc.CreateAccountParams{Email: "reader@example.com", PasswordHash: "hash", Created: "2006-01-02 15:04:05"})
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Feed", AccountID: sql.NullInt64{Int64: owner.ID, Valid: true}})
+	require.NoError(t, err)
+
+	for _, subscription := range []sqlc.UpsertSubscriptionParams{
+		{Sender: "news@example.com", UnsubscribeUrl: sender.URL + "/news", OneClick: true},
+		{Sender: "promo@example.com", UnsubscribeUrl: sender.URL + "/promo"},
+		{Sender: "weekly@example.com", UnsubscribeUrl: sender.URL + "/weekly", OneClick: true},
+	} {
+		subscription.FeedID = "abc123"
+		subscription.LastReceived = "2006-01-02 15:04:05"
+		require.NoError(t, db.UpsertSubscription(ctx, subscription))
+	}
+
+	w := call(api, "GET", "/api/feeds/abc123/subscriptions", "admin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var subscriptions []Subscription
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&subscriptions))
+	require.Len(t, subscriptions, 3)
+	require.Equal(t, "news@example.com", subscriptions[0].Sender)
+	require.True(t, subscriptions[0].Automatic)
+	require.False(t, subscriptions[1].Automatic)
+	require.Nil(t, subscriptions[0].Unsubscribed)
+
+	path := fmt.Sprintf("/api/feeds/abc123/subscriptions/%d/unsubscribe", subscriptions[0].ID)
+	require.Equal(t, http.StatusUnauthorized, call(api, "POST", path, "", "").Code)
+
+	w = call(api, "POST", path, "admin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var unsubscribed Subscription
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&unsubscribed))
+	require.NotNil(t, unsubscribed.Unsubscribed)
+	require.Equal(t, []string{"/news"}, posted)
+
+	// senders without one-click unsubscription have to be unsubscribed from by hand
+	path = fmt.Sprintf("/api/feeds/abc123/subscriptions/%d/unsubscribe", subscriptions[1].ID)
+	require.Equal(t, http.StatusUnprocessableEntity, call(api, "POST", path, "admin", "").Code)
+	require.Equal(t, http.StatusNotFound, call(api, "POST", "/api/feeds/abc123/subscriptions/99/unsubscribe", "admin", "").Code)
+
+	// the dashboard unsubscribes the signed in account's feeds
+	form := url.Values{"feed": {"abc123"}, "subscription": {fmt.Sprint(subscriptions[2].ID)}}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/dashboard/unsubscribe", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	api.ServeHTTP(w, r.WithContext(account.NewContext(r.Context(), owner)))
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	require.Equal(t, "/dashboard", w.Header().Get("Location"))
+	require.Equal(t, []string{"/news", "/weekly"}, posted)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/dashboard", nil)
+	s.Dashboard(w, r.WithContext(account.NewContext(r.Context(), owner)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	require.Contains(t, body, "news@example.com")
+	require.Contains(t, body, "unsubscribed on")
+	require.Contains(t, body, `<a href="`+sender.URL+`/promo" rel="noreferrer" target="_blank">Unsubscribe</a>`)
+}
+
+// relay is a fake SMTP relay which records the messages sent through it.
+type relay struct {
+	messages chan relayed
+}
+
+type relayed struct {
+	from string
+	data string
+}
+
+func (r *relay) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &relaySession{relay: r}, nil
+}
+
+type relaySession struct {
+	relay *relay
+	from  string
+}
+
+func (s *relaySession) Reset()                                         {}
+func (s *relaySession) Logout() error                                  { return nil }
+func (s *relaySession) AuthPlain(username, password string) error      { return smtp.ErrAuthUnsupported }
+func (s *relaySession) Rcpt(to string, opts *smtp.RcptOptions) error   { return nil }
+func (s *relaySession) Mail(from string, opts *smtp.MailOptions) error { s.from = from; return nil }
+
+func (s *relaySession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.relay.messages <- relayed{from: s.from, data: string(data)}
+	return nil
+}
+
+func TestUnsubscribeByMail(t *testing.T) {
+	backend := &relay{messages: make(chan relayed, 10)}
+	server := smtp.NewServer(backend)
+	server.Domain = "relay.example.com"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	defer server.Close()
+
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	s := New(logger, &db, nil, Options{
+		Domain:       "mailfeed.xyz",
+		AdminToken:   "admin",
+		Unsubscriber: mail.NewUnsubscriber(nil, mail.Relay{Addr: ln.Addr().String()}),
+	})
+
+	api := chi.NewRouter()
+	api.Post("/api/feeds/{id}/subscriptions/{subscription}/unsubscribe", s.Unsubscribe)
+
+	// the feed's address has never been rotated, so it still receives mail at its ID
+	ctx := context.Background()
+	_, err = db.CreateFeed(ctx, sqlc.CreateFeedParams{ID: "abc123", Name: "Feed"})
+	require.NoError(t, err)
+
+	require.NoError(t, db.UpsertSubscription(ctx, sqlc.UpsertSubscriptionParams{
+		FeedID:            "abc123",
+		Sender:            "news@example.com",
+		UnsubscribeMailto: "mailto:leave@example.com",
+		LastReceived:      "2006-01-02 15:04:05",
+	}))
+
+	subscriptions, err := db.ListSubscriptions(ctx, "abc123")
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+
+	path := fmt.Sprintf("/api/feeds/abc123/subscriptions/%d/unsubscribe", subscriptions[0].ID)
+	require.Equal(t, http.StatusOK, call(api, "POST", path, "admin", "").Code)
+
+	msg := <-backend.messages
+	require.Equal(t, "abc123@mailfeed.xyz", msg.from)
+	require.Contains(t, msg.data, "From: <abc123@mailfeed.xyz>\r\n")
+}
@@ -0,0 +1,175 @@
+package smtpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+)
+
+var (
+	errUnknownRecipient = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "No such feed",
+	}
+	errNoRecipients = &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 5, 1},
+		Message:      "No valid recipients",
+	}
+	errMalformedMessage = &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+		Message:      "Malformed message",
+	}
+	errTemporary = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      "Temporary failure, please try again later",
+	}
+)
+
+type Options struct {
+	// Addr is the TCP address to listen on.
+	Addr string
+	// Domain is the server's hostname, announced in its greeting.
+	Domain string
+	// LMTP makes the server speak LMTP instead of SMTP.
+	LMTP bool
+	// MaxMessageBytes limits the size of accepted messages.
+	MaxMessageBytes int64
+}
+
+// Backend accepts mail addressed to feeds and hands it to a mail.Deliverer.
+type Backend struct {
+	logger    *zap.Logger
+	resolver  *mail.Resolver
+	deliverer *mail.Deliverer
+	lmtp      bool
+}
+
+// New creates an SMTP (or LMTP) server which accepts mail for the feeds known to resolver.
+func New(logger *zap.Logger, resolver *mail.Resolver, deliverer *mail.Deliverer, options Options) *smtp.Server {
+	backend := &Backend{
+		logger:    logger,
+		resolver:  resolver,
+		deliverer: deliverer,
+		lmtp:      options.LMTP,
+	}
+
+	s := smtp.NewServer(backend)
+	s.Addr = options.Addr
+	s.Domain = options.Domain
+	s.LMTP = options.LMTP
+	s.MaxMessageBytes = options.MaxMessageBytes
+	s.ReadTimeout = 1 * time.Minute
+	s.WriteTimeout = 1 * time.Minute
+	s.AuthDisabled = true
+	s.ErrorLog = zap.NewStdLog(logger)
+
+	return s
+}
+
+func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	s := &session{backend: b, remote: c.Hostname()}
+
+	// LMTP clients are the local MTA rather than the sender's server, so SPF is taken
+	// from the Authentication-Results header it added instead of checked against it.
+	if addr, ok := c.Conn().RemoteAddr().(*net.TCPAddr); ok && !b.lmtp {
+		s.ip = addr.IP
+	}
+
+	return s, nil
+}
+
+type session struct {
+	backend *Backend
+	// remote is the name the client greeted with, and ip its address, which is nil
+	// over LMTP.
+	remote     string
+	ip         net.IP
+	from       string
+	recipients []mail.Recipient
+}
+
+func (s *session) Reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
+
+func (s *session) AuthPlain(username, password string) error {
+	return smtp.ErrAuthUnsupported
+}
+
+func (s *session) Mail(from string, opts *smtp.MailOptions) error {
+	s.from = from
+	return nil
+}
+
+// Rcpt rejects any recipient which isn't an existing feed, so senders learn
+// about a bad address straight away rather than through a bounce.
+func (s *session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	recipient, err := s.backend.resolver.Resolve(context.Background(), to)
+	if errors.Is(err, mail.ErrNoFeed) {
+		return errUnknownRecipient
+	}
+
+	if err != nil {
+		s.backend.logger.Error("failed to resolve recipient", zap.String("recipient", to), zap.Error(err))
+		return errTemporary
+	}
+
+	for _, r := range s.recipients {
+		if r == recipient {
+			return nil
+		}
+	}
+
+	s.recipients = append(s.recipients, recipient)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	if len(s.recipients) == 0 {
+		return errNoRecipients
+	}
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	}
+
+	logger := s.backend.logger.With(zap.String("remote", s.remote), zap.String("from", s.from))
+	logger.Info("message received", zap.Int("recipients", len(s.recipients)))
+
+	envelope := mail.Envelope{
+		Recipients: s.recipients,
+		RemoteIP:   s.ip,
+		Helo:       s.remote,
+		MailFrom:   s.from,
+	}
+
+	err = s.backend.deliverer.Deliver(context.Background(), envelope, buf)
+	if errors.Is(err, mail.ErrMalformedMessage) {
+		logger.Warn("rejected malformed message", zap.Error(err))
+		return errMalformedMessage
+	}
+
+	if err != nil {
+		logger.Error("failed to deliver message", zap.Error(err))
+		return errTemporary
+	}
+
+	return nil
+}
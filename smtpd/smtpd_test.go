@@ -0,0 +1,148 @@
+package smtpd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-emery/mailfeed/database"
+	"github.com/alex-emery/mailfeed/database/sqlc"
+	"github.com/alex-emery/mailfeed/mail"
+	"github.com/alex-emery/mailfeed/newsletter"
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testMessage = "Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+	"From: The Newsletter <the@newsletter.com>\r\n" +
+	"To: abc123@mailfeed.xyz\r\n" +
+	"Subject: Why is Email to RSS Great\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>It just is.</p>\r\n"
+
+// fakeResolver answers TXT lookups from a map, so tests never touch the network.
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txts, ok := f[strings.TrimSuffix(name, ".")]; ok {
+		return txts, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func startTestServer(t *testing.T, lmtp bool) (string, <-chan *newsletter.NewsLetter, *database.Database) {
+	logger := zap.NewNop()
+	db, err := database.New(logger, ":memory:")
+	require.NoError(t, err)
+
+	_, err = db.CreateFeed(context.Background(), sqlc.CreateFeedParams{ID: "abc123", Name: "Test Feed"})
+	require.NoError(t, err)
+
+	letters := newsletter.NewQueue(10)
+	deliverer := mail.NewDeliverer(logger, &db, letters)
+	deliverer.SetDNSResolver(fakeResolver{
+		"_dmarc.newsletter.com": {"v=DMARC1; p=reject"},
+		"newsletter.com":        {"v=spf1 ip4:192.0.2.1 -all"},
+	})
+
+	server := New(logger, mail.NewResolver(&db, "mailfeed.xyz"), deliverer, Options{
+		Domain: "mailfeed.xyz",
+		LMTP:   lmtp,
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = server.Serve(ln)
+	}()
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	return ln.Addr().String(), letters.Receive(), &db
+}
+
+func TestReceiveSMTP(t *testing.T) {
+	addr, letters, db := startTestServer(t, false)
+
+	c, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Mail("the@newsletter.com", nil))
+	require.Error(t, c.Rcpt("unknown@mailfeed.xyz", nil))
+	require.Error(t, c.Rcpt("abc123@example.com", nil))
+	require.NoError(t, c.Rcpt("abc123@MAILFEED.xyz", nil))
+
+	w, err := c.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte(testMessage))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, c.Quit())
+
+	select {
+	case letter := <-letters:
+		require.Equal(t, "abc123", letter.Inbox)
+		require.Equal(t, "Why is Email to RSS Great", letter.Subject)
+		require.Equal(t, "<p>It just is.</p>\n", letter.Body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for newsletter")
+	}
+
+	// SPF is checked against the connecting server, which newsletter.com doesn't send from
+	emails, err := db.ListEmails(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+	require.Equal(t, mail.AuthFail, emails[0].Spf)
+	require.Equal(t, mail.AuthFail, emails[0].Dmarc)
+}
+
+func TestReceiveLMTP(t *testing.T) {
+	addr, letters, db := startTestServer(t, true)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	c := smtp.NewClientLMTP(conn)
+	defer c.Close()
+
+	require.NoError(t, c.Hello("localhost"))
+
+	// the MTA in front checked SPF against the sender's server
+	results := "Authentication-Results: mailfeed.xyz; spf=pass smtp.mailfrom=the@newsletter.com\r\n"
+	err = c.SendMail("the@newsletter.com", []string{"abc123@mailfeed.xyz"}, strings.NewReader(results+testMessage))
+	require.NoError(t, err)
+
+	select {
+	case letter := <-letters:
+		require.Equal(t, "abc123", letter.Inbox)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for newsletter")
+	}
+
+	emails, err := db.ListEmails(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+	require.Equal(t, mail.AuthPass, emails[0].Spf)
+	require.Equal(t, mail.AuthPass, emails[0].Dmarc)
+}
@@ -0,0 +1,5 @@
+create table mailbox (
+    name text primary key,
+    uid_validity integer not null,
+    last_uid integer not null
+);
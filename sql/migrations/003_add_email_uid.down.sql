@@ -0,0 +1 @@
+ALTER TABLE email DROP COLUMN uid;
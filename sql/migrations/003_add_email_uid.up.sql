@@ -0,0 +1 @@
+ALTER TABLE email ADD COLUMN uid integer;
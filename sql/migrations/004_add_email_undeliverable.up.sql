@@ -0,0 +1 @@
+ALTER TABLE email ADD COLUMN undeliverable boolean NOT NULL DEFAULT false;
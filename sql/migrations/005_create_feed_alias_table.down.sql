@@ -0,0 +1,3 @@
+DROP TABLE feed_alias;
+
+ALTER TABLE feed_item DROP COLUMN category;
@@ -0,0 +1,6 @@
+create table feed_alias (
+    alias text primary key,
+    feed_id text not null references feed(id) ON DELETE CASCADE
+);
+
+ALTER TABLE feed_item ADD COLUMN category text NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE email DROP COLUMN raw;
+
+ALTER TABLE feed_item DROP COLUMN email_id;
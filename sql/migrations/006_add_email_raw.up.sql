@@ -0,0 +1,3 @@
+ALTER TABLE email ADD COLUMN raw blob;
+
+ALTER TABLE feed_item ADD COLUMN email_id integer REFERENCES email(id) ON DELETE SET NULL;
@@ -0,0 +1,7 @@
+DROP INDEX feed_item_message_id;
+
+ALTER TABLE feed_item DROP COLUMN message_id;
+
+DROP INDEX email_message_id;
+
+ALTER TABLE email DROP COLUMN message_id;
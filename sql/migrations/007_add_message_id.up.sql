@@ -0,0 +1,7 @@
+ALTER TABLE email ADD COLUMN message_id text NOT NULL DEFAULT '';
+
+CREATE INDEX email_message_id ON email(message_id);
+
+ALTER TABLE feed_item ADD COLUMN message_id text NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX feed_item_message_id ON feed_item(feed_id, message_id) WHERE message_id != '';
@@ -0,0 +1 @@
+-- Item IDs are published in feeds, so they are kept.
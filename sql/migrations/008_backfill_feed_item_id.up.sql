@@ -0,0 +1 @@
+UPDATE feed_item SET id = lower(hex(randomblob(6))) WHERE id IS NULL OR id = '';
@@ -0,0 +1,3 @@
+DROP INDEX attachment_email_id;
+
+DROP TABLE attachment;
@@ -0,0 +1,12 @@
+create table attachment (
+    id text primary key,
+    email_id integer not null references email(id) ON DELETE CASCADE,
+    content_id text not null DEFAULT '',
+    filename text not null DEFAULT '',
+    content_type text not null,
+    inline boolean not null DEFAULT FALSE,
+    size integer not null,
+    data blob not null
+);
+
+CREATE INDEX attachment_email_id ON attachment(email_id);
@@ -0,0 +1,3 @@
+ALTER TABLE feed_alias DROP COLUMN disabled;
+
+ALTER TABLE feed DROP COLUMN address;
@@ -0,0 +1,3 @@
+ALTER TABLE feed ADD COLUMN address text NOT NULL DEFAULT '';
+
+ALTER TABLE feed_alias ADD COLUMN disabled boolean NOT NULL DEFAULT FALSE;
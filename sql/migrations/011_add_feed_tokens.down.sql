@@ -0,0 +1,3 @@
+ALTER TABLE feed DROP COLUMN read_token_hash;
+ALTER TABLE feed DROP COLUMN private;
+ALTER TABLE feed DROP COLUMN token_hash;
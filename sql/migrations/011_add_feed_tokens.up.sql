@@ -0,0 +1,3 @@
+ALTER TABLE feed ADD COLUMN token_hash text NOT NULL DEFAULT '';
+ALTER TABLE feed ADD COLUMN private boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE feed ADD COLUMN read_token_hash text NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+DROP INDEX feed_account_id;
+ALTER TABLE feed DROP COLUMN account_id;
+DROP TABLE session;
+DROP TABLE account;
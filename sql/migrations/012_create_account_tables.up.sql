@@ -0,0 +1,18 @@
+create table account (
+    id integer primary key,
+    email text not null unique,
+    password_hash text not null,
+    created text not null
+);
+
+create table session (
+    id text primary key,
+    account_id integer not null references account(id) ON DELETE CASCADE,
+    expires text not null
+);
+
+CREATE INDEX session_account_id ON session(account_id);
+
+ALTER TABLE feed ADD COLUMN account_id integer references account(id) ON DELETE SET NULL;
+
+CREATE INDEX feed_account_id ON feed(account_id);
@@ -0,0 +1,4 @@
+DROP TABLE quarantine;
+DROP TABLE sender_rule;
+ALTER TABLE feed DROP COLUMN sender_limit;
+ALTER TABLE feed DROP COLUMN locked;
@@ -0,0 +1,24 @@
+ALTER TABLE feed ADD COLUMN locked boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE feed ADD COLUMN sender_limit integer NOT NULL DEFAULT 0;
+
+create table sender_rule (
+    id integer primary key,
+    feed_id text not null references feed(id) ON DELETE CASCADE,
+    pattern text not null,
+    action text not null,
+    auto boolean not null DEFAULT FALSE,
+    unique (feed_id, pattern)
+);
+
+create table quarantine (
+    id integer primary key,
+    feed_id text not null references feed(id) ON DELETE CASCADE,
+    email_id integer not null references email(id) ON DELETE CASCADE,
+    sender text not null,
+    category text not null DEFAULT '',
+    reason text not null,
+    message_id text not null DEFAULT ''
+);
+
+CREATE INDEX quarantine_feed_id ON quarantine(feed_id);
+CREATE UNIQUE INDEX quarantine_message_id ON quarantine(feed_id, message_id) WHERE message_id != '';
@@ -0,0 +1,5 @@
+ALTER TABLE feed DROP COLUMN require_dkim;
+ALTER TABLE email DROP COLUMN dmarc;
+ALTER TABLE email DROP COLUMN spf;
+ALTER TABLE email DROP COLUMN dkim_aligned;
+ALTER TABLE email DROP COLUMN dkim;
@@ -0,0 +1,5 @@
+ALTER TABLE email ADD COLUMN dkim text NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN dkim_aligned boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE email ADD COLUMN spf text NOT NULL DEFAULT '';
+ALTER TABLE email ADD COLUMN dmarc text NOT NULL DEFAULT '';
+ALTER TABLE feed ADD COLUMN require_dkim boolean NOT NULL DEFAULT FALSE;
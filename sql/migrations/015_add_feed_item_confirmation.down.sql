@@ -0,0 +1,2 @@
+ALTER TABLE feed_item DROP COLUMN confirmed;
+ALTER TABLE feed_item DROP COLUMN confirm_url;
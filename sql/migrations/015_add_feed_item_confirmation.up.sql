@@ -0,0 +1,2 @@
+ALTER TABLE feed_item ADD COLUMN confirm_url text NOT NULL DEFAULT '';
+ALTER TABLE feed_item ADD COLUMN confirmed boolean NOT NULL DEFAULT FALSE;
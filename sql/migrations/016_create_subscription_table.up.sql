@@ -0,0 +1,11 @@
+create table subscription (
+    id integer primary key,
+    feed_id text not null references feed(id) ON DELETE CASCADE,
+    sender text not null,
+    unsubscribe_url text not null DEFAULT '',
+    unsubscribe_mailto text not null DEFAULT '',
+    one_click boolean not null DEFAULT FALSE,
+    last_received text not null,
+    unsubscribed text not null DEFAULT '',
+    unique (feed_id, sender)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE feed_item DROP COLUMN renamed;
+DROP TABLE filter_rule;
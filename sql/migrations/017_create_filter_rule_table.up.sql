@@ -0,0 +1,14 @@
+create table filter_rule (
+    id integer primary key,
+    feed_id text not null references feed(id) ON DELETE CASCADE,
+    field text not null,
+    header text not null DEFAULT '',
+    pattern text not null,
+    action text not null,
+    value text not null DEFAULT '',
+    target_feed_id text references feed(id) ON DELETE CASCADE
+);
+
+CREATE INDEX filter_rule_feed_id ON filter_rule(feed_id);
+
+ALTER TABLE feed_item ADD COLUMN renamed boolean NOT NULL DEFAULT FALSE;
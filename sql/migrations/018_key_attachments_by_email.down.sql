@@ -0,0 +1,20 @@
+create table attachment_by_id (
+    id text primary key,
+    email_id integer not null references email(id) ON DELETE CASCADE,
+    content_id text not null DEFAULT '',
+    filename text not null DEFAULT '',
+    content_type text not null,
+    inline boolean not null DEFAULT FALSE,
+    size integer not null,
+    data blob not null
+);
+
+INSERT INTO attachment_by_id(rowid, id, email_id, content_id, filename, content_type, inline, size, data)
+SELECT rowid, id, email_id, content_id, filename, content_type, inline, size, data FROM attachment
+WHERE rowid IN (SELECT min(rowid) FROM attachment GROUP BY id);
+
+DROP INDEX attachment_id;
+DROP TABLE attachment;
+ALTER TABLE attachment_by_id RENAME TO attachment;
+
+CREATE INDEX attachment_email_id ON attachment(email_id);
@@ -0,0 +1,20 @@
+create table attachment_by_email (
+    id text not null,
+    email_id integer not null references email(id) ON DELETE CASCADE,
+    content_id text not null DEFAULT '',
+    filename text not null DEFAULT '',
+    content_type text not null,
+    inline boolean not null DEFAULT FALSE,
+    size integer not null,
+    data blob not null,
+    primary key (email_id, id)
+);
+
+INSERT INTO attachment_by_email(rowid, id, email_id, content_id, filename, content_type, inline, size, data)
+SELECT rowid, id, email_id, content_id, filename, content_type, inline, size, data FROM attachment;
+
+DROP INDEX attachment_email_id;
+DROP TABLE attachment;
+ALTER TABLE attachment_by_email RENAME TO attachment;
+
+CREATE INDEX attachment_id ON attachment(id);
@@ -0,0 +1,25 @@
+-- name: CreateAccount :one
+INSERT INTO
+    account (email, password_hash, created)
+VALUES
+    (?, ?, ?) RETURNING *;
+
+-- name: GetAccount :one
+SELECT
+    *
+FROM
+    account
+WHERE
+    id = ?
+LIMIT
+    1;
+
+-- name: GetAccountByEmail :one
+SELECT
+    *
+FROM
+    account
+WHERE
+    email = ?
+LIMIT
+    1;
@@ -0,0 +1,51 @@
+-- name: CreateAttachment :exec
+INSERT into
+    attachment(
+        id,
+        email_id,
+        content_id,
+        filename,
+        content_type,
+        inline,
+        size,
+        data
+        )
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (email_id, id) DO NOTHING;
+
+-- name: GetAttachment :one
+SELECT
+    *
+FROM
+    attachment
+WHERE
+    id = ?
+LIMIT
+    1;
+
+-- name: ListFeedAttachments :many
+SELECT
+    feed_item.id AS item_id,
+    attachment.id,
+    attachment.filename,
+    attachment.content_type,
+    attachment.size
+FROM
+    attachment
+    JOIN feed_item ON feed_item.email_id = attachment.email_id
+WHERE
+    feed_item.feed_id = ?
+    AND NOT attachment.inline
+ORDER BY
+    attachment.rowid;
+
+-- name: ListAttachmentFeeds :many
+SELECT DISTINCT
+    feed.*
+FROM
+    feed
+    JOIN feed_item ON feed_item.feed_id = feed.id
+    JOIN attachment ON attachment.email_id = feed_item.email_id
+WHERE
+    attachment.id = ?;
@@ -8,6 +8,17 @@ WHERE
 LIMIT
     1;
 
+-- name: HasEmailWithMessageID :one
+SELECT
+    EXISTS (
+        SELECT
+            1
+        FROM
+            email
+        WHERE
+            message_id = ?
+    );
+
 -- name: ListEmails :many
 SELECT
     *
@@ -19,13 +30,77 @@ ORDER BY
 -- name: CreateEmail :one
 INSERT INTO
     email (
-        id,
         date,
         recipient,
         sender,
         subject,
-        description
+        description,
+        uid,
+        undeliverable,
+        raw,
+        message_id,
+        dkim,
+        dkim_aligned,
+        spf,
+        dmarc
     )
 VALUES
-    (?, ?, ?, ?, ?, ?) RETURNING *;
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: ListRawEmails :many
+SELECT
+    *
+FROM
+    email
+WHERE
+    raw IS NOT NULL
+ORDER BY
+    id;
+
+-- name: ListRawEmailsForFeed :many
+SELECT
+    *
+FROM
+    email
+WHERE
+    raw IS NOT NULL
+    AND id IN (
+        SELECT
+            email_id
+        FROM
+            feed_item
+        WHERE
+            feed_id = ?
+    )
+ORDER BY
+    id;
+
+-- name: UpdateEmailDescription :exec
+UPDATE
+    email
+SET
+    description = ?
+WHERE
+    id = ?;
 
+-- name: DeleteUnusedEmail :exec
+DELETE FROM
+    email
+WHERE
+    id = ?
+    AND NOT EXISTS (
+        SELECT
+            1
+        FROM
+            feed_item
+        WHERE
+            feed_item.email_id = email.id
+    )
+    AND NOT EXISTS (
+        SELECT
+            1
+        FROM
+            quarantine
+        WHERE
+            quarantine.email_id = email.id
+    );
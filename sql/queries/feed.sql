@@ -1,8 +1,14 @@
 -- name: CreateFeed :one
 INSERT into
-    feed (id, name)
+    feed (id, name, token_hash, private, read_token_hash, account_id)
 VALUES
-    (?, ?) RETURNING *;
+    (?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: DeleteFeed :execrows
+DELETE FROM
+    feed
+WHERE
+    id = ?;
 
 -- name: GetFeed :one
 SELECT
@@ -14,8 +20,127 @@ where
 limit
     1;
 
+-- name: GetFeedByAddress :one
+SELECT
+    *
+FROM
+    feed
+WHERE
+    coalesce(nullif(address, ''), id) = ?
+LIMIT
+    1;
+
+-- name: GetFeedSummary :one
+SELECT
+    feed.id,
+    feed.name,
+    coalesce(nullif(feed.address, ''), feed.id) AS address,
+    feed.private,
+    feed.locked,
+    feed.sender_limit,
+    feed.require_dkim,
+    count(feed_item.id) AS item_count,
+    max(feed_item.date) AS last_received
+FROM
+    feed
+    LEFT JOIN feed_item ON feed_item.feed_id = feed.id
+WHERE
+    feed.id = ?
+GROUP BY
+    feed.id;
+
+-- name: ListAccountFeedSummaries :many
+SELECT
+    feed.id,
+    feed.name,
+    coalesce(nullif(feed.address, ''), feed.id) AS address,
+    feed.private,
+    feed.locked,
+    feed.sender_limit,
+    feed.require_dkim,
+    count(feed_item.id) AS item_count,
+    max(feed_item.date) AS last_received
+FROM
+    feed
+    LEFT JOIN feed_item ON feed_item.feed_id = feed.id
+WHERE
+    feed.account_id = ?
+GROUP BY
+    feed.id
+ORDER BY
+    feed.rowid;
+
+-- name: ListFeedSummaries :many
+SELECT
+    feed.id,
+    feed.name,
+    coalesce(nullif(feed.address, ''), feed.id) AS address,
+    feed.private,
+    feed.locked,
+    feed.sender_limit,
+    feed.require_dkim,
+    count(feed_item.id) AS item_count,
+    max(feed_item.date) AS last_received
+FROM
+    feed
+    LEFT JOIN feed_item ON feed_item.feed_id = feed.id
+GROUP BY
+    feed.id
+ORDER BY
+    feed.rowid;
+
 -- name: ListFeeds :many
 SELECT
     *
 FROM
-    feed;
\ No newline at end of file
+    feed;
+
+-- name: RenameFeed :execrows
+UPDATE
+    feed
+SET
+    name = ?
+WHERE
+    id = ?;
+
+-- name: SetFeedLock :exec
+UPDATE
+    feed
+SET
+    locked = ?,
+    sender_limit = ?
+WHERE
+    id = ?;
+
+-- name: SetFeedRequireDKIM :exec
+UPDATE
+    feed
+SET
+    require_dkim = ?
+WHERE
+    id = ?;
+
+-- name: SetFeedAccount :exec
+UPDATE
+    feed
+SET
+    account_id = ?
+WHERE
+    id = ?;
+
+-- name: UpdateFeedAddress :exec
+UPDATE
+    feed
+SET
+    address = ?
+WHERE
+    id = ?;
+
+-- name: UpdateFeedPrivacy :exec
+UPDATE
+    feed
+SET
+    private = ?,
+    read_token_hash = ?
+WHERE
+    id = ?;
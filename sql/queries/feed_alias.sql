@@ -0,0 +1,30 @@
+-- name: CreateFeedAlias :one
+INSERT into
+    feed_alias (alias, feed_id)
+VALUES
+    (?, ?) RETURNING *;
+
+-- name: GetFeedAlias :one
+SELECT
+    *
+FROM
+    feed_alias
+where
+    alias = ?
+limit
+    1;
+
+-- name: ListFeedAliases :many
+SELECT
+    *
+FROM
+    feed_alias
+WHERE
+    feed_id = ?;
+
+-- name: CreateDisabledFeedAlias :exec
+INSERT into
+    feed_alias (alias, feed_id, disabled)
+VALUES
+    (?, ?, TRUE)
+ON CONFLICT (alias) DO NOTHING;
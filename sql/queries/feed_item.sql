@@ -6,10 +6,16 @@ INSERT into
         feed_id,
         subject,
         body,
-        date
+        date,
+        category,
+        email_id,
+        message_id,
+        confirm_url,
+        renamed
         )
 VALUES
-    (?, ?, ?,?,?,?) RETURNING *;
+    (?, ?, ?,?,?,?,?,?,?,?,?)
+ON CONFLICT (feed_id, message_id) WHERE message_id != '' DO NOTHING RETURNING *;
 
 -- name: GetFeedItem :one
 SELECT
@@ -29,3 +35,53 @@ FROM
 WHERE
     feed_id = ?;
 
+-- name: ListRecentFeedItems :many
+SELECT
+    *
+FROM
+    feed_item
+WHERE
+    feed_id = ?
+ORDER BY
+    date DESC,
+    rowid DESC
+LIMIT
+    ?;
+
+-- name: UpdateFeedItemsForEmail :exec
+UPDATE
+    feed_item
+SET
+    subject = CASE
+        WHEN renamed THEN subject
+        ELSE ?
+    END,
+    body = ?,
+    date = ?,
+    confirm_url = ?
+WHERE
+    email_id = ?;
+
+-- name: ConfirmFeedItemsForEmail :exec
+UPDATE
+    feed_item
+SET
+    confirmed = TRUE
+WHERE
+    email_id = ?;
+
+-- name: ListFeedEmailIDs :many
+SELECT
+    email_id
+FROM
+    feed_item
+WHERE
+    feed_id = ?1
+    AND email_id IS NOT NULL
+UNION
+SELECT
+    email_id
+FROM
+    quarantine
+WHERE
+    feed_id = ?1;
@@ -0,0 +1,36 @@
+-- name: CreateFilterRule :one
+INSERT INTO
+    filter_rule (feed_id, field, header, pattern, action, value, target_feed_id)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?) RETURNING *;
+
+-- name: DeleteFilterRule :execrows
+DELETE FROM
+    filter_rule
+WHERE
+    id = ?
+    AND feed_id = ?;
+
+-- name: ListFilterRules :many
+SELECT
+    *
+FROM
+    filter_rule
+WHERE
+    feed_id = ?
+ORDER BY
+    id;
+
+-- name: UpdateFilterRule :one
+UPDATE
+    filter_rule
+SET
+    field = ?,
+    header = ?,
+    pattern = ?,
+    action = ?,
+    value = ?,
+    target_feed_id = ?
+WHERE
+    id = ?
+    AND feed_id = ? RETURNING *;
@@ -0,0 +1,19 @@
+-- name: GetMailbox :one
+SELECT
+    *
+FROM
+    mailbox
+WHERE
+    name = ?
+LIMIT
+    1;
+
+-- name: UpsertMailbox :exec
+INSERT INTO
+    mailbox (name, uid_validity, last_uid)
+VALUES
+    (?, ?, ?) ON CONFLICT(name) DO
+UPDATE
+SET
+    uid_validity = excluded.uid_validity,
+    last_uid = excluded.last_uid;
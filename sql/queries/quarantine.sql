@@ -0,0 +1,37 @@
+-- name: CreateQuarantine :one
+INSERT INTO
+    quarantine (feed_id, email_id, sender, category, reason, message_id)
+VALUES
+    (?, ?, ?, ?, ?, ?) ON CONFLICT (feed_id, message_id) WHERE message_id != '' DO NOTHING RETURNING *;
+
+-- name: DeleteQuarantine :exec
+DELETE FROM
+    quarantine
+WHERE
+    id = ?;
+
+-- name: GetQuarantine :one
+SELECT
+    *
+FROM
+    quarantine
+WHERE
+    id = ?
+    AND feed_id = ?
+LIMIT
+    1;
+
+-- name: ListQuarantine :many
+SELECT
+    quarantine.id,
+    quarantine.sender,
+    quarantine.reason,
+    email.subject,
+    email.date
+FROM
+    quarantine
+    JOIN email ON email.id = quarantine.email_id
+WHERE
+    quarantine.feed_id = ?
+ORDER BY
+    quarantine.id;
@@ -0,0 +1,35 @@
+-- name: CountAutoSenderRules :one
+SELECT
+    count(*)
+FROM
+    sender_rule
+WHERE
+    feed_id = ?
+    AND auto;
+
+-- name: CreateSenderRule :one
+INSERT INTO
+    sender_rule (feed_id, pattern, action, auto)
+VALUES
+    (?, ?, ?, ?) ON CONFLICT (feed_id, pattern) DO
+UPDATE
+SET
+    action = excluded.action,
+    auto = excluded.auto RETURNING *;
+
+-- name: DeleteSenderRule :execrows
+DELETE FROM
+    sender_rule
+WHERE
+    id = ?
+    AND feed_id = ?;
+
+-- name: ListSenderRules :many
+SELECT
+    *
+FROM
+    sender_rule
+WHERE
+    feed_id = ?
+ORDER BY
+    id;
@@ -0,0 +1,32 @@
+-- name: CreateSession :exec
+INSERT INTO
+    session (id, account_id, expires)
+VALUES
+    (?, ?, ?);
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM
+    session
+WHERE
+    expires <= ?;
+
+-- name: DeleteSession :exec
+DELETE FROM
+    session
+WHERE
+    id = ?;
+
+-- name: GetSessionAccount :one
+SELECT
+    account.id,
+    account.email,
+    account.password_hash,
+    account.created
+FROM
+    session
+    JOIN account ON account.id = session.account_id
+WHERE
+    session.id = ?
+    AND session.expires > ?
+LIMIT
+    1;
@@ -0,0 +1,49 @@
+-- name: GetSubscription :one
+SELECT
+    *
+FROM
+    subscription
+WHERE
+    id = ?
+    AND feed_id = ?
+LIMIT
+    1;
+
+-- name: ListSubscriptions :many
+SELECT
+    *
+FROM
+    subscription
+WHERE
+    feed_id = ?
+ORDER BY
+    sender;
+
+-- name: SetSubscriptionUnsubscribed :exec
+UPDATE
+    subscription
+SET
+    unsubscribed = ?
+WHERE
+    id = ?;
+
+-- name: UpsertSubscription :exec
+INSERT INTO
+    subscription (
+        feed_id,
+        sender,
+        unsubscribe_url,
+        unsubscribe_mailto,
+        one_click,
+        last_received
+    )
+VALUES
+    (?, ?, ?, ?, ?, ?) ON CONFLICT (feed_id, sender) DO
+UPDATE
+SET
+    unsubscribe_url = excluded.unsubscribe_url,
+    unsubscribe_mailto = excluded.unsubscribe_mailto,
+    one_click = excluded.one_click,
+    last_received = excluded.last_received
+WHERE
+    excluded.last_received >= subscription.last_received;